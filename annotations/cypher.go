@@ -1,10 +1,10 @@
 package annotations
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"regexp"
 	"time"
 
 	"github.com/Financial-Times/neo-model-utils-go/mapper"
@@ -12,7 +12,7 @@ import (
 	"github.com/jmcvetta/neoism"
 )
 
-var uuidExtractRegex = regexp.MustCompile(".*/([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})$")
+var defaultIDResolver = NewSchemeResolver()
 
 var UnsupportedPredicateErr = errors.New("Unsupported predicate")
 
@@ -23,17 +23,39 @@ var UnsupportedPredicateErr = errors.New("Unsupported predicate")
 // TODO - move to implement a shared defined Service interface?
 type Service interface {
 	Write(contentUUID string, annotationLifecycle string, platformVersion string, tid string, thing interface{}) (err error)
-	Read(contentUUID string, tid string, annotationLifecycle string) (thing interface{}, found bool, err error)
+	Read(contentUUID string, tid string, annotationLifecycle string, filter ReadFilter) (thing interface{}, found bool, err error)
 	Delete(contentUUID string, tid string, annotationLifecycle string) (found bool, err error)
 	Check() (err error)
+	CheckReadable() (err error)
 	DecodeJSON(*json.Decoder) (thing interface{}, err error)
 	Count(annotationLifecycle string, platformVersion string) (int, error)
+	Export(annotationLifecycle string, handle func(ContentAnnotations) error) error
 	Initialise() error
 }
 
 //holds the Neo4j-specific information
 type service struct {
-	conn neoutils.NeoConnection
+	conn                neoutils.NeoConnection
+	idResolver          IDResolver
+	equivalenceResolver EquivalenceResolver
+}
+
+// ServiceOption configures optional behaviour of the Neo4j annotations service
+type ServiceOption func(*service)
+
+// WithIDResolver sets the resolver used to turn the concept ids supplied on write into concept UUIDs
+func WithIDResolver(r IDResolver) ServiceOption {
+	return func(s *service) {
+		s.idResolver = r
+	}
+}
+
+// WithEquivalenceResolver makes Write annotate the canonical concept instead of the one supplied, keeping the
+// supplied concept UUID in the sourceConceptId property of the annotation
+func WithEquivalenceResolver(r EquivalenceResolver) ServiceOption {
+	return func(s *service) {
+		s.equivalenceResolver = r
+	}
 }
 
 const (
@@ -42,8 +64,37 @@ const (
 )
 
 //NewCypherAnnotationsService instantiate driver
-func NewCypherAnnotationsService(cypherRunner neoutils.NeoConnection) service {
-	return service{cypherRunner}
+func NewCypherAnnotationsService(cypherRunner neoutils.NeoConnection, opts ...ServiceOption) service {
+	s := service{conn: cypherRunner, idResolver: defaultIDResolver}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return s
+}
+
+// ContextConnection is a Neo4j connection that can run its queries as part of the context of the request or message
+// they are made for, for instance to trace them
+type ContextConnection interface {
+	neoutils.NeoConnection
+	WithContext(ctx context.Context) neoutils.NeoConnection
+}
+
+// WithContext returns the service running its queries as part of ctx. Services that cannot are returned as they are.
+func WithContext(ctx context.Context, s Service) Service {
+	if c, ok := s.(interface {
+		WithContext(ctx context.Context) Service
+	}); ok {
+		return c.WithContext(ctx)
+	}
+	return s
+}
+
+// WithContext returns the service running its queries as part of ctx, when its connection is a ContextConnection
+func (s service) WithContext(ctx context.Context) Service {
+	if c, ok := s.conn.(ContextConnection); ok {
+		s.conn = c.WithContext(ctx)
+	}
+	return s
 }
 
 // DecodeJSON decodes to a list of annotations, for ease of use this is a struct itself
@@ -53,25 +104,35 @@ func (s service) DecodeJSON(dec *json.Decoder) (interface{}, error) {
 	return a, err
 }
 
-func (s service) Read(contentUUID string, tid string, annotationLifecycle string) (thing interface{}, found bool, err error) {
+// Read returns the annotations of the content for the lifecycle that match the filter
+func (s service) Read(contentUUID string, tid string, annotationLifecycle string, filter ReadFilter) (thing interface{}, found bool, err error) {
 	results := []Annotation{}
+	whereClause, params, err := filter.whereClause(s.idResolver)
+	if err != nil {
+		return Annotations{}, false, err
+	}
+
 	//TODO shouldn't return Provenances if none of the scores, agentRole or atTime are set
 	statementTemplate := `
 			MATCH (c:Thing{uuid:{contentUUID}})-[rel{lifecycle:{annotationLifecycle}}]->(cc:Thing)
+			%s
 			WITH c, cc, rel, {id:cc.uuid,prefLabel:cc.prefLabel,types:labels(cc),predicate:type(rel)} as thing,
 			collect(
-				{scores:[
+				{scores:[score IN [
 					{scoringSystem:'%s', value:rel.relevanceScore},
-					{scoringSystem:'%s', value:rel.confidenceScore}],
+					{scoringSystem:'%s', value:rel.confidenceScore}] WHERE score.value IS NOT NULL],
 				agentRole:rel.annotatedBy,
-				atTime:rel.annotatedDate}) as provenances
+				atTime:rel.annotatedDate,
+				sourceConceptId:rel.sourceConceptId}) as provenances
 			RETURN thing, provenances ORDER BY thing.id`
 
-	statement := fmt.Sprintf(statementTemplate, relevanceScoringSystem, confidenceScoringSystem)
+	statement := fmt.Sprintf(statementTemplate, whereClause, relevanceScoringSystem, confidenceScoringSystem)
 
+	params["contentUUID"] = contentUUID
+	params["annotationLifecycle"] = annotationLifecycle
 	query := &neoism.CypherQuery{
 		Statement:  statement,
-		Parameters: neoism.Props{"contentUUID": contentUUID, "annotationLifecycle": annotationLifecycle},
+		Parameters: params,
 		Result:     &results,
 	}
 	if err := s.conn.CypherBatch([]*neoism.CypherQuery{query}); err != nil {
@@ -122,14 +183,30 @@ func (s service) Write(contentUUID string, annotationLifecycle string, platformV
 		return err
 	}
 
+	annotationsToWrite, err := canonicaliseConceptIDs(s.idResolver, annotationsToWrite)
+	if err != nil {
+		return err
+	}
+
+	sourceConcepts, err := s.resolveEquivalentConcepts(annotationsToWrite)
+	if err != nil {
+		return err
+	}
+
 	queries := append([]*neoism.CypherQuery{}, buildDeleteQuery(contentUUID, annotationLifecycle, false))
 
 	var statements []string
-	for _, annotationToWrite := range annotationsToWrite {
-		query, err := createAnnotationQuery(contentUUID, annotationToWrite, platformVersion, annotationLifecycle)
+	for idx, annotationToWrite := range annotationsToWrite {
+		query, err := createAnnotationQuery(s.idResolver, contentUUID, annotationToWrite, platformVersion, annotationLifecycle)
+		if _, ok := err.(ValidationError); ok {
+			return err
+		}
 		if err != nil {
 			return fmt.Errorf("create annotation query failed: %w", err)
 		}
+		if sourceUUID, found := sourceConcepts[idx]; found {
+			query.Parameters["annProps"].(map[string]interface{})["sourceConceptId"] = sourceUUID
+		}
 		statements = append(statements, query.Statement)
 		queries = append(queries, query)
 	}
@@ -150,6 +227,11 @@ func (s service) Check() error {
 	return neoutils.Check(s.conn)
 }
 
+// CheckReadable tests neo4j by running a simple cypher query, without requiring the instance to accept writes
+func (s service) CheckReadable() error {
+	return neoutils.Check(s.conn)
+}
+
 func (s service) Count(annotationLifecycle string, platformVersion string) (int, error) {
 	var results []struct {
 		Count int `json:"c"`
@@ -202,9 +284,9 @@ func getRelationshipFromPredicate(predicate string) (string, error) {
 	return r, nil
 }
 
-func createAnnotationQuery(contentUUID string, ann Annotation, platformVersion string, annotationLifecycle string) (*neoism.CypherQuery, error) {
+func createAnnotationQuery(r IDResolver, contentUUID string, ann Annotation, platformVersion string, annotationLifecycle string) (*neoism.CypherQuery, error) {
 	query := neoism.CypherQuery{}
-	thingID, err := extractUUIDFromURI(ann.Thing.ID)
+	thingID, err := r.ResolveUUID(ann.Thing.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -221,7 +303,7 @@ func createAnnotationQuery(contentUUID string, ann Annotation, platformVersion s
 
 	if len(ann.Provenances) >= 1 {
 		prov = ann.Provenances[0]
-		annotatedBy, annotatedDateEpoch, relevanceScore, confidenceScore, supplied, err := extractDataFromProvenance(&prov)
+		annotatedBy, annotatedDateEpoch, relevanceScore, confidenceScore, supplied, err := extractDataFromProvenance(r, &prov)
 
 		if err != nil {
 			return nil, err
@@ -255,34 +337,76 @@ func createAnnotationQuery(contentUUID string, ann Annotation, platformVersion s
 	return &query, nil
 }
 
-func extractDataFromProvenance(prov *Provenance) (string, int64, float64, float64, bool, error) {
+func extractDataFromProvenance(r IDResolver, prov *Provenance) (string, int64, float64, float64, bool, error) {
 	if len(prov.Scores) == 0 {
 		return "", -1, -1, -1, false, nil
 	}
 	var annotatedBy string
 	var annotatedDateEpoch int64
-	var confidenceScore, relevanceScore float64
 	var err error
 	if prov.AgentRole != "" {
-		annotatedBy, err = extractUUIDFromURI(prov.AgentRole)
+		if annotatedBy, err = r.ResolveUUID(prov.AgentRole); err != nil {
+			return "", -1, -1, -1, true, ValidationError{fmt.Sprintf("invalid agentRole %s", prov.AgentRole)}
+		}
 	}
 	if prov.AtTime != "" {
-		annotatedDateEpoch, err = convertAnnotatedDateToEpoch(prov.AtTime)
+		if annotatedDateEpoch, err = convertAnnotatedDateToEpoch(prov.AtTime); err != nil {
+			return "", -1, -1, -1, true, ValidationError{fmt.Sprintf("invalid atTime %s", prov.AtTime)}
+		}
 	}
-	relevanceScore, confidenceScore, err = extractScores(prov.Scores)
-
+	relevanceScore, confidenceScore, err := extractScores(prov.Scores)
 	if err != nil {
 		return "", -1, -1, -1, true, err
 	}
 	return annotatedBy, annotatedDateEpoch, relevanceScore, confidenceScore, true, nil
 }
 
-func extractUUIDFromURI(uri string) (string, error) {
-	result := uuidExtractRegex.FindStringSubmatch(uri)
-	if len(result) == 2 {
-		return result[1], nil
+// canonicaliseConceptIDs returns a copy of the annotations with every concept id replaced by its canonical URI,
+// leaving the caller's annotations untouched as they are still forwarded in the form they were received
+func canonicaliseConceptIDs(r IDResolver, anns Annotations) (Annotations, error) {
+	result := make(Annotations, len(anns))
+	for idx, ann := range anns {
+		id, err := CanonicalID(r, ann.Thing.ID)
+		if err != nil {
+			return nil, ValidationError{err.Error()}
+		}
+		ann.Thing.ID = id
+		result[idx] = ann
+	}
+	return result, nil
+}
+
+// resolveEquivalentConcepts points each annotation at its canonical concept, when an equivalence resolver is configured.
+// It returns the original concept UUID of every annotation that was changed, keyed by the annotation's index.
+func (s service) resolveEquivalentConcepts(anns Annotations) (map[int]string, error) {
+	if s.equivalenceResolver == nil || len(anns) == 0 {
+		return nil, nil
 	}
-	return "", fmt.Errorf("couldn't extract uuid from uri %s", uri)
+
+	uuids := make([]string, len(anns))
+	for idx, ann := range anns {
+		uuid, err := s.idResolver.ResolveUUID(ann.Thing.ID)
+		if err != nil {
+			return nil, err
+		}
+		uuids[idx] = uuid
+	}
+
+	canonicalUUIDs, err := s.equivalenceResolver.CanonicalUUIDs(uuids)
+	if err != nil {
+		return nil, fmt.Errorf("resolving canonical concepts failed: %w", err)
+	}
+
+	sourceConcepts := map[int]string{}
+	for idx, uuid := range uuids {
+		canonicalUUID, found := canonicalUUIDs[uuid]
+		if !found || canonicalUUID == "" || canonicalUUID == uuid {
+			continue
+		}
+		anns[idx].Thing.ID = mapper.IDURL(canonicalUUID)
+		sourceConcepts[idx] = uuid
+	}
+	return sourceConcepts, nil
 }
 
 func convertAnnotatedDateToEpoch(annotatedDateString string) (int64, error) {
@@ -342,10 +466,14 @@ func (v ValidationError) Error() string {
 
 func mapToResponseFormat(ann *Annotation) {
 	ann.Thing.ID = mapper.IDURL(ann.Thing.ID)
+	ann.Thing.Types = typeURIsFromLabels(ann.Thing.Types)
 	// We expect only ONE provenance - provenance value is considered valid even if the AgentRole is not specified. See: v1 - isClassifiedBy
 	for idx := range ann.Provenances {
 		if ann.Provenances[idx].AgentRole != "" {
 			ann.Provenances[idx].AgentRole = mapper.IDURL(ann.Provenances[idx].AgentRole)
 		}
+		if ann.Provenances[idx].SourceConceptID != "" {
+			ann.Provenances[idx].SourceConceptID = mapper.IDURL(ann.Provenances[idx].SourceConceptID)
+		}
 	}
 }
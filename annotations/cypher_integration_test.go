@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/Financial-Times/go-logger"
 	"github.com/Financial-Times/neo-utils-go/neoutils"
@@ -127,7 +128,7 @@ func TestDeleteRemovesAnnotationsButNotConceptsOrContent(t *testing.T) {
 	assert.True(deleted, "Didn't manage to delete annotations for content uuid %s: %s", contentUUID, err)
 	assert.NoError(err, "Error deleting annotation for content uuid %, conceptUUID %s", contentUUID, conceptUUID)
 
-	anns, found, err := annotationsService.Read(contentUUID, tid, v2AnnotationLifecycle)
+	anns, found, err := annotationsService.Read(contentUUID, tid, v2AnnotationLifecycle, ReadFilter{})
 
 	assert.Equal(Annotations{}, anns, "Found annotation for content %s when it should have been deleted", contentUUID)
 	assert.False(found, "Found annotation for content %s when it should have been deleted", contentUUID)
@@ -491,7 +492,7 @@ func getNeoConnection(t *testing.T) neoutils.NeoConnection {
 func readAnnotationsForContentUUIDAndCheckKeyFieldsMatch(t *testing.T, contentUUID string, annotationLifecycle string, expectedAnnotations []Annotation) {
 	assert := assert.New(t)
 	logger.InitDefaultLogger("annotations-rw")
-	storedThings, found, err := annotationsService.Read(contentUUID, tid, annotationLifecycle)
+	storedThings, found, err := annotationsService.Read(contentUUID, tid, annotationLifecycle, ReadFilter{})
 	storedAnnotations := storedThings.(Annotations)
 
 	assert.NoError(err, "Error finding annotations for contentUUID %s", contentUUID)
@@ -637,3 +638,174 @@ func deleteNode(conn neoutils.NeoConnection, uuid string) error {
 func exampleConcepts(uuid string) Annotations {
 	return Annotations{exampleConcept(uuid)}
 }
+
+func TestWriteResolvesEquivalentConceptsToTheCanonicalNode(t *testing.T) {
+	assert := assert.New(t)
+	conn := getNeoConnection(t)
+	defer cleanDB(t, assert)
+	defer func() {
+		assert.NoError(conn.CypherBatch([]*neoism.CypherQuery{{
+			Statement:  "MATCH (canonical:Thing {prefUUID: {prefUUID}}) DETACH DELETE canonical",
+			Parameters: neoism.Props{"prefUUID": conceptUUID},
+		}}))
+	}()
+
+	// the concepts writers point every source concept at a canonical node holding the UUID of the preferred source
+	assert.NoError(conn.CypherBatch([]*neoism.CypherQuery{{
+		Statement: `MERGE (canonical:Thing {prefUUID: {prefUUID}})
+				MERGE (preferred:Thing {uuid: {prefUUID}})
+				MERGE (source:Thing {uuid: {sourceUUID}})
+				MERGE (preferred)-[:EQUIVALENT_TO]->(canonical)
+				MERGE (source)-[:EQUIVALENT_TO]->(canonical)`,
+		Parameters: neoism.Props{"prefUUID": conceptUUID, "sourceUUID": secondConceptUUID},
+	}}), "Error setting up the concepts")
+
+	annotationsService = NewCypherAnnotationsService(conn, WithEquivalenceResolver(NewNeoEquivalenceResolver(conn)))
+	assert.NoError(annotationsService.Write(contentUUID, v2AnnotationLifecycle, v2PlatformVersion, tid, exampleConcepts(secondConceptUUID)), "Failed to write annotation")
+
+	var results []struct {
+		UUID            string `json:"uuid"`
+		SourceConceptID string `json:"sourceConceptId"`
+	}
+	assert.NoError(conn.CypherBatch([]*neoism.CypherQuery{{
+		Statement: `MATCH (:Thing {uuid: {contentUUID}})-[rel {lifecycle: {lifecycle}}]->(concept:Thing)
+				RETURN concept.uuid AS uuid, rel.sourceConceptId AS sourceConceptId`,
+		Parameters: neoism.Props{"contentUUID": contentUUID, "lifecycle": v2AnnotationLifecycle},
+		Result:     &results,
+	}}))
+	if assert.Len(results, 1, "The annotation should only be written against the canonical concept") {
+		assert.Equal(conceptUUID, results[0].UUID)
+		assert.Equal(secondConceptUUID, results[0].SourceConceptID)
+	}
+
+	stored, found, err := annotationsService.Read(contentUUID, tid, v2AnnotationLifecycle, ReadFilter{})
+	assert.NoError(err)
+	assert.True(found)
+	anns := stored.(Annotations)
+	assert.Equal(getURI(conceptUUID), anns[0].Thing.ID)
+	assert.Equal(getURI(secondConceptUUID), anns[0].Provenances[0].SourceConceptID)
+}
+
+func TestReadFilters(t *testing.T) {
+	conn := getNeoConnection(t)
+	defer cleanDB(t, assert.New(t))
+	annotationsService = NewCypherAnnotationsService(conn)
+
+	assert.NoError(t, conn.CypherBatch([]*neoism.CypherQuery{{
+		Statement:  "MERGE (o:Thing {uuid: {uuid}}) SET o:Concept:Organisation",
+		Parameters: neoism.Props{"uuid": secondConceptUUID},
+	}}), "Error setting up the organisation")
+
+	firstAgent := "http://api.ft.com/things/0edd3c31-1fd0-4ef6-9230-8d545be3880a"
+	secondAgent := "http://api.ft.com/things/1ad6d0bd-1d60-4bd8-bd6c-5c6f4e3a2b5e"
+	anns := Annotations{
+		{
+			Thing: Thing{ID: getURI(conceptUUID), Predicate: "about"},
+			Provenances: []Provenance{{
+				Scores:    []Score{{ScoringSystem: relevanceScoringSystem, Value: 0.9}, {ScoringSystem: confidenceScoringSystem, Value: 0.8}},
+				AgentRole: firstAgent,
+				AtTime:    "2016-01-01T19:43:47.314Z",
+			}},
+		},
+		{
+			Thing: Thing{ID: getURI(secondConceptUUID), Predicate: "mentions"},
+			Provenances: []Provenance{{
+				Scores:    []Score{{ScoringSystem: relevanceScoringSystem, Value: 0.4}, {ScoringSystem: confidenceScoringSystem, Value: 0.5}},
+				AgentRole: secondAgent,
+				AtTime:    "2018-06-01T00:00:00Z",
+			}},
+		},
+	}
+	assert.NoError(t, annotationsService.Write(contentUUID, v2AnnotationLifecycle, v2PlatformVersion, tid, anns), "Failed to write annotations")
+
+	half := 0.5
+	sixty := 0.6
+	threshold := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		filter   ReadFilter
+		expected []string
+	}{
+		{"predicate", ReadFilter{Predicates: []string{"about"}}, []string{conceptUUID}},
+		{"type", ReadFilter{Types: []string{"http://www.ft.com/ontology/organisation/Organisation"}}, []string{secondConceptUUID}},
+		{"agent role", ReadFilter{AgentRoles: []string{secondAgent}}, []string{secondConceptUUID}},
+		{"minimum relevance", ReadFilter{MinRelevance: &half}, []string{conceptUUID}},
+		{"minimum confidence", ReadFilter{MinConfidence: &sixty}, []string{conceptUUID}},
+		{"annotated after", ReadFilter{AnnotatedAfter: threshold}, []string{secondConceptUUID}},
+		{"annotated before", ReadFilter{AnnotatedBefore: threshold}, []string{conceptUUID}},
+		{"several values of a field", ReadFilter{Predicates: []string{"about", "mentions"}}, []string{conceptUUID, secondConceptUUID}},
+		{"several fields", ReadFilter{Predicates: []string{"mentions"}, MinRelevance: &half}, nil},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			stored, found, err := annotationsService.Read(contentUUID, tid, v2AnnotationLifecycle, test.filter)
+			assert.NoError(t, err)
+			assert.Equal(t, len(test.expected) > 0, found)
+			var ids []string
+			for _, ann := range stored.(Annotations) {
+				ids = append(ids, ann.Thing.ID)
+			}
+			var expected []string
+			for _, uuid := range test.expected {
+				expected = append(expected, getURI(uuid))
+			}
+			assert.Equal(t, expected, ids)
+		})
+	}
+}
+
+func TestReadLeavesOutScoresThatWereNotWritten(t *testing.T) {
+	assert := assert.New(t)
+	conn := getNeoConnection(t)
+	defer cleanDB(t, assert)
+	annotationsService = NewCypherAnnotationsService(conn)
+
+	ann := exampleConcept(conceptUUID)
+	ann.Provenances = nil
+	assert.NoError(annotationsService.Write(contentUUID, v2AnnotationLifecycle, v2PlatformVersion, tid, Annotations{ann}), "Failed to write annotation")
+
+	stored, found, err := annotationsService.Read(contentUUID, tid, v2AnnotationLifecycle, ReadFilter{})
+	assert.NoError(err)
+	assert.True(found)
+	anns := stored.(Annotations)
+	if assert.Len(anns, 1) && assert.Len(anns[0].Provenances, 1) {
+		assert.Empty(anns[0].Provenances[0].Scores, "No scores were written, so none should be read")
+	}
+}
+
+func TestExportReadsEveryPage(t *testing.T) {
+	assert := assert.New(t)
+	conn := getNeoConnection(t)
+	annotationsService = NewCypherAnnotationsService(conn)
+
+	contentUUIDs := []string{
+		"0a5ef3d2-7a4e-4f0c-9a6b-0c1d2e3f4a01",
+		"0a5ef3d2-7a4e-4f0c-9a6b-0c1d2e3f4a02",
+		"0a5ef3d2-7a4e-4f0c-9a6b-0c1d2e3f4a03",
+	}
+	defer cleanDB(t, assert)
+	for _, uuid := range contentUUIDs {
+		defer func(uuid string) {
+			assert.NoError(deleteNode(conn, uuid), "Could not delete content node")
+		}(uuid)
+		assert.NoError(annotationsService.Write(uuid, v2AnnotationLifecycle, v2PlatformVersion, tid, exampleConcepts(conceptUUID)), "Failed to write annotation")
+	}
+
+	defer func(pageSize int) { exportPageSize = pageSize }(exportPageSize)
+	exportPageSize = 2
+
+	exported := map[string]int{}
+	var order []string
+	err := annotationsService.Export(v2AnnotationLifecycle, func(content ContentAnnotations) error {
+		exported[content.ContentUUID]++
+		order = append(order, content.ContentUUID)
+		return nil
+	})
+	assert.NoError(err)
+	for _, uuid := range contentUUIDs {
+		assert.Equal(1, exported[uuid], "Content %s should be exported exactly once", uuid)
+	}
+	for idx := 1; idx < len(order); idx++ {
+		assert.True(order[idx-1] < order[idx], "Content should be exported in UUID order")
+	}
+}
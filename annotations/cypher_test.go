@@ -1,10 +1,14 @@
 package annotations
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
 
 	"github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/neo-utils-go/neoutils"
+	"github.com/jmcvetta/neoism"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -21,12 +25,28 @@ func TestCreateAnnotationQuery(t *testing.T) {
 	logger.InitDefaultLogger("annotations-rw")
 	annotationToWrite := exampleConcept(oldConceptUUID)
 
-	query, err := createAnnotationQuery(contentUUID, annotationToWrite, v2PlatformVersion, v2AnnotationLifecycle)
+	query, err := createAnnotationQuery(defaultIDResolver, contentUUID, annotationToWrite, v2PlatformVersion, v2AnnotationLifecycle)
 	assert.NoError(err, "Cypher query for creating annotations couldn't be created.")
 	params := query.Parameters["annProps"].(map[string]interface{})
 	assert.Equal(v2PlatformVersion, params["platformVersion"], fmt.Sprintf("\nExpected: %s\nActual: %s", v2PlatformVersion, params["platformVersion"]))
 }
 
+func TestCreateAnnotationQueryUsesResolver(t *testing.T) {
+	annotationToWrite := exampleConcept(oldConceptUUID)
+	annotationToWrite.Provenances = []Provenance{{
+		Scores:    []Score{{ScoringSystem: relevanceScoringSystem, Value: 0.9}},
+		AgentRole: "tme:Mjk0NTA0OTQtMDc5Mi00MzVjLThlZTEtZGMxMzM4YzEzNjkz-VG9waWNz",
+	}}
+
+	_, err := createAnnotationQuery(defaultIDResolver, contentUUID, annotationToWrite, v2PlatformVersion, v2AnnotationLifecycle)
+	assert.IsType(t, ValidationError{}, err, "TME agent roles should be rejected unless the TME scheme is configured")
+
+	query, err := createAnnotationQuery(NewSchemeResolver(TMEScheme), contentUUID, annotationToWrite, v2PlatformVersion, v2AnnotationLifecycle)
+	assert.NoError(t, err)
+	assert.Equal(t, oldConceptUUID, query.Parameters["conceptID"])
+	assert.Equal(t, "6d58b99c-0e95-3437-8508-1f37fb60895c", query.Parameters["annProps"].(map[string]interface{})["annotatedBy"])
+}
+
 func TestCreateAnnotationQueryWithPredicate(t *testing.T) {
 	testCases := []struct {
 		name              string
@@ -90,7 +110,7 @@ func TestCreateAnnotationQueryWithPredicate(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			assert := assert.New(t)
 			logger.InitDefaultLogger("annotations-rw")
-			query, err := createAnnotationQuery(contentUUID, test.annotationToWrite, test.platformVersion, test.lifecycle)
+			query, err := createAnnotationQuery(defaultIDResolver, contentUUID, test.annotationToWrite, test.platformVersion, test.lifecycle)
 
 			assert.NoError(err, "Cypher query for creating annotations couldn't be created.")
 			assert.Contains(query.Statement, test.relationship, "Relationship name is not inserted!")
@@ -121,3 +141,82 @@ func TestGetRelationshipFromPredicate(t *testing.T) {
 		}
 	}
 }
+
+type recordingConn struct {
+	queries []*neoism.CypherQuery
+}
+
+func (c *recordingConn) CypherBatch(queries []*neoism.CypherQuery) error {
+	c.queries = append(c.queries, queries...)
+	return nil
+}
+
+func (c *recordingConn) EnsureConstraints(indexes map[string]string) error {
+	return nil
+}
+
+func (c *recordingConn) EnsureIndexes(indexes map[string]string) error {
+	return nil
+}
+
+func TestWriteResolvesEquivalentConcepts(t *testing.T) {
+	assert := assert.New(t)
+	conn := &recordingConn{}
+	resolver := EquivalenceResolverFunc(func(uuids []string) (map[string]string, error) {
+		assert.Equal([]string{oldConceptUUID, secondConceptUUID}, uuids)
+		return map[string]string{oldConceptUUID: conceptUUID}, nil
+	})
+	svc := NewCypherAnnotationsService(conn, WithEquivalenceResolver(resolver))
+
+	anns := Annotations{exampleConcept(oldConceptUUID), exampleConcept(secondConceptUUID)}
+	err := svc.Write(contentUUID, v2AnnotationLifecycle, v2PlatformVersion, "tid", anns)
+	assert.NoError(err)
+	assert.Len(conn.queries, 3)
+
+	resolved := conn.queries[1]
+	assert.Equal(conceptUUID, resolved.Parameters["conceptID"])
+	assert.Equal(oldConceptUUID, resolved.Parameters["annProps"].(map[string]interface{})["sourceConceptId"])
+
+	unresolved := conn.queries[2]
+	assert.Equal(secondConceptUUID, unresolved.Parameters["conceptID"])
+	assert.NotContains(unresolved.Parameters["annProps"], "sourceConceptId")
+
+	assert.Equal(getURI(oldConceptUUID), anns[0].Thing.ID, "Annotations passed to Write should not be modified")
+}
+
+func TestWriteFailsWhenEquivalenceLookupFails(t *testing.T) {
+	resolver := EquivalenceResolverFunc(func(uuids []string) (map[string]string, error) {
+		return nil, errors.New("lookup failed")
+	})
+	svc := NewCypherAnnotationsService(&recordingConn{}, WithEquivalenceResolver(resolver))
+
+	err := svc.Write(contentUUID, v2AnnotationLifecycle, v2PlatformVersion, "tid", Annotations{exampleConcept(oldConceptUUID)})
+	assert.EqualError(t, err, "resolving canonical concepts failed: lookup failed")
+}
+
+type contextKey struct{}
+
+// contextConn records the context its queries are run in
+type contextConn struct {
+	*recordingConn
+	ctx context.Context
+}
+
+func (c *contextConn) WithContext(ctx context.Context) neoutils.NeoConnection {
+	return &contextConn{recordingConn: c.recordingConn, ctx: ctx}
+}
+
+func TestWithContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), contextKey{}, "request")
+	conn := &contextConn{recordingConn: &recordingConn{}}
+	svc := WithContext(ctx, NewCypherAnnotationsService(conn))
+
+	bound, ok := svc.(service).conn.(*contextConn)
+	if assert.True(t, ok) {
+		assert.Equal(t, ctx, bound.ctx)
+	}
+	assert.Nil(t, conn.ctx, "The service should not be changed")
+
+	plain := NewCypherAnnotationsService(&recordingConn{})
+	assert.Equal(t, plain, WithContext(ctx, plain), "Connections without a context should be used as they are")
+}
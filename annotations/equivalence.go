@@ -0,0 +1,58 @@
+package annotations
+
+import (
+	"fmt"
+
+	"github.com/Financial-Times/neo-utils-go/neoutils"
+	"github.com/jmcvetta/neoism"
+)
+
+// EquivalenceResolver looks up the canonical (concorded) concept for a set of concept UUIDs.
+// The returned map only holds entries for UUIDs whose canonical UUID is known; UUIDs that are
+// missing from it are written as they are.
+type EquivalenceResolver interface {
+	CanonicalUUIDs(uuids []string) (map[string]string, error)
+}
+
+// EquivalenceResolverFunc adapts a plain function, e.g. a call to a concordances service, to an EquivalenceResolver
+type EquivalenceResolverFunc func(uuids []string) (map[string]string, error)
+
+// CanonicalUUIDs calls f(uuids)
+func (f EquivalenceResolverFunc) CanonicalUUIDs(uuids []string) (map[string]string, error) {
+	return f(uuids)
+}
+
+type neoEquivalenceResolver struct {
+	conn neoutils.CypherRunner
+}
+
+// NewNeoEquivalenceResolver returns an EquivalenceResolver that follows the EQUIVALENT_TO relationships
+// written by the concepts writers to the canonical node and returns its prefUUID
+func NewNeoEquivalenceResolver(conn neoutils.CypherRunner) EquivalenceResolver {
+	return neoEquivalenceResolver{conn: conn}
+}
+
+func (r neoEquivalenceResolver) CanonicalUUIDs(uuids []string) (map[string]string, error) {
+	var results []struct {
+		UUID          string `json:"uuid"`
+		CanonicalUUID string `json:"canonicalUUID"`
+	}
+
+	query := &neoism.CypherQuery{
+		Statement: `UNWIND {uuids} AS id
+				MATCH (:Thing{uuid:id})-[:EQUIVALENT_TO]->(canonical:Thing)
+				WHERE canonical.prefUUID IS NOT NULL
+				RETURN id AS uuid, canonical.prefUUID AS canonicalUUID`,
+		Parameters: neoism.Props{"uuids": uuids},
+		Result:     &results,
+	}
+	if err := r.conn.CypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return nil, fmt.Errorf("error executing concept equivalence query: %w", err)
+	}
+
+	canonical := make(map[string]string, len(results))
+	for _, result := range results {
+		canonical[result.UUID] = result.CanonicalUUID
+	}
+	return canonical, nil
+}
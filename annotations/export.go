@@ -0,0 +1,66 @@
+package annotations
+
+import (
+	"fmt"
+
+	"github.com/jmcvetta/neoism"
+)
+
+// exportPageSize is the number of pieces of content read from Neo4j at a time
+var exportPageSize = 500
+
+// ContentAnnotations holds the annotations of one piece of content
+type ContentAnnotations struct {
+	ContentUUID string      `json:"contentUUID"`
+	Annotations Annotations `json:"annotations"`
+}
+
+// Export calls handle with the annotations of every piece of content in the lifecycle, in content UUID order.
+// Annotations are read from Neo4j a page of content at a time, so the whole lifecycle is never held in memory. Pages
+// start after the last content UUID of the previous one, seeking the content through the uuid index rather than scanning
+// the lifecycle again. Export stops at the first error returned by handle and returns it.
+func (s service) Export(annotationLifecycle string, handle func(ContentAnnotations) error) error {
+	statement := fmt.Sprintf(`
+			MATCH (c:Thing)
+			WHERE c.uuid > {lastUUID} AND (c)-[{lifecycle:{annotationLifecycle}}]->(:Thing)
+			WITH c ORDER BY c.uuid LIMIT {pageSize}
+			MATCH (c)-[rel{lifecycle:{annotationLifecycle}}]->(cc:Thing)
+			WITH c.uuid AS contentUUID, cc, rel ORDER BY cc.uuid
+			RETURN contentUUID, collect({
+				thing:{id:cc.uuid,prefLabel:cc.prefLabel,types:labels(cc),predicate:type(rel)},
+				provenances:[{
+					scores:[score IN [
+						{scoringSystem:'%s', value:rel.relevanceScore},
+						{scoringSystem:'%s', value:rel.confidenceScore}] WHERE score.value IS NOT NULL],
+					agentRole:rel.annotatedBy,
+					atTime:rel.annotatedDate,
+					sourceConceptId:rel.sourceConceptId}]}) AS annotations
+			ORDER BY contentUUID`, relevanceScoringSystem, confidenceScoringSystem)
+
+	lastUUID := ""
+	for {
+		var page []ContentAnnotations
+		query := &neoism.CypherQuery{
+			Statement:  statement,
+			Parameters: neoism.Props{"annotationLifecycle": annotationLifecycle, "lastUUID": lastUUID, "pageSize": exportPageSize},
+			Result:     &page,
+		}
+		if err := s.conn.CypherBatch([]*neoism.CypherQuery{query}); err != nil {
+			return fmt.Errorf("error executing export query: %w", err)
+		}
+
+		for _, content := range page {
+			for idx := range content.Annotations {
+				mapToResponseFormat(&content.Annotations[idx])
+			}
+			if err := handle(content); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < exportPageSize {
+			return nil
+		}
+		lastUUID = page[len(page)-1].ContentUUID
+	}
+}
@@ -0,0 +1,60 @@
+package annotations
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+)
+
+// pagingConn answers export queries with pages of content, one annotation each, from a fixed number of pieces of content
+type pagingConn struct {
+	recordingConn
+	total int
+}
+
+func (c *pagingConn) CypherBatch(queries []*neoism.CypherQuery) error {
+	c.queries = append(c.queries, queries...)
+	lastUUID := queries[0].Parameters["lastUUID"].(string)
+	page := queries[0].Result.(*[]ContentAnnotations)
+	for i := 0; i < c.total && len(*page) < queries[0].Parameters["pageSize"].(int); i++ {
+		uuid := fmt.Sprintf("%08d-0000-0000-0000-000000000000", i)
+		if uuid > lastUUID {
+			*page = append(*page, ContentAnnotations{ContentUUID: uuid, Annotations: Annotations{{Thing: Thing{ID: conceptUUID, Types: []string{"Thing"}}}}})
+		}
+	}
+	return nil
+}
+
+func TestExportPagesThroughContent(t *testing.T) {
+	conn := &pagingConn{total: exportPageSize + 1}
+	svc := NewCypherAnnotationsService(conn)
+
+	var exported []string
+	err := svc.Export(v2AnnotationLifecycle, func(content ContentAnnotations) error {
+		exported = append(exported, content.ContentUUID)
+		assert.Equal(t, getURI(conceptUUID), content.Annotations[0].Thing.ID, "Annotations should be in the read format")
+		assert.Equal(t, []string{"http://www.ft.com/ontology/core/Thing"}, content.Annotations[0].Thing.Types)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Len(t, exported, exportPageSize+1)
+	assert.Len(t, conn.queries, 2)
+	assert.Equal(t, "", conn.queries[0].Parameters["lastUUID"])
+	assert.Equal(t, exported[exportPageSize-1], conn.queries[1].Parameters["lastUUID"])
+}
+
+func TestExportStopsOnHandlerError(t *testing.T) {
+	svc := NewCypherAnnotationsService(&pagingConn{total: 3})
+
+	calls := 0
+	err := svc.Export(v2AnnotationLifecycle, func(content ContentAnnotations) error {
+		calls++
+		return errors.New("client went away")
+	})
+	assert.EqualError(t, err, "client went away")
+	assert.Equal(t, 1, calls)
+}
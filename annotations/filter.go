@@ -0,0 +1,102 @@
+package annotations
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Financial-Times/neo-model-utils-go/mapper"
+	"github.com/jmcvetta/neoism"
+)
+
+// ReadFilter restricts the annotations returned by Read. The zero value returns every annotation.
+// Multiple values of the same field match any of them, different fields must all match.
+type ReadFilter struct {
+	// Predicates as used in the PUT body, e.g. mentions or isClassifiedBy
+	Predicates []string
+	// Types of the annotated concept, as ontology type URIs or bare type names, e.g. http://www.ft.com/ontology/Topic or Topic
+	Types []string
+	// AgentRoles that made the annotation, as URIs or UUIDs
+	AgentRoles      []string
+	MinRelevance    *float64
+	MinConfidence   *float64
+	AnnotatedAfter  time.Time
+	AnnotatedBefore time.Time
+}
+
+// IsZero tells whether the filter returns every annotation
+func (f ReadFilter) IsZero() bool {
+	return len(f.Predicates) == 0 && len(f.Types) == 0 && len(f.AgentRoles) == 0 &&
+		f.MinRelevance == nil && f.MinConfidence == nil && f.AnnotatedAfter.IsZero() && f.AnnotatedBefore.IsZero()
+}
+
+// whereClause builds the Cypher WHERE clause for the filter, matching relationships bound to rel and concepts bound to cc,
+// together with the parameters it uses. Agent roles are resolved with r. An empty filter gives an empty clause.
+func (f ReadFilter) whereClause(r IDResolver) (string, neoism.Props, error) {
+	var conditions []string
+	params := neoism.Props{}
+
+	if len(f.Predicates) > 0 {
+		var relationships []string
+		for _, predicate := range f.Predicates {
+			relationship, err := getRelationshipFromPredicate(predicate)
+			if err != nil {
+				return "", nil, ValidationError{fmt.Sprintf("unsupported predicate %s", predicate)}
+			}
+			relationships = append(relationships, relationship)
+		}
+		conditions = append(conditions, "type(rel) IN {filterRelationships}")
+		params["filterRelationships"] = relationships
+	}
+
+	if len(f.Types) > 0 {
+		var labels []string
+		for _, t := range f.Types {
+			label := t[strings.LastIndex(t, "/")+1:]
+			if label != "Thing" && mapper.ParentType(label) == "" {
+				return "", nil, ValidationError{fmt.Sprintf("unsupported concept type %s", t)}
+			}
+			labels = append(labels, label)
+		}
+		conditions = append(conditions, "any(label IN labels(cc) WHERE label IN {filterTypes})")
+		params["filterTypes"] = labels
+	}
+
+	if len(f.AgentRoles) > 0 {
+		var agents []string
+		for _, agentRole := range f.AgentRoles {
+			agent, err := r.ResolveUUID(agentRole)
+			if err != nil {
+				return "", nil, ValidationError{fmt.Sprintf("invalid agentRole %s", agentRole)}
+			}
+			agents = append(agents, agent)
+		}
+		conditions = append(conditions, "rel.annotatedBy IN {filterAgentRoles}")
+		params["filterAgentRoles"] = agents
+	}
+
+	if f.MinRelevance != nil {
+		conditions = append(conditions, "rel.relevanceScore >= {filterMinRelevance}")
+		params["filterMinRelevance"] = *f.MinRelevance
+	}
+
+	if f.MinConfidence != nil {
+		conditions = append(conditions, "rel.confidenceScore >= {filterMinConfidence}")
+		params["filterMinConfidence"] = *f.MinConfidence
+	}
+
+	if !f.AnnotatedAfter.IsZero() {
+		conditions = append(conditions, "rel.annotatedDateEpoch > {filterAnnotatedAfter}")
+		params["filterAnnotatedAfter"] = f.AnnotatedAfter.Unix()
+	}
+
+	if !f.AnnotatedBefore.IsZero() {
+		conditions = append(conditions, "rel.annotatedDateEpoch < {filterAnnotatedBefore}")
+		params["filterAnnotatedBefore"] = f.AnnotatedBefore.Unix()
+	}
+
+	if len(conditions) == 0 {
+		return "", params, nil
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), params, nil
+}
@@ -0,0 +1,91 @@
+package annotations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmptyFilterHasNoWhereClause(t *testing.T) {
+	clause, params, err := ReadFilter{}.whereClause(defaultIDResolver)
+	assert.NoError(t, err)
+	assert.Empty(t, clause)
+	assert.Empty(t, params)
+}
+
+func TestFilterIsZero(t *testing.T) {
+	minRelevance := 0.5
+	assert.True(t, ReadFilter{}.IsZero())
+	assert.True(t, ReadFilter{Predicates: []string{}}.IsZero())
+	assert.False(t, ReadFilter{Predicates: []string{"about"}}.IsZero())
+	assert.False(t, ReadFilter{MinRelevance: &minRelevance}.IsZero())
+	assert.False(t, ReadFilter{AnnotatedBefore: time.Now()}.IsZero())
+}
+
+func TestFilterWhereClause(t *testing.T) {
+	minRelevance := 0.5
+	minConfidence := 0.8
+	after := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	filter := ReadFilter{
+		Predicates:      []string{"mentions", "about"},
+		Types:           []string{"http://www.ft.com/ontology/Topic", "Person"},
+		AgentRoles:      []string{"http://api.ft.com/things/0edd3c31-1fd0-4ef6-9230-8d545be3880a"},
+		MinRelevance:    &minRelevance,
+		MinConfidence:   &minConfidence,
+		AnnotatedAfter:  after,
+		AnnotatedBefore: before,
+	}
+
+	clause, params, err := filter.whereClause(defaultIDResolver)
+	assert.NoError(t, err)
+	assert.Equal(t, "WHERE type(rel) IN {filterRelationships}"+
+		" AND any(label IN labels(cc) WHERE label IN {filterTypes})"+
+		" AND rel.annotatedBy IN {filterAgentRoles}"+
+		" AND rel.relevanceScore >= {filterMinRelevance}"+
+		" AND rel.confidenceScore >= {filterMinConfidence}"+
+		" AND rel.annotatedDateEpoch > {filterAnnotatedAfter}"+
+		" AND rel.annotatedDateEpoch < {filterAnnotatedBefore}", clause)
+	assert.Equal(t, neoism.Props{
+		"filterRelationships":   []string{"MENTIONS", "ABOUT"},
+		"filterTypes":           []string{"Topic", "Person"},
+		"filterAgentRoles":      []string{"0edd3c31-1fd0-4ef6-9230-8d545be3880a"},
+		"filterMinRelevance":    0.5,
+		"filterMinConfidence":   0.8,
+		"filterAnnotatedAfter":  after.Unix(),
+		"filterAnnotatedBefore": before.Unix(),
+	}, params)
+}
+
+func TestFilterValidation(t *testing.T) {
+	testCases := []struct {
+		name   string
+		filter ReadFilter
+		msg    string
+	}{
+		{"unknown predicate", ReadFilter{Predicates: []string{"hasAFakePredicate"}}, "unsupported predicate hasAFakePredicate"},
+		{"unknown type", ReadFilter{Types: []string{"http://www.ft.com/ontology/Unicorn"}}, "unsupported concept type http://www.ft.com/ontology/Unicorn"},
+		{"invalid agent", ReadFilter{AgentRoles: []string{"someone"}}, "invalid agentRole someone"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := test.filter.whereClause(defaultIDResolver)
+			assert.Equal(t, ValidationError{test.msg}, err)
+		})
+	}
+}
+
+func TestReadPushesFilterDownToCypher(t *testing.T) {
+	conn := &recordingConn{}
+	svc := NewCypherAnnotationsService(conn)
+
+	_, found, err := svc.Read(contentUUID, "tid", v2AnnotationLifecycle, ReadFilter{Predicates: []string{"about"}})
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Contains(t, conn.queries[0].Statement, "WHERE type(rel) IN {filterRelationships}")
+	assert.Equal(t, []string{"ABOUT"}, conn.queries[0].Parameters["filterRelationships"])
+	assert.Equal(t, contentUUID, conn.queries[0].Parameters["contentUUID"])
+}
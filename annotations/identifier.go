@@ -0,0 +1,124 @@
+package annotations
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Financial-Times/neo-model-utils-go/mapper"
+	uuid "github.com/satori/go.uuid"
+)
+
+const uuidPattern = `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`
+
+// IDResolver turns a concept identifier, in whatever form a client supplied it, into the UUID of the concept.
+type IDResolver interface {
+	ResolveUUID(id string) (string, error)
+}
+
+// IDScheme describes one accepted form of concept identifier.
+// Pattern is matched against the trimmed identifier and its first submatch is passed to ToUUID.
+type IDScheme struct {
+	Name    string
+	Pattern *regexp.Regexp
+	ToUUID  func(id string) string
+}
+
+// FTURIScheme accepts any http(s) URI whose last path segment is a UUID, e.g. http://api.ft.com/things/{uuid},
+// https://api.ft.com/people/{uuid}/ or http://api.ft.com/organisations/{uuid}?foo=bar
+var FTURIScheme = IDScheme{
+	Name:    "ft-uri",
+	Pattern: regexp.MustCompile(`^(?i:https?)://[^/?#]+/(?:[^?#]*/)?(` + uuidPattern + `)/?(?:[?#].*)?$`),
+	ToUUID:  strings.ToLower,
+}
+
+// UUIDScheme accepts a bare UUID.
+var UUIDScheme = IDScheme{
+	Name:    "uuid",
+	Pattern: regexp.MustCompile(`^(` + uuidPattern + `)$`),
+	ToUUID:  strings.ToLower,
+}
+
+// UUIDURNScheme accepts RFC 4122 URNs, e.g. urn:uuid:{uuid}
+var UUIDURNScheme = IDScheme{
+	Name:    "urn",
+	Pattern: regexp.MustCompile(`^(?i:urn:uuid:)(` + uuidPattern + `)$`),
+	ToUUID:  strings.ToLower,
+}
+
+// TMEScheme accepts TME identifiers prefixed with "tme:". The UUID is derived from the TME identifier
+// in the same way as the TME concept transformers do, so it points at the concept they created.
+var TMEScheme = IDScheme{
+	Name:    "tme",
+	Pattern: regexp.MustCompile(`^(?i:tme:)(\S+)$`),
+	ToUUID:  tmeUUID,
+}
+
+// DefaultIDSchemes are the identifier forms accepted when no other configuration is given.
+var DefaultIDSchemes = []IDScheme{FTURIScheme, UUIDScheme, UUIDURNScheme}
+
+var knownIDSchemes = map[string]IDScheme{
+	FTURIScheme.Name:   FTURIScheme,
+	UUIDScheme.Name:    UUIDScheme,
+	UUIDURNScheme.Name: UUIDURNScheme,
+	TMEScheme.Name:     TMEScheme,
+}
+
+// LookupIDSchemes returns the built-in identifier schemes with the given names, in the given order.
+func LookupIDSchemes(names []string) ([]IDScheme, error) {
+	var schemes []IDScheme
+	for _, name := range names {
+		scheme, ok := knownIDSchemes[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown concept identifier scheme %q", name)
+		}
+		schemes = append(schemes, scheme)
+	}
+	return schemes, nil
+}
+
+// SchemeResolver is an IDResolver that tries a list of IDSchemes in order and uses the first one that matches.
+type SchemeResolver struct {
+	schemes []IDScheme
+}
+
+// NewSchemeResolver returns a resolver for the given schemes, or for DefaultIDSchemes if none are given. The canonical
+// concept URIs, which the service resolves once it has canonicalised the ids supplied, are always accepted.
+func NewSchemeResolver(schemes ...IDScheme) *SchemeResolver {
+	if len(schemes) == 0 {
+		schemes = DefaultIDSchemes
+	}
+	for _, scheme := range schemes {
+		if scheme.Name == FTURIScheme.Name {
+			return &SchemeResolver{schemes: schemes}
+		}
+	}
+	return &SchemeResolver{schemes: append(append([]IDScheme{}, schemes...), FTURIScheme)}
+}
+
+// ResolveUUID returns the lowercase UUID identified by id.
+func (r *SchemeResolver) ResolveUUID(id string) (string, error) {
+	trimmed := strings.TrimSpace(id)
+	for _, scheme := range r.schemes {
+		match := scheme.Pattern.FindStringSubmatch(trimmed)
+		if len(match) == 2 {
+			return scheme.ToUUID(match[1]), nil
+		}
+	}
+	return "", fmt.Errorf("couldn't extract uuid from uri %s", id)
+}
+
+// CanonicalID returns the canonical concept URI for id, as built by mapper.IDURL.
+func CanonicalID(r IDResolver, id string) (string, error) {
+	uuid, err := r.ResolveUUID(id)
+	if err != nil {
+		return "", err
+	}
+	return mapper.IDURL(uuid), nil
+}
+
+// tmeUUID generates the version 3 UUID of a TME identifier in the nil namespace, as the TME transformers do with
+// uuid.NewMD5(uuid.UUID{}, []byte(identifier)).
+func tmeUUID(identifier string) string {
+	return uuid.NewV3(uuid.Nil, identifier).String()
+}
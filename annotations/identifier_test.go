@@ -0,0 +1,78 @@
+package annotations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultResolverAcceptsCommonForms(t *testing.T) {
+	testCases := []struct {
+		name string
+		id   string
+	}{
+		{"things uri", "http://api.ft.com/things/2cca9e2a-2248-3e48-abc1-93d718b91bbe"},
+		{"people uri over https", "https://api.ft.com/people/2cca9e2a-2248-3e48-abc1-93d718b91bbe"},
+		{"organisations uri with query", "http://api.ft.com/organisations/2cca9e2a-2248-3e48-abc1-93d718b91bbe?foo=bar"},
+		{"trailing slash", "http://www.ft.com/thing/2cca9e2a-2248-3e48-abc1-93d718b91bbe/"},
+		{"uppercase uuid", "http://api.ft.com/things/2CCA9E2A-2248-3E48-ABC1-93D718B91BBE"},
+		{"bare uuid", "2cca9e2a-2248-3e48-abc1-93d718b91bbe"},
+		{"bare uppercase uuid with spaces", " 2CCA9E2A-2248-3E48-ABC1-93D718B91BBE "},
+		{"uuid urn", "urn:uuid:2cca9e2a-2248-3e48-abc1-93d718b91bbe"},
+	}
+
+	r := NewSchemeResolver()
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			id, err := CanonicalID(r, test.id)
+			assert.NoError(t, err)
+			assert.Equal(t, "http://api.ft.com/things/2cca9e2a-2248-3e48-abc1-93d718b91bbe", id)
+		})
+	}
+}
+
+func TestDefaultResolverRejectsUnknownForms(t *testing.T) {
+	r := NewSchemeResolver()
+	for _, id := range []string{"", "not-a-uuid", "http://api.ft.com/things/", "tme:TnN0ZWluX1BOXzIwMDkwNjIzXzI5MzU=-UE4="} {
+		_, err := r.ResolveUUID(id)
+		assert.Error(t, err, id)
+	}
+}
+
+func TestTMEScheme(t *testing.T) {
+	r := NewSchemeResolver(append(DefaultIDSchemes, TMEScheme)...)
+	uuid, err := r.ResolveUUID("tme:Mjk0NTA0OTQtMDc5Mi00MzVjLThlZTEtZGMxMzM4YzEzNjkz-VG9waWNz")
+	assert.NoError(t, err)
+	// the UUID the TME transformers give the concept, uuid.NewMD5(uuid.UUID{}, []byte(identifier))
+	assert.Equal(t, "6d58b99c-0e95-3437-8508-1f37fb60895c", uuid)
+}
+
+func TestSchemeResolverAcceptsCanonicalURIs(t *testing.T) {
+	r := NewSchemeResolver(TMEScheme)
+	uuid, err := r.ResolveUUID("http://api.ft.com/things/2cca9e2a-2248-3e48-abc1-93d718b91bbe")
+	assert.NoError(t, err)
+	assert.Equal(t, "2cca9e2a-2248-3e48-abc1-93d718b91bbe", uuid)
+	_, err = r.ResolveUUID("2cca9e2a-2248-3e48-abc1-93d718b91bbe")
+	assert.Error(t, err, "Only the configured schemes and the canonical URIs should be accepted")
+}
+
+func TestLookupIDSchemes(t *testing.T) {
+	schemes, err := LookupIDSchemes([]string{"uuid", "tme"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"uuid", "tme"}, []string{schemes[0].Name, schemes[1].Name})
+
+	_, err = LookupIDSchemes([]string{"isbn"})
+	assert.EqualError(t, err, `unknown concept identifier scheme "isbn"`)
+}
+
+func TestCanonicaliseConceptIDsDoesNotModifyInput(t *testing.T) {
+	anns := Annotations{{Thing: Thing{ID: "2CCA9E2A-2248-3E48-ABC1-93D718B91BBE"}}}
+	canonical, err := canonicaliseConceptIDs(NewSchemeResolver(), anns)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://api.ft.com/things/2cca9e2a-2248-3e48-abc1-93d718b91bbe", canonical[0].Thing.ID)
+	assert.Equal(t, "2CCA9E2A-2248-3E48-ABC1-93D718B91BBE", anns[0].Thing.ID)
+
+	_, err = canonicaliseConceptIDs(NewSchemeResolver(), Annotations{{Thing: Thing{ID: "nope"}}})
+	_, ok := err.(ValidationError)
+	assert.True(t, ok, "Should have returned a validation error")
+}
@@ -18,10 +18,13 @@ type Thing struct {
 }
 
 //Provenance indicates the scores and where they came from
+//SourceConceptID is only set on read, when the annotation was written against a different concept
+//that was resolved to the canonical one
 type Provenance struct {
-	Scores    []Score `json:"scores,omitempty"`
-	AgentRole string  `json:"agentRole,omitempty"`
-	AtTime    string  `json:"atTime,omitempty"`
+	Scores          []Score `json:"scores,omitempty"`
+	AgentRole       string  `json:"agentRole,omitempty"`
+	AtTime          string  `json:"atTime,omitempty"`
+	SourceConceptID string  `json:"sourceConceptId,omitempty"`
 }
 
 //Score represents one of our scores for the annotation
@@ -0,0 +1,60 @@
+package annotations
+
+import (
+	"sort"
+)
+
+// predicateURIs maps the relationship types written for each predicate to the FT ontology property URIs
+var predicateURIs = map[string]string{
+	"MENTIONS":                   "http://www.ft.com/ontology/annotation/mentions",
+	"IS_CLASSIFIED_BY":           "http://www.ft.com/ontology/classification/isClassifiedBy",
+	"IMPLICITLY_CLASSIFIED_BY":   "http://www.ft.com/ontology/implicitlyClassifiedBy",
+	"ABOUT":                      "http://www.ft.com/ontology/annotation/about",
+	"IS_PRIMARILY_CLASSIFIED_BY": "http://www.ft.com/ontology/classification/isPrimarilyClassifiedBy",
+	"MAJOR_MENTIONS":             "http://www.ft.com/ontology/annotation/majorMentions",
+	"HAS_AUTHOR":                 "http://www.ft.com/ontology/annotation/hasAuthor",
+	"HAS_CONTRIBUTOR":            "http://www.ft.com/ontology/hasContributor",
+	"HAS_DISPLAY_TAG":            "http://www.ft.com/ontology/hasDisplayTag",
+	"HAS_BRAND":                  "http://www.ft.com/ontology/hasBrand",
+}
+
+// PredicateNames returns the supported predicates, as used in the PUT body, in alphabetical order
+func PredicateNames() []string {
+	names := make([]string, 0, len(relations))
+	for name := range relations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PredicateName returns the name of a predicate as used in the PUT body (e.g. mentions), given either that name,
+// the relationship type returned by Read (e.g. MENTIONS), or an empty string for the default predicate
+func PredicateName(predicate string) (string, error) {
+	relationship, err := relationshipOf(predicate)
+	if err != nil {
+		return "", err
+	}
+	for name, r := range relations {
+		if r == relationship {
+			return name, nil
+		}
+	}
+	return "", UnsupportedPredicateErr
+}
+
+// PredicateURI returns the FT ontology property URI of a predicate, given in any of the forms accepted by PredicateName
+func PredicateURI(predicate string) (string, error) {
+	relationship, err := relationshipOf(predicate)
+	if err != nil {
+		return "", err
+	}
+	return predicateURIs[relationship], nil
+}
+
+func relationshipOf(predicate string) (string, error) {
+	if _, found := predicateURIs[predicate]; found {
+		return predicate, nil
+	}
+	return getRelationshipFromPredicate(predicate)
+}
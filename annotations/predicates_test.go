@@ -0,0 +1,42 @@
+package annotations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEveryPredicateHasAnOntologyURI(t *testing.T) {
+	for name, relationship := range relations {
+		uri, err := PredicateURI(name)
+		assert.NoError(t, err, name)
+		assert.NotEmpty(t, uri, name)
+
+		uriFromRelationship, err := PredicateURI(relationship)
+		assert.NoError(t, err, relationship)
+		assert.Equal(t, uri, uriFromRelationship)
+	}
+	assert.Len(t, predicateURIs, len(relations))
+}
+
+func TestPredicateName(t *testing.T) {
+	var tests = []struct {
+		predicate string
+		name      string
+	}{
+		{"mentions", "mentions"},
+		{"MENTIONS", "mentions"},
+		{"", "mentions"},
+		{"IS_PRIMARILY_CLASSIFIED_BY", "isPrimarilyClassifiedBy"},
+		{"hasBrand", "hasBrand"},
+	}
+
+	for _, test := range tests {
+		name, err := PredicateName(test.predicate)
+		assert.NoError(t, err)
+		assert.Equal(t, test.name, name)
+	}
+
+	_, err := PredicateName("LIKES")
+	assert.Equal(t, UnsupportedPredicateErr, err)
+}
@@ -0,0 +1,54 @@
+package annotations
+
+import (
+	"github.com/Financial-Times/neo-model-utils-go/mapper"
+)
+
+// Formats in which the types of an annotated concept can be returned
+const (
+	HierarchyTypes    = "hierarchy"
+	MostSpecificTypes = "mostSpecific"
+)
+
+// IsValidTypesFormat reports whether format is one of the supported formats for concept types
+func IsValidTypesFormat(format string) bool {
+	return format == HierarchyTypes || format == MostSpecificTypes
+}
+
+// WithMostSpecificType returns a copy of the annotations in which the types of every concept are reduced to
+// the most specific one. Types are expected in the hierarchy format returned by Read, least specific first.
+func WithMostSpecificType(anns Annotations) Annotations {
+	result := make(Annotations, len(anns))
+	for idx, ann := range anns {
+		if len(ann.Thing.Types) > 1 {
+			ann.Thing.Types = ann.Thing.Types[len(ann.Thing.Types)-1:]
+		}
+		result[idx] = ann
+	}
+	return result
+}
+
+// typeURIsFromLabels maps the Neo4j labels of a concept to the ontology type URIs of its full type hierarchy,
+// ordered from the least to the most specific type. Labels that are not ontology types are ignored.
+func typeURIsFromLabels(labels []string) []string {
+	var types []string
+	for _, label := range labels {
+		if label == "Thing" || mapper.ParentType(label) != "" {
+			types = append(types, label)
+		}
+	}
+	if len(types) == 0 {
+		return nil
+	}
+
+	mostSpecific, err := mapper.MostSpecificType(types)
+	if err != nil {
+		// labels are not a single hierarchy, so map them one by one rather than dropping them
+		var uris []string
+		for _, t := range types {
+			uris = append(uris, mapper.TypeURIs([]string{t})...)
+		}
+		return uris
+	}
+	return mapper.FullTypeHierarchy(mostSpecific)
+}
@@ -0,0 +1,72 @@
+package annotations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypeURIsFromLabels(t *testing.T) {
+	testCases := []struct {
+		name     string
+		labels   []string
+		expected []string
+	}{
+		{
+			name:   "full hierarchy in any order",
+			labels: []string{"Thing", "Topic", "Concept"},
+			expected: []string{
+				"http://www.ft.com/ontology/core/Thing",
+				"http://www.ft.com/ontology/concept/Concept",
+				"http://www.ft.com/ontology/Topic",
+			},
+		},
+		{
+			name:   "missing intermediate labels",
+			labels: []string{"PublicCompany", "Thing"},
+			expected: []string{
+				"http://www.ft.com/ontology/core/Thing",
+				"http://www.ft.com/ontology/concept/Concept",
+				"http://www.ft.com/ontology/organisation/Organisation",
+				"http://www.ft.com/ontology/company/Company",
+				"http://www.ft.com/ontology/company/PublicCompany",
+			},
+		},
+		{
+			name:     "unknown labels are ignored",
+			labels:   []string{"Thing", "UPPIdentifier"},
+			expected: []string{"http://www.ft.com/ontology/core/Thing"},
+		},
+		{
+			name:   "siblings are mapped one by one",
+			labels: []string{"Person", "Brand"},
+			expected: []string{
+				"http://www.ft.com/ontology/person/Person",
+				"http://www.ft.com/ontology/product/Brand",
+			},
+		},
+		{
+			name:     "no labels",
+			labels:   []string{},
+			expected: nil,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, typeURIsFromLabels(test.labels))
+		})
+	}
+}
+
+func TestWithMostSpecificType(t *testing.T) {
+	anns := Annotations{{Thing: Thing{Types: []string{
+		"http://www.ft.com/ontology/core/Thing",
+		"http://www.ft.com/ontology/concept/Concept",
+		"http://www.ft.com/ontology/Topic",
+	}}}}
+
+	mostSpecific := WithMostSpecificType(anns)
+	assert.Equal(t, []string{"http://www.ft.com/ontology/Topic"}, mostSpecific[0].Thing.Types)
+	assert.Len(t, anns[0].Thing.Types, 3, "Input annotations should not be modified")
+}
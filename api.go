@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const apiPath = "/__api"
+
+// apiSpec holds the OpenAPI document of the service. It serves the document and validates request bodies
+// against the schemas defined in it.
+type apiSpec struct {
+	raw     []byte
+	schemas map[string]*jsonSchema
+}
+
+// jsonSchema is the subset of the OpenAPI schema object used by the service's own document
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 string                 `json:"type"`
+	Format               string                 `json:"format"`
+	Enum                 []interface{}          `json:"enum"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+}
+
+// SchemaValidationError lists every way in which a document does not match its schema
+type SchemaValidationError struct {
+	Problems []string
+}
+
+func (e SchemaValidationError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+func loadAPISpec(path string) (*apiSpec, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading API specification: %w", err)
+	}
+
+	var doc struct {
+		Components struct {
+			Schemas map[string]*jsonSchema `json:"schemas"`
+		} `json:"components"`
+	}
+	if err = json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("error unmarshalling API specification: %w", err)
+	}
+	return &apiSpec{raw: raw, schemas: doc.Components.Schemas}, nil
+}
+
+func (s *apiSpec) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(s.raw)
+}
+
+// validate checks the JSON document against the named schema of components/schemas
+func (s *apiSpec) validate(schemaName string, doc []byte) error {
+	schema, found := s.schemas[schemaName]
+	if !found {
+		return fmt.Errorf("schema %s is not defined in the API specification", schemaName)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(doc, &value); err != nil {
+		return SchemaValidationError{Problems: []string{fmt.Sprintf("invalid JSON: %v", err)}}
+	}
+
+	var problems []string
+	s.validateValue(schema, value, "body", &problems)
+	if len(problems) > 0 {
+		return SchemaValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func (s *apiSpec) validateValue(schema *jsonSchema, value interface{}, path string, problems *[]string) {
+	if schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		ref, found := s.schemas[name]
+		if !found {
+			*problems = append(*problems, fmt.Sprintf("%s: schema %s is not defined", path, schema.Ref))
+			return
+		}
+		schema = ref
+	}
+
+	if !hasJSONType(value, schema.Type) {
+		*problems = append(*problems, fmt.Sprintf("%s should be of type %s but is %s", path, schema.Type, jsonTypeOf(value)))
+		return
+	}
+
+	if len(schema.Enum) > 0 && !inEnum(value, schema.Enum) {
+		*problems = append(*problems, fmt.Sprintf("%s has unsupported value %v", path, value))
+	}
+
+	switch v := value.(type) {
+	case string:
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				*problems = append(*problems, fmt.Sprintf("%s should be an RFC3339 date-time", path))
+			}
+		}
+	case []interface{}:
+		if schema.Items != nil {
+			for idx, item := range v {
+				s.validateValue(schema.Items, item, fmt.Sprintf("%s[%d]", path, idx), problems)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range schema.Required {
+			if _, found := v[name]; !found {
+				*problems = append(*problems, fmt.Sprintf("%s.%s is required", path, name))
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, found := schema.Properties[name]
+			if !found {
+				if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+					*problems = append(*problems, fmt.Sprintf("%s.%s is not a supported field", path, name))
+				}
+				continue
+			}
+			s.validateValue(property, v[name], path+"."+name, problems)
+		}
+	}
+}
+
+func hasJSONType(value interface{}, schemaType string) bool {
+	switch schemaType {
+	case "":
+		return true
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == float64(int64(n))
+	default:
+		return jsonTypeOf(value) == schemaType
+	}
+}
+
+func jsonTypeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func inEnum(value interface{}, enum []interface{}) bool {
+	for _, allowed := range enum {
+		if value == allowed {
+			return true
+		}
+	}
+	return false
+}
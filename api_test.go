@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+
+	logger "github.com/Financial-Times/go-logger/v2"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPISpecDocumentsEveryRoute(t *testing.T) {
+	spec, err := loadAPISpec("openapi.json")
+	require.NoError(t, err)
+
+	var doc struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(spec.raw, &doc))
+
+	registered := map[string]bool{}
+	hh := &httpHandler{apiSpec: spec}
+	err = newServicesRouter(hh, &healthCheckHandler{}).Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			// not a path route
+			return nil
+		}
+		methods, err := route.GetMethods()
+		require.NoError(t, err)
+		for _, method := range methods {
+			method = strings.ToLower(method)
+			registered[method+" "+path] = true
+			assert.Contains(t, doc.Paths[path], method, fmt.Sprintf("%s %s is not documented", method, path))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	for path, item := range doc.Paths {
+		for method := range item {
+			if method == "parameters" {
+				continue
+			}
+			assert.True(t, registered[method+" "+path], fmt.Sprintf("%s %s is documented but not registered", method, path))
+		}
+	}
+}
+
+func TestAPISpecPredicatesMatchSupportedPredicates(t *testing.T) {
+	spec, err := loadAPISpec("openapi.json")
+	require.NoError(t, err)
+
+	expected := []interface{}{""}
+	for _, name := range annotations.PredicateNames() {
+		expected = append(expected, name)
+	}
+	assert.ElementsMatch(t, expected, spec.schemas["Predicate"].Enum)
+}
+
+func TestAPISpecIsServed(t *testing.T) {
+	spec, err := loadAPISpec("openapi.json")
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest(http.MethodGet, "/__api", nil)
+	rec := httptest.NewRecorder()
+	router(&httpHandler{apiSpec: spec}, &healthCheckHandler{}, logger.NewUPPInfoLogger("annotations-rw")).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json; charset=UTF-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, string(spec.raw), rec.Body.String())
+}
+
+func TestAPISpecAcceptsExampleBodies(t *testing.T) {
+	spec, err := loadAPISpec("openapi.json")
+	require.NoError(t, err)
+
+	for _, file := range []string{"annotations/examplePutBody.json", "annotations/examplePutBodyWithPredicate.json"} {
+		body, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		assert.NoError(t, spec.validate("Annotations", body), file)
+	}
+}
+
+func TestAPISpecRejectsInvalidBodies(t *testing.T) {
+	spec, err := loadAPISpec("openapi.json")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		body     string
+		problems []string
+	}{
+		{
+			name:     "not a list",
+			body:     `{"id": "1234"}`,
+			problems: []string{"body should be of type array but is object"},
+		},
+		{
+			name:     "misspelled field",
+			body:     `[{"thing": {"id": "http://api.ft.com/things/2384fa7a-d514-3d6a-a0ea-3a711f66d0d8", "predicte": "about"}}]`,
+			problems: []string{"body[0].thing.predicte is not a supported field"},
+		},
+		{
+			name:     "missing concept id",
+			body:     `[{"thing": {"prefLabel": "Apple"}}]`,
+			problems: []string{"body[0].thing.id is required"},
+		},
+		{
+			name: "wrong types",
+			body: `[{"thing": {"id": 1, "types": "Organisation"}, "provenances": [{"scores": [{"value": "high"}], "atTime": "yesterday"}]}]`,
+			problems: []string{
+				"body[0].provenances[0].atTime should be an RFC3339 date-time",
+				"body[0].provenances[0].scores[0].value should be of type number but is string",
+				"body[0].thing.id should be of type string but is number",
+				"body[0].thing.types should be of type array but is string",
+			},
+		},
+		{
+			name:     "unknown predicate",
+			body:     `[{"thing": {"id": "http://api.ft.com/things/2384fa7a-d514-3d6a-a0ea-3a711f66d0d8", "predicate": "hasAFakePredicate"}}]`,
+			problems: []string{"body[0].thing.predicate has unsupported value hasAFakePredicate"},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := spec.validate("Annotations", []byte(test.body))
+			assert.Equal(t, SchemaValidationError{Problems: test.problems}, err)
+		})
+	}
+}
@@ -0,0 +1,111 @@
+// Package audit keeps an audit trail of the writes and deletes of annotations: who changed the annotations of which
+// content, when, and the annotations before and after the change. Records go to a Sink, which is a local rotating JSON
+// lines file, nodes in Neo4j or messages on a Kafka topic.
+package audit
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+
+	logger "github.com/Financial-Times/go-logger/v2"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Actions
+const (
+	Write  = "write"
+	Delete = "delete"
+)
+
+// Sources of the changes
+const (
+	HTTP  = "http"
+	Kafka = "kafka"
+)
+
+// ErrQueryNotSupported is returned by the sinks that records cannot be read back from
+var ErrQueryNotSupported = errors.New("the audit log cannot be queried with this sink")
+
+// Record is the audit record of a write or delete. Before is nil when the annotations could not be read before the
+// change, and Caller is empty when the request was not authenticated or the change came from Kafka.
+type Record struct {
+	ID            string                  `json:"id"`
+	Time          time.Time               `json:"time"`
+	Action        string                  `json:"action"`
+	Source        string                  `json:"source"`
+	TransactionID string                  `json:"transactionId"`
+	OriginSystem  string                  `json:"originSystem"`
+	Caller        string                  `json:"caller"`
+	Lifecycle     string                  `json:"annotationLifecycle"`
+	UUID          string                  `json:"uuid"`
+	Before        annotations.Annotations `json:"before"`
+	After         annotations.Annotations `json:"after"`
+}
+
+// Query selects the records of a piece of content made from From, inclusive, to To, exclusive.
+// A zero From or To leaves the range open, and an empty Lifecycle matches every lifecycle.
+type Query struct {
+	UUID      string
+	Lifecycle string
+	From      time.Time
+	To        time.Time
+}
+
+func (q Query) matches(r Record) bool {
+	return r.UUID == q.UUID &&
+		(q.Lifecycle == "" || r.Lifecycle == q.Lifecycle) &&
+		(q.From.IsZero() || !r.Time.Before(q.From)) &&
+		(q.To.IsZero() || r.Time.Before(q.To))
+}
+
+// Sink stores audit records
+type Sink interface {
+	Append(r Record) error
+	// Query returns the matching records in the order they were made, or ErrQueryNotSupported
+	Query(q Query) ([]Record, error)
+	Close() error
+}
+
+// Log records the changes to annotations in a Sink. A nil *Log records nothing.
+type Log struct {
+	sink Sink
+	log  *logger.UPPLogger
+	now  func() time.Time
+}
+
+// New returns a Log recording to the sink
+func New(sink Sink, log *logger.UPPLogger) *Log {
+	return &Log{sink: sink, log: log, now: time.Now}
+}
+
+// Record assigns the record an id and time and appends it to the sink. The change has already been made when it is
+// recorded, so a record that cannot be stored is logged with the AuditLogFailure monitoring event rather than failing it.
+func (l *Log) Record(r Record) {
+	if l == nil {
+		return
+	}
+	id, _ := uuid.NewV4()
+	r.ID = id.String()
+	r.Time = l.now().UTC()
+	if r.After == nil {
+		r.After = annotations.Annotations{}
+	}
+	if err := l.sink.Append(r); err != nil {
+		l.log.WithMonitoringEvent("AuditLogFailure", r.TransactionID, r.Action).WithUUID(r.UUID).WithField("caller", r.Caller).WithError(err).Error("Failed to store the audit record of a change to annotations")
+	}
+}
+
+// Query returns the records matching the query, oldest first
+func (l *Log) Query(q Query) ([]Record, error) {
+	return l.sink.Query(q)
+}
+
+// Close closes the sink once the last changes are recorded
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.sink.Close()
+}
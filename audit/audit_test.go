@@ -0,0 +1,241 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+
+	logger "github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	start    = time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	mentions = annotations.Annotations{{Thing: annotations.Thing{ID: "http://api.ft.com/things/1", Predicate: "mentions"}}}
+)
+
+func record(uuid string, minutes int) Record {
+	return Record{
+		ID:            fmt.Sprintf("%s-%02d", uuid, minutes),
+		Time:          start.Add(time.Duration(minutes) * time.Minute),
+		Action:        Write,
+		Source:        HTTP,
+		TransactionID: "tid_test",
+		Lifecycle:     "annotations-v1",
+		UUID:          uuid,
+		Before:        annotations.Annotations{},
+		After:         mentions,
+	}
+}
+
+func TestQueryMatches(t *testing.T) {
+	r := record("1234", 10)
+	tests := []struct {
+		name    string
+		query   Query
+		matches bool
+	}{
+		{"content", Query{UUID: "1234"}, true},
+		{"other content", Query{UUID: "5678"}, false},
+		{"lifecycle", Query{UUID: "1234", Lifecycle: "annotations-v1"}, true},
+		{"other lifecycle", Query{UUID: "1234", Lifecycle: "annotations-pac"}, false},
+		{"from is inclusive", Query{UUID: "1234", From: r.Time}, true},
+		{"to is exclusive", Query{UUID: "1234", To: r.Time}, false},
+		{"in range", Query{UUID: "1234", From: start, To: start.Add(time.Hour)}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.matches, test.query.matches(r))
+		})
+	}
+}
+
+func TestFileSinkRotatesAndQueries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	line, err := json.Marshal(record("1234", 0))
+	require.NoError(t, err)
+	path := filepath.Join(dir, "audit.jsonl")
+	s, err := NewFileSink(path, int64(2*len(line)+2), 2)
+	require.NoError(t, err)
+	rotations := 0
+	s.now = func() time.Time {
+		rotations++
+		return start.Add(time.Duration(rotations) * time.Second)
+	}
+
+	for minutes := 0; minutes < 8; minutes++ {
+		require.NoError(t, s.Append(record("1234", minutes)))
+		require.NoError(t, s.Append(record("5678", minutes)))
+	}
+	require.NoError(t, s.Close())
+
+	rotated, err := s.rotated()
+	require.NoError(t, err)
+	assert.Equal(t, []string{path + ".20261018T090006.000000000", path + ".20261018T090007.000000000"}, rotated, "Only the newest rotated files should be kept")
+
+	s, err = NewFileSink(path, int64(2*len(line)+2), 2)
+	require.NoError(t, err)
+	defer s.Close()
+	records, err := s.Query(Query{UUID: "1234", From: start.Add(6 * time.Minute)})
+	require.NoError(t, err)
+	assert.Equal(t, []Record{record("1234", 6), record("1234", 7)}, records)
+
+	records, err = s.Query(Query{UUID: "1234", To: start})
+	require.NoError(t, err)
+	assert.Equal(t, []Record{}, records)
+}
+
+func TestFileSinkSkipsTruncatedLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.jsonl")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"id":"cut short","uuid":"1234"`), 0640))
+	s, err := NewFileSink(path, 0, 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.Append(record("1234", 1)))
+	records, err := s.Query(Query{UUID: "1234"})
+	require.NoError(t, err)
+	assert.Equal(t, []Record{record("1234", 1)}, records)
+}
+
+type failingSink struct {
+	Sink
+	appended []Record
+}
+
+func (s *failingSink) Append(r Record) error {
+	s.appended = append(s.appended, r)
+	return errors.New("disk full")
+}
+
+func TestLogRecord(t *testing.T) {
+	sink := &failingSink{}
+	l := New(sink, logger.NewUPPInfoLogger("test"))
+	l.now = func() time.Time { return start }
+	l.Record(Record{Action: Delete, UUID: "1234"})
+
+	require.Len(t, sink.appended, 1, "A record the sink fails to store should only be logged")
+	r := sink.appended[0]
+	assert.NotEmpty(t, r.ID)
+	assert.Equal(t, start, r.Time)
+	assert.Nil(t, r.Before)
+	assert.Equal(t, annotations.Annotations{}, r.After)
+
+	var disabled *Log
+	disabled.Record(Record{Action: Delete, UUID: "1234"})
+	assert.NoError(t, disabled.Close())
+}
+
+// neoConn records the queries run, filling the results of queries with rows
+type neoConn struct {
+	queries []*neoism.CypherQuery
+	rows    string
+	indexes map[string]string
+}
+
+func (c *neoConn) CypherBatch(queries []*neoism.CypherQuery) error {
+	c.queries = append(c.queries, queries...)
+	for _, q := range queries {
+		if q.Result != nil {
+			if err := json.Unmarshal([]byte(c.rows), q.Result); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *neoConn) EnsureConstraints(indexes map[string]string) error {
+	return nil
+}
+
+func (c *neoConn) EnsureIndexes(indexes map[string]string) error {
+	c.indexes = indexes
+	return nil
+}
+
+func TestNeo4jSink(t *testing.T) {
+	conn := &neoConn{rows: `[
+		{"id": "1", "time": 1792314000000, "action": "write", "uuid": "1234", "annotationLifecycle": "annotations-v1", "before": "[]", "after": "[{\"thing\":{\"id\":\"http://api.ft.com/things/1\",\"predicate\":\"mentions\"}}]"},
+		{"id": "2", "time": 1792314060000, "action": "delete", "uuid": "1234", "annotationLifecycle": "annotations-v1", "before": null, "after": "[]"}
+	]`}
+	s, err := NewNeo4jSink(conn)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"AuditRecord": "uuid"}, conn.indexes)
+
+	r := record("1234", 0)
+	r.Before = nil
+	require.NoError(t, s.Append(r))
+	props := conn.queries[0].Parameters["props"].(neoism.Props)
+	assert.Equal(t, start.UnixNano()/int64(time.Millisecond), props["time"])
+	assert.NotContains(t, props, "before", "Annotations that could not be read should not be stored as none")
+
+	records, err := s.Query(Query{UUID: "1234", Lifecycle: "annotations-v1", From: start})
+	require.NoError(t, err)
+	query := conn.queries[1]
+	assert.Contains(t, query.Statement, "r.annotationLifecycle = {lifecycle} AND r.time >= {from}")
+	assert.NotContains(t, query.Statement, "{to}")
+	assert.Equal(t, start.UnixNano()/int64(time.Millisecond), query.Parameters["from"])
+
+	require.Len(t, records, 2)
+	assert.Equal(t, annotations.Annotations{}, records[0].Before)
+	assert.Equal(t, mentions, records[0].After)
+	assert.Equal(t, time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC), records[0].Time)
+	assert.Nil(t, records[1].Before)
+	assert.Equal(t, annotations.Annotations{}, records[1].After)
+}
+
+type recordingProducer struct {
+	kafka.Producer
+	messages []kafka.FTMessage
+	shutdown bool
+}
+
+func (p *recordingProducer) SendMessage(message kafka.FTMessage) error {
+	p.messages = append(p.messages, message)
+	return nil
+}
+
+func (p *recordingProducer) Shutdown() {
+	p.shutdown = true
+}
+
+func TestKafkaSink(t *testing.T) {
+	producer := &recordingProducer{}
+	s := NewKafkaSink(producer)
+	r := record("1234", 0)
+	r.OriginSystem = "http://cmdb.ft.com/systems/pac"
+	require.NoError(t, s.Append(r))
+
+	require.Len(t, producer.messages, 1)
+	m := producer.messages[0]
+	assert.Equal(t, r.ID, m.Headers["Message-Id"])
+	assert.Equal(t, MessageType, m.Headers["Message-Type"])
+	assert.Equal(t, "tid_test", m.Headers["X-Request-Id"])
+	assert.Equal(t, "http://cmdb.ft.com/systems/pac", m.Headers["Origin-System-Id"])
+	var sent Record
+	require.NoError(t, json.Unmarshal([]byte(m.Body), &sent))
+	assert.Equal(t, r, sent)
+
+	_, err := s.Query(Query{UUID: "1234"})
+	assert.Equal(t, ErrQueryNotSupported, err)
+	assert.NoError(t, s.Close())
+	assert.True(t, producer.shutdown)
+}
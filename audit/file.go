@@ -0,0 +1,174 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rotatedSuffix is the time format of the suffix added to rotated files, which sort in the order they were rotated
+const rotatedSuffix = "20060102T150405.000000000"
+
+// FileSink appends records as JSON lines to a local file. Once the file reaches maxBytes it is renamed with the time of
+// the rotation as suffix, and only the newest maxFiles rotated files are kept.
+type FileSink struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+	now      func() time.Time
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileSink opens the file at path for appending, creating it when needed. A maxBytes of 0 never rotates the file,
+// and a maxFiles of 0 keeps every rotated file.
+func NewFileSink(path string, maxBytes int64, maxFiles int) (*FileSink, error) {
+	s := &FileSink{path: path, maxBytes: maxBytes, maxFiles: maxFiles, now: time.Now}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("error opening audit log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("error opening audit log file: %w", err)
+	}
+	s.file = f
+	s.size = info.Size()
+
+	// a line cut short by a crash is ended, so the next record starts on a line of its own
+	if s.size > 0 {
+		last := make([]byte, 1)
+		if r, err := os.Open(s.path); err == nil {
+			_, err = r.ReadAt(last, s.size-1)
+			r.Close()
+			if err == nil && last[0] != '\n' {
+				n, _ := f.Write([]byte{'\n'})
+				s.size += int64(n)
+			}
+		}
+	}
+	return nil
+}
+
+// Append writes the record and syncs the file, so records are not lost when the service stops
+func (s *FileSink) Append(r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxBytes > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxBytes {
+		if err = s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("error writing audit log file: %w", err)
+	}
+	return s.file.Sync()
+}
+
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("error closing audit log file: %w", err)
+	}
+	if err := os.Rename(s.path, s.path+"."+s.now().UTC().Format(rotatedSuffix)); err != nil {
+		return fmt.Errorf("error rotating audit log file: %w", err)
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+
+	rotated, err := s.rotated()
+	if err != nil {
+		return err
+	}
+	if s.maxFiles > 0 && len(rotated) > s.maxFiles {
+		for _, old := range rotated[:len(rotated)-s.maxFiles] {
+			if err = os.Remove(old); err != nil {
+				return fmt.Errorf("error removing old audit log file: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// rotated lists the rotated files, oldest first
+func (s *FileSink) rotated() ([]string, error) {
+	files, err := filepath.Glob(s.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Query reads the rotated files still kept and the current file. Lines that cannot be decoded, such as a line cut short
+// by a crash, are skipped.
+func (s *FileSink) Query(q Query) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.rotated()
+	if err != nil {
+		return nil, err
+	}
+	records := []Record{}
+	for _, path := range append(files, s.path) {
+		if records, err = readRecords(path, q, records); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+func readRecords(path string, q Query, records []Record) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading audit log file: %w", err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		var r Record
+		if len(line) > 0 && json.Unmarshal(line, &r) == nil && q.matches(r) {
+			records = append(records, r)
+		}
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading audit log file: %w", err)
+		}
+	}
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,49 @@
+package audit
+
+import (
+	"encoding/json"
+
+	"github.com/Financial-Times/kafka-client-go/kafka"
+)
+
+// MessageType is the Message-Type header of the audit messages
+const MessageType = "annotations-audit"
+
+// KafkaSink sends records as messages to the topic of its producer, for a downstream system to keep.
+// Records cannot be queried back from it.
+type KafkaSink struct {
+	producer kafka.Producer
+}
+
+// NewKafkaSink returns a sink sending records with the producer
+func NewKafkaSink(producer kafka.Producer) *KafkaSink {
+	return &KafkaSink{producer: producer}
+}
+
+// Append sends the record, with the record id as Message-Id
+func (s *KafkaSink) Append(r Record) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{
+		"X-Request-Id":      r.TransactionID,
+		"Message-Id":        r.ID,
+		"Message-Timestamp": r.Time.Format("2006-01-02T15:04:05.000Z0700"),
+		"Message-Type":      MessageType,
+		"Content-Type":      "application/json",
+		"Origin-System-Id":  r.OriginSystem,
+	}
+	return s.producer.SendMessage(kafka.NewFTMessage(headers, string(body)))
+}
+
+// Query is not supported
+func (s *KafkaSink) Query(q Query) ([]Record, error) {
+	return nil, ErrQueryNotSupported
+}
+
+// Close flushes the producer
+func (s *KafkaSink) Close() error {
+	s.producer.Shutdown()
+	return nil
+}
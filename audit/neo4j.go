@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+
+	"github.com/Financial-Times/neo-utils-go/neoutils"
+	"github.com/jmcvetta/neoism"
+)
+
+// Neo4jSink stores records as AuditRecord nodes, apart from the content and concepts. The time is stored in
+// milliseconds since the epoch, and the annotations before and after as JSON.
+type Neo4jSink struct {
+	conn neoutils.NeoConnection
+}
+
+// neoRecord is a record as returned by the queries
+type neoRecord struct {
+	ID            string  `json:"id"`
+	Time          int64   `json:"time"`
+	Action        string  `json:"action"`
+	Source        string  `json:"source"`
+	TransactionID string  `json:"transactionId"`
+	OriginSystem  string  `json:"originSystem"`
+	Caller        string  `json:"caller"`
+	Lifecycle     string  `json:"annotationLifecycle"`
+	UUID          string  `json:"uuid"`
+	Before        *string `json:"before"`
+	After         string  `json:"after"`
+}
+
+// NewNeo4jSink returns a sink storing records in Neo4j, indexing them by content UUID
+func NewNeo4jSink(conn neoutils.NeoConnection) (*Neo4jSink, error) {
+	if err := conn.EnsureIndexes(map[string]string{"AuditRecord": "uuid"}); err != nil {
+		return nil, fmt.Errorf("error creating the index of audit records: %w", err)
+	}
+	return &Neo4jSink{conn: conn}, nil
+}
+
+// Append creates the node of the record
+func (s *Neo4jSink) Append(r Record) error {
+	after, err := json.Marshal(r.After)
+	if err != nil {
+		return err
+	}
+	props := neoism.Props{
+		"id":                  r.ID,
+		"time":                r.Time.UnixNano() / int64(time.Millisecond),
+		"action":              r.Action,
+		"source":              r.Source,
+		"transactionId":       r.TransactionID,
+		"originSystem":        r.OriginSystem,
+		"caller":              r.Caller,
+		"annotationLifecycle": r.Lifecycle,
+		"uuid":                r.UUID,
+		"after":               string(after),
+	}
+	if r.Before != nil {
+		before, err := json.Marshal(r.Before)
+		if err != nil {
+			return err
+		}
+		props["before"] = string(before)
+	}
+
+	query := &neoism.CypherQuery{
+		Statement:  `CREATE (r:AuditRecord) SET r = {props}`,
+		Parameters: neoism.Props{"props": props},
+	}
+	if err = s.conn.CypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return fmt.Errorf("error creating audit record in Neo4j: %w", err)
+	}
+	return nil
+}
+
+// Query matches the nodes of the content in the time range
+func (s *Neo4jSink) Query(q Query) ([]Record, error) {
+	params := neoism.Props{"uuid": q.UUID}
+	where := ""
+	if q.Lifecycle != "" {
+		where += ` AND r.annotationLifecycle = {lifecycle}`
+		params["lifecycle"] = q.Lifecycle
+	}
+	if !q.From.IsZero() {
+		where += ` AND r.time >= {from}`
+		params["from"] = q.From.UnixNano() / int64(time.Millisecond)
+	}
+	if !q.To.IsZero() {
+		where += ` AND r.time < {to}`
+		params["to"] = q.To.UnixNano() / int64(time.Millisecond)
+	}
+
+	var results []neoRecord
+	query := &neoism.CypherQuery{
+		Statement: `MATCH (r:AuditRecord {uuid: {uuid}})
+			WHERE true` + where + `
+			RETURN r.id AS id, r.time AS time, r.action AS action, r.source AS source, r.transactionId AS transactionId,
+				r.originSystem AS originSystem, r.caller AS caller, r.annotationLifecycle AS annotationLifecycle,
+				r.uuid AS uuid, r.before AS before, r.after AS after
+			ORDER BY r.time`,
+		Parameters: params,
+		Result:     &results,
+	}
+	if err := s.conn.CypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return nil, fmt.Errorf("error querying audit records in Neo4j: %w", err)
+	}
+
+	records := make([]Record, 0, len(results))
+	for _, result := range results {
+		r := Record{
+			ID:            result.ID,
+			Time:          time.Unix(0, result.Time*int64(time.Millisecond)).UTC(),
+			Action:        result.Action,
+			Source:        result.Source,
+			TransactionID: result.TransactionID,
+			OriginSystem:  result.OriginSystem,
+			Caller:        result.Caller,
+			Lifecycle:     result.Lifecycle,
+			UUID:          result.UUID,
+		}
+		if result.Before != nil {
+			r.Before = annotations.Annotations{}
+			if err := json.Unmarshal([]byte(*result.Before), &r.Before); err != nil {
+				return nil, fmt.Errorf("error decoding audit record %s: %w", r.ID, err)
+			}
+		}
+		if err := json.Unmarshal([]byte(result.After), &r.After); err != nil {
+			return nil, fmt.Errorf("error decoding audit record %s: %w", r.ID, err)
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// Close does nothing, the connection is shared with the annotations service
+func (s *Neo4jSink) Close() error {
+	return nil
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+)
+
+// APIKeyHeader is the request header carrying an API key
+const APIKeyHeader = "X-Api-Key"
+
+// apiKeyAuthenticator identifies clients by the SHA-256 digest of their API key, so the keys themselves are never configured
+type apiKeyAuthenticator struct {
+	clientsByDigest map[string]string
+}
+
+func (a apiKeyAuthenticator) Authenticate(r *http.Request) (string, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return "", ErrNoCredentials
+	}
+
+	digest := sha256.Sum256([]byte(key))
+	client := ""
+	for configured, c := range a.clientsByDigest {
+		expected, err := hex.DecodeString(configured)
+		if err == nil && subtle.ConstantTimeCompare(expected, digest[:]) == 1 {
+			client = c
+		}
+	}
+	if client == "" {
+		return "", invalidCredentials("unknown API key")
+	}
+	return client, nil
+}
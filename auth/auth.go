@@ -0,0 +1,156 @@
+// Package auth authenticates the clients of the API and authorises what they may do with each annotation lifecycle.
+// Clients are identified by API keys, HMAC-signed requests or JWTs verified against a local JWKS file, and a policy
+// grants each client the HTTP methods it may use on a set of lifecycles, and the admin endpoints.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request carries no credentials it understands
+var ErrNoCredentials = errors.New("no credentials supplied")
+
+// InvalidCredentialsError is returned when a request carries credentials that are malformed, unknown or expired
+type InvalidCredentialsError struct {
+	msg string
+}
+
+func (e InvalidCredentialsError) Error() string {
+	return e.msg
+}
+
+func invalidCredentials(format string, args ...interface{}) error {
+	return InvalidCredentialsError{msg: fmt.Sprintf(format, args...)}
+}
+
+// ForbiddenError is returned when an authenticated client is not granted the method on the lifecycle,
+// or the admin endpoints when the lifecycle is empty
+type ForbiddenError struct {
+	Client    string
+	Method    string
+	Lifecycle string
+}
+
+func (e ForbiddenError) Error() string {
+	if e.Lifecycle == "" {
+		return fmt.Sprintf("client %s may not use the admin endpoints", e.Client)
+	}
+	return fmt.Sprintf("client %s may not %s annotations in lifecycle %s", e.Client, e.Method, e.Lifecycle)
+}
+
+// Authenticator identifies the client that sent a request.
+// It returns ErrNoCredentials when the request has none of the credentials it handles,
+// and an InvalidCredentialsError when they do not identify a known client.
+type Authenticator interface {
+	Authenticate(r *http.Request) (client string, err error)
+}
+
+// Grant allows the use of some HTTP methods on some lifecycles. A lifecycle of "*" matches every lifecycle.
+// Admin allows every method on the admin endpoints, which belong to no lifecycle.
+type Grant struct {
+	Lifecycles []string `json:"lifecycles"`
+	Methods    []string `json:"methods"`
+	Admin      bool     `json:"admin"`
+}
+
+func (g Grant) allows(method string, lifecycle string) bool {
+	return contains(g.Methods, method) && (contains(g.Lifecycles, lifecycle) || contains(g.Lifecycles, "*"))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Guard decides whether a request may use a lifecycle
+type Guard struct {
+	authenticators []Authenticator
+	grants         map[string][]Grant
+	openReads      bool
+}
+
+// NewGuard returns a Guard that identifies clients with the authenticators, tried in order, and authorises them with
+// the grants of each client. When openReads is set, GET requests are allowed without credentials.
+func NewGuard(grants map[string][]Grant, openReads bool, authenticators ...Authenticator) *Guard {
+	return &Guard{authenticators: authenticators, grants: grants, openReads: openReads}
+}
+
+// Authorise returns the client that sent the request once it is allowed to use the lifecycle with the request method.
+// The client is empty for reads allowed without credentials.
+func (g *Guard) Authorise(r *http.Request, lifecycle string) (string, error) {
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	if g.openReads && method == http.MethodGet {
+		return "", nil
+	}
+
+	client, err := g.authenticate(r)
+	if err != nil {
+		return "", err
+	}
+	for _, grant := range g.grants[client] {
+		if grant.allows(method, lifecycle) {
+			return client, nil
+		}
+	}
+	return client, ForbiddenError{Client: client, Method: method, Lifecycle: lifecycle}
+}
+
+// AuthoriseAdmin returns the client that sent the request once it is granted the admin endpoints.
+// Credentials are required whatever the method, as the admin endpoints are never open to reads.
+func (g *Guard) AuthoriseAdmin(r *http.Request) (string, error) {
+	client, err := g.authenticate(r)
+	if err != nil {
+		return "", err
+	}
+	for _, grant := range g.grants[client] {
+		if grant.Admin {
+			return client, nil
+		}
+	}
+	return client, ForbiddenError{Client: client, Method: r.Method}
+}
+
+// AuthoriseClient returns the client that sent the request once it is the given client, or is granted the admin
+// endpoints. It lets clients follow up on what they submitted.
+func (g *Guard) AuthoriseClient(r *http.Request, owner string) (string, error) {
+	client, err := g.AuthoriseAdmin(r)
+	if _, forbidden := err.(ForbiddenError); forbidden && owner != "" && client == owner {
+		return client, nil
+	}
+	return client, err
+}
+
+func (g *Guard) authenticate(r *http.Request) (string, error) {
+	for _, a := range g.authenticators {
+		client, err := a.Authenticate(r)
+		if err == ErrNoCredentials {
+			continue
+		}
+		return client, err
+	}
+	return "", ErrNoCredentials
+}
+
+type clientKey struct{}
+
+// NewContext returns a copy of ctx carrying the client a request was authorised for
+func NewContext(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// ClientFrom returns the client a request was authorised for, empty when it was not authenticated
+func ClientFrom(ctx context.Context) string {
+	client, _ := ctx.Value(clientKey{}).(string)
+	return client
+}
@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	nveKey    = "next-video-secret-key"
+	nveSecret = "next-video-hmac-secret"
+)
+
+func sha256Hex(value string) string {
+	digest := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(digest[:])
+}
+
+func testConfig() Config {
+	return Config{Clients: map[string]ClientConfig{
+		"next-video-editor": {
+			APIKeySHA256: []string{sha256Hex(nveKey)},
+			HMACKeys:     map[string]string{"nve-1": nveSecret},
+			Grants:       []Grant{{Lifecycles: []string{"annotations-next-video"}, Methods: []string{"PUT", "DELETE"}}},
+		},
+		"admin": {
+			APIKeySHA256: []string{sha256Hex("admin-key")},
+			Grants:       []Grant{{Lifecycles: []string{"*"}, Methods: []string{"GET", "PUT", "DELETE"}}, {Admin: true}},
+		},
+	}}
+}
+
+func TestGuardAuthorisesAPIKeys(t *testing.T) {
+	guard, err := testConfig().Guard(false)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		method    string
+		key       string
+		lifecycle string
+		client    string
+		err       error
+	}{
+		{"granted", "PUT", nveKey, "annotations-next-video", "next-video-editor", nil},
+		{"other lifecycle", "PUT", nveKey, "annotations-v1", "next-video-editor", ForbiddenError{Client: "next-video-editor", Method: "PUT", Lifecycle: "annotations-v1"}},
+		{"method not granted", "GET", nveKey, "annotations-next-video", "next-video-editor", ForbiddenError{Client: "next-video-editor", Method: "GET", Lifecycle: "annotations-next-video"}},
+		{"wildcard lifecycle", "DELETE", "admin-key", "annotations-pac", "admin", nil},
+		{"HEAD is a read", "HEAD", "admin-key", "annotations-pac", "admin", nil},
+		{"unknown key", "PUT", "guess", "annotations-next-video", "", InvalidCredentialsError{msg: "unknown API key"}},
+		{"no credentials", "PUT", "", "annotations-next-video", "", ErrNoCredentials},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, "/content/1234/annotations/"+test.lifecycle, nil)
+			if test.key != "" {
+				r.Header.Set(APIKeyHeader, test.key)
+			}
+			client, err := guard.Authorise(r, test.lifecycle)
+			assert.Equal(t, test.client, client)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
+
+func TestGuardOpenReads(t *testing.T) {
+	guard, err := testConfig().Guard(true)
+	require.NoError(t, err)
+
+	client, err := guard.Authorise(httptest.NewRequest("GET", "/content/1234/annotations/annotations-v1", nil), "annotations-v1")
+	assert.NoError(t, err)
+	assert.Empty(t, client)
+
+	_, err = guard.Authorise(httptest.NewRequest("PUT", "/content/1234/annotations/annotations-v1", nil), "annotations-v1")
+	assert.Equal(t, ErrNoCredentials, err)
+}
+
+func signedRequest(t *testing.T, keyID string, secret string, date time.Time, body string) *http.Request {
+	r := httptest.NewRequest("PUT", "/content/1234/annotations/annotations-next-video?x=1", bytes.NewBufferString(body))
+	r.Header.Set("Date", date.UTC().Format(http.TimeFormat))
+	signature, err := SignRequest(r, []byte(secret))
+	require.NoError(t, err)
+	r.Header.Set("Authorization", fmt.Sprintf("%s keyId=%s,signature=%s", HMACScheme, keyID, base64.StdEncoding.EncodeToString(signature)))
+	return r
+}
+
+func TestGuardAuthorisesAdmin(t *testing.T) {
+	guard, err := testConfig().Guard(true)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		method string
+		key    string
+		client string
+		err    error
+	}{
+		{"granted", "PUT", "admin-key", "admin", nil},
+		{"not granted", "PUT", nveKey, "next-video-editor", ForbiddenError{Client: "next-video-editor", Method: "PUT"}},
+		{"reads are not open", "GET", "", "", ErrNoCredentials},
+		{"unknown key", "GET", "guess", "", InvalidCredentialsError{msg: "unknown API key"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, "/__maintenance", nil)
+			if test.key != "" {
+				r.Header.Set(APIKeyHeader, test.key)
+			}
+			client, err := guard.AuthoriseAdmin(r)
+			assert.Equal(t, test.client, client)
+			assert.Equal(t, test.err, err)
+		})
+	}
+	assert.EqualError(t, ForbiddenError{Client: "next-video-editor", Method: "PUT"}, "client next-video-editor may not use the admin endpoints")
+}
+
+func TestGuardAuthorisesClient(t *testing.T) {
+	guard, err := testConfig().Guard(true)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		key    string
+		owner  string
+		client string
+		err    error
+	}{
+		{"owner", nveKey, "next-video-editor", "next-video-editor", nil},
+		{"admin", "admin-key", "next-video-editor", "admin", nil},
+		{"other client", nveKey, "admin", "next-video-editor", ForbiddenError{Client: "next-video-editor", Method: "GET"}},
+		{"unknown owner", nveKey, "", "next-video-editor", ForbiddenError{Client: "next-video-editor", Method: "GET"}},
+		{"reads are not open", "", "next-video-editor", "", ErrNoCredentials},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/__jobs/1", nil)
+			if test.key != "" {
+				r.Header.Set(APIKeyHeader, test.key)
+			}
+			client, err := guard.AuthoriseClient(r, test.owner)
+			assert.Equal(t, test.client, client)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	now := time.Date(2020, 3, 4, 10, 0, 0, 0, time.UTC)
+	authenticator := hmacAuthenticator{
+		keys: map[string]HMACKey{"nve-1": {Client: "next-video-editor", Secret: []byte(nveSecret)}},
+		now:  func() time.Time { return now },
+	}
+
+	client, err := authenticator.Authenticate(signedRequest(t, "nve-1", nveSecret, now, `[{"thing":{}}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, "next-video-editor", client)
+
+	r := signedRequest(t, "nve-1", nveSecret, now, `[{"thing":{}}]`)
+	_, err = authenticator.Authenticate(r)
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(r.Body)
+	assert.Equal(t, `[{"thing":{}}]`, string(body), "The body should still be readable after authentication")
+
+	_, err = authenticator.Authenticate(signedRequest(t, "nve-1", "wrong secret", now, "{}"))
+	assert.EqualError(t, err, "HMAC signature does not match the request")
+
+	_, err = authenticator.Authenticate(signedRequest(t, "nve-2", nveSecret, now, "{}"))
+	assert.EqualError(t, err, "unknown HMAC key nve-2")
+
+	_, err = authenticator.Authenticate(signedRequest(t, "nve-1", nveSecret, now.Add(-10*time.Minute), "{}"))
+	assert.EqualError(t, err, "Date of the signed request is more than 5m0s away")
+
+	tampered := signedRequest(t, "nve-1", nveSecret, now, "{}")
+	tampered.Body = ioutil.NopCloser(bytes.NewBufferString("[]"))
+	_, err = authenticator.Authenticate(tampered)
+	assert.EqualError(t, err, "HMAC signature does not match the request")
+
+	_, err = authenticator.Authenticate(httptest.NewRequest("PUT", "/", nil))
+	assert.Equal(t, ErrNoCredentials, err)
+
+	authenticator.maxBodyBytes = 4
+	_, err = authenticator.Authenticate(signedRequest(t, "nve-1", nveSecret, now, `[{"thing":{}}]`))
+	assert.Equal(t, BodyTooLargeError{MaxBytes: 4}, err, "Bodies should not be read past the limit to verify signatures")
+}
+
+func TestConfigRejectsSharedCredentials(t *testing.T) {
+	c := testConfig()
+	c.Clients["copycat"] = ClientConfig{APIKeySHA256: []string{sha256Hex("admin-key")}}
+	_, err := c.Guard(false)
+	assert.Error(t, err)
+}
+
+func TestLoadGuard(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := fmt.Sprintf(`{
+		"jwt": {"jwksPath": "jwks.json", "audience": "annotations-rw"},
+		"clients": {"next-video-editor": {"apiKeySha256": ["%s"], "grants": [{"lifecycles": ["annotations-next-video"], "methods": ["PUT"]}]}}
+	}`, sha256Hex(nveKey))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "auth.json"), []byte(config), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "jwks.json"), []byte(`{"keys": []}`), 0600))
+
+	guard, err := LoadGuard(filepath.Join(dir, "auth.json"), true)
+	require.NoError(t, err)
+	assert.Len(t, guard.authenticators, 3)
+
+	r := httptest.NewRequest("PUT", "/content/1234/annotations/annotations-next-video", nil)
+	r.Header.Set(APIKeyHeader, nveKey)
+	client, err := guard.Authorise(r, "annotations-next-video")
+	assert.NoError(t, err)
+	assert.Equal(t, "next-video-editor", client)
+
+	_, err = LoadGuard(filepath.Join(dir, "missing.json"), true)
+	assert.Error(t, err)
+}
+
+func TestClientContext(t *testing.T) {
+	assert.Equal(t, "", ClientFrom(context.Background()))
+	assert.Equal(t, "methode", ClientFrom(NewContext(context.Background(), "methode")))
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+// Config is the content of the auth configuration file: how JWTs are verified and, for each client,
+// its credentials and grants. MaxSignedBodyBytes caps the body read to verify HMAC signatures, and defaults to
+// DefaultMaxSignedBodyBytes.
+type Config struct {
+	JWT                JWTConfig               `json:"jwt"`
+	Clients            map[string]ClientConfig `json:"clients"`
+	MaxSignedBodyBytes int64                   `json:"maxSignedBodyBytes"`
+}
+
+// JWTConfig describes the JWTs accepted. JWTs are not accepted when JWKSPath is empty.
+// A relative JWKSPath is resolved against the directory of the configuration file.
+type JWTConfig struct {
+	JWKSPath string `json:"jwksPath"`
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+}
+
+// ClientConfig holds the credentials identifying a client and what it is granted.
+// API keys are given as hex encoded SHA-256 digests; HMAC keys map a key id to its secret.
+type ClientConfig struct {
+	APIKeySHA256 []string          `json:"apiKeySha256"`
+	HMACKeys     map[string]string `json:"hmacKeys"`
+	JWTSubjects  []string          `json:"jwtSubjects"`
+	Grants       []Grant           `json:"grants"`
+}
+
+// LoadGuard reads the auth configuration file and returns the Guard it describes
+func LoadGuard(path string, openReads bool) (*Guard, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading auth configuration file: %w", err)
+	}
+	var c Config
+	if err = json.Unmarshal(file, &c); err != nil {
+		return nil, fmt.Errorf("error parsing auth configuration file: %w", err)
+	}
+	if c.JWT.JWKSPath != "" && !filepath.IsAbs(c.JWT.JWKSPath) {
+		c.JWT.JWKSPath = filepath.Join(filepath.Dir(path), c.JWT.JWKSPath)
+	}
+	return c.Guard(openReads)
+}
+
+// Guard returns the Guard described by the configuration
+func (c Config) Guard(openReads bool) (*Guard, error) {
+	apiKeys := apiKeyAuthenticator{clientsByDigest: map[string]string{}}
+	hmacKeys := hmacAuthenticator{keys: map[string]HMACKey{}, now: time.Now, maxBodyBytes: c.MaxSignedBodyBytes}
+	if hmacKeys.maxBodyBytes == 0 {
+		hmacKeys.maxBodyBytes = DefaultMaxSignedBodyBytes
+	}
+	jwts := jwtAuthenticator{issuer: c.JWT.Issuer, audience: c.JWT.Audience, clientsBySubject: map[string]string{}, now: time.Now}
+	grants := map[string][]Grant{}
+
+	for client, cc := range c.Clients {
+		for _, digest := range cc.APIKeySHA256 {
+			if other, found := apiKeys.clientsByDigest[digest]; found {
+				return nil, fmt.Errorf("API key of client %s is also used by %s", client, other)
+			}
+			apiKeys.clientsByDigest[digest] = client
+		}
+		for id, secret := range cc.HMACKeys {
+			if other, found := hmacKeys.keys[id]; found {
+				return nil, fmt.Errorf("HMAC key %s of client %s is also used by %s", id, client, other.Client)
+			}
+			hmacKeys.keys[id] = HMACKey{Client: client, Secret: []byte(secret)}
+		}
+		for _, subject := range cc.JWTSubjects {
+			if other, found := jwts.clientsBySubject[subject]; found {
+				return nil, fmt.Errorf("JWT subject %s of client %s is also used by %s", subject, client, other)
+			}
+			jwts.clientsBySubject[subject] = client
+		}
+		grants[client] = cc.Grants
+	}
+
+	authenticators := []Authenticator{apiKeys, hmacKeys}
+	if c.JWT.JWKSPath != "" {
+		jwks, err := LoadJWKS(c.JWT.JWKSPath)
+		if err != nil {
+			return nil, err
+		}
+		jwts.jwks = jwks
+		authenticators = append(authenticators, jwts)
+	}
+	return NewGuard(grants, openReads, authenticators...), nil
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HMACScheme is the Authorization scheme of HMAC-signed requests:
+//
+//	Authorization: HMAC-SHA256 keyId=<key id>,signature=<base64 signature>
+//
+// The signature is the HMAC-SHA256, with the secret of the key, of the request method, request URI, Date header and
+// hex encoded SHA-256 digest of the body, each followed by a newline except the last.
+const HMACScheme = "HMAC-SHA256"
+
+// maxClockSkew is how far the Date of a signed request may be from the time it is received
+const maxClockSkew = 5 * time.Minute
+
+// DefaultMaxSignedBodyBytes is the largest body read to verify the signature of a request, unless configured otherwise
+const DefaultMaxSignedBodyBytes = 10 << 20
+
+// BodyTooLargeError is returned when the body of a signed request is larger than is read to verify its signature
+type BodyTooLargeError struct {
+	MaxBytes int64
+}
+
+func (e BodyTooLargeError) Error() string {
+	return fmt.Sprintf("request body is larger than the maximum of %d bytes", e.MaxBytes)
+}
+
+// HMACKey is a shared secret used to sign requests
+type HMACKey struct {
+	Client string
+	Secret []byte
+}
+
+type hmacAuthenticator struct {
+	keys         map[string]HMACKey
+	now          func() time.Time
+	maxBodyBytes int64
+}
+
+func (a hmacAuthenticator) Authenticate(r *http.Request) (string, error) {
+	params, found := authorizationParams(r, HMACScheme)
+	if !found {
+		return "", ErrNoCredentials
+	}
+
+	key, found := a.keys[params["keyId"]]
+	if !found {
+		return "", invalidCredentials("unknown HMAC key %s", params["keyId"])
+	}
+	signature, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil || len(signature) == 0 {
+		return "", invalidCredentials("HMAC signature is not base64 encoded")
+	}
+
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return "", invalidCredentials("signed requests need a valid Date header")
+	}
+	if skew := a.now().Sub(date); skew > maxClockSkew || skew < -maxClockSkew {
+		return "", invalidCredentials("Date of the signed request is more than %v away", maxClockSkew)
+	}
+
+	// the body is buffered to be hashed, so it is capped before the signature proves the client knows the secret
+	if r.Body != nil && a.maxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(nil, r.Body, a.maxBodyBytes)
+	}
+	expected, err := SignRequest(r, key.Secret)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return "", BodyTooLargeError{MaxBytes: a.maxBodyBytes}
+	}
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal(signature, expected) {
+		return "", invalidCredentials("HMAC signature does not match the request")
+	}
+	return key.Client, nil
+}
+
+// SignRequest returns the HMAC-SHA256 signature of a request, as described by HMACScheme.
+// The body of the request is read and replaced, so it can still be read by the handler.
+func SignRequest(r *http.Request, secret []byte) ([]byte, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading request body: %w", err)
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	bodyDigest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", r.Method, r.URL.RequestURI(), r.Header.Get("Date"), hex.EncodeToString(bodyDigest[:]))
+	return mac.Sum(nil), nil
+}
+
+// authorizationParams returns the comma separated key=value parameters of the Authorization header, when it uses the scheme
+func authorizationParams(r *http.Request, scheme string) (map[string]string, bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, scheme+" ") {
+		return nil, false
+	}
+
+	params := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(header, scheme+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return params, true
+}
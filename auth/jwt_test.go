@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func signJWT(t *testing.T, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		require.NoError(t, err)
+		signature = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rb):32], rb)
+		copy(signature[64-len(sb):], sb)
+	}
+	return signingInput + "." + b64(signature)
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwks, err := ParseJWKS([]byte(fmt.Sprintf(`{"keys": [
+		{"kty": "RSA", "kid": "rsa-1", "n": "%s", "e": "%s"},
+		{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": "%s", "y": "%s"}
+	]}`, b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()), b64(ecKey.X.Bytes()), b64(ecKey.Y.Bytes()))))
+	require.NoError(t, err)
+
+	now := time.Date(2020, 3, 4, 10, 0, 0, 0, time.UTC)
+	authenticator := jwtAuthenticator{
+		jwks:             jwks,
+		issuer:           "https://auth.example.com",
+		audience:         "annotations-rw",
+		clientsBySubject: map[string]string{"nve": "next-video-editor"},
+		now:              func() time.Time { return now },
+	}
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "nve", "iss": "https://auth.example.com", "aud": []string{"annotations-rw"}, "exp": now.Add(time.Hour).Unix()}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{"RS256", signJWT(t, "rsa-1", rsaKey, claims(nil)), ""},
+		{"ES256", signJWT(t, "ec-1", ecKey, claims(map[string]interface{}{"aud": "annotations-rw"})), ""},
+		{"wrong key", signJWT(t, "rsa-1", otherKey, claims(nil)), "JWT signature is not valid"},
+		{"unknown kid", signJWT(t, "rsa-2", rsaKey, claims(nil)), "JWT signed with unknown key rsa-2"},
+		{"expired", signJWT(t, "rsa-1", rsaKey, claims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()})), "JWT has expired"},
+		{"no expiry", signJWT(t, "rsa-1", rsaKey, claims(map[string]interface{}{"exp": nil})), "JWT has expired"},
+		{"not yet valid", signJWT(t, "rsa-1", rsaKey, claims(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()})), "JWT is not valid yet"},
+		{"wrong issuer", signJWT(t, "rsa-1", rsaKey, claims(map[string]interface{}{"iss": "https://evil.example.com"})), "JWT issuer https://evil.example.com is not trusted"},
+		{"wrong audience", signJWT(t, "rsa-1", rsaKey, claims(map[string]interface{}{"aud": "other"})), "JWT is not intended for this service"},
+		{"unknown subject", signJWT(t, "rsa-1", rsaKey, claims(map[string]interface{}{"sub": "someone"})), "unknown JWT subject someone"},
+		{"malformed", "not.a-jwt", "malformed JWT"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/", nil)
+			r.Header.Set("Authorization", "Bearer "+test.token)
+			client, err := authenticator.Authenticate(r)
+			if test.err == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, "next-video-editor", client)
+				return
+			}
+			assert.EqualError(t, err, test.err)
+			assert.IsType(t, InvalidCredentialsError{}, err)
+		})
+	}
+}
+
+func TestParseJWKSRejectsUnsupportedKeys(t *testing.T) {
+	_, err := ParseJWKS([]byte(`{"keys": [{"kty": "oct", "kid": "shared", "k": "c2VjcmV0"}]}`))
+	assert.EqualError(t, err, "invalid key shared in JWKS: unsupported key type oct")
+
+	_, err = ParseJWKS([]byte(`{"keys": [{"kty": "EC", "kid": "p384", "crv": "P-384", "x": "AQ", "y": "AQ"}]}`))
+	assert.EqualError(t, err, "invalid key p384 in JWKS: unsupported curve P-384")
+}
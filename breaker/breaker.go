@@ -0,0 +1,173 @@
+// Package breaker stops calls to Neo4j while it fails, for requests to fail fast rather than wait out its timeouts.
+// The breaker opens after a number of consecutive failures, rejects calls until a cool-down has passed, then lets a single
+// probe through: the breaker closes when the probe succeeds and opens again when it fails.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// State of a breaker
+type State string
+
+const (
+	Closed   State = "closed"
+	Open     State = "open"
+	HalfOpen State = "half-open"
+)
+
+// States of a breaker, in the order of the severity
+var States = []State{Closed, HalfOpen, Open}
+
+// probeWait is how long calls rejected while a probe is running are told to wait
+const probeWait = time.Second
+
+// OpenError is returned by calls rejected while the breaker is open
+type OpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("Neo4j circuit breaker is open, retry in %s", e.RetryAfter.Round(time.Millisecond))
+}
+
+// RetryAfter tells whether err is the rejection of a call by an open breaker, and how long to wait before retrying
+func RetryAfter(err error) (time.Duration, bool) {
+	var open *OpenError
+	if errors.As(err, &open) {
+		return open.RetryAfter, true
+	}
+	return 0, false
+}
+
+// Breaker tracks the failures of the calls to Neo4j. A nil *Breaker lets every call through.
+type Breaker struct {
+	mu               sync.Mutex
+	failureThreshold int
+	openTimeout      time.Duration
+	onChange         func(State)
+	state            State
+	failures         int
+	openedAt         time.Time
+	probing          bool
+}
+
+// New returns a closed breaker opening after failureThreshold consecutive failures, for openTimeout before probing.
+// onChange, if any, is called with the new state whenever the state changes.
+func New(failureThreshold int, openTimeout time.Duration, onChange func(State)) *Breaker {
+	if onChange == nil {
+		onChange = func(State) {}
+	}
+	onChange(Closed)
+	return &Breaker{failureThreshold: failureThreshold, openTimeout: openTimeout, onChange: onChange, state: Closed}
+}
+
+// State returns the state of the breaker, along with the time it opened last
+func (b *Breaker) State() (State, time.Time) {
+	if b == nil {
+		return Closed, time.Time{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.openedAt
+}
+
+// Wait blocks until the breaker lets calls through, or the context is done
+func (b *Breaker) Wait(ctx context.Context) error {
+	for {
+		wait := b.wait()
+		if wait <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+func (b *Breaker) wait() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Open:
+		return time.Until(b.openedAt.Add(b.openTimeout))
+	case HalfOpen:
+		if b.probing {
+			return probeWait
+		}
+	}
+	return 0
+}
+
+// allow lets a call through, or rejects it when the breaker is open. Once the cool-down has passed, the call let through
+// is the probe of the half-open breaker.
+func (b *Breaker) allow() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Open:
+		if wait := time.Until(b.openedAt.Add(b.openTimeout)); wait > 0 {
+			return false, &OpenError{RetryAfter: wait}
+		}
+		b.setState(HalfOpen)
+		b.probing = true
+		return true, nil
+	case HalfOpen:
+		if b.probing {
+			return false, &OpenError{RetryAfter: probeWait}
+		}
+		b.probing = true
+		return true, nil
+	}
+	return false, nil
+}
+
+// done records the outcome of a call let through. Calls failing for other reasons than Neo4j, such as writes rejected
+// before reaching it or cancelled, neither fail nor succeed and only free the probe. Calls let through before the
+// breaker opened do not change it.
+func (b *Breaker) done(probe bool, err error) {
+	failed, succeeded := neo4jFailure(err), err == nil
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+		if failed {
+			b.openedAt = time.Now()
+			b.setState(Open)
+		} else if succeeded {
+			b.failures = 0
+			b.setState(Closed)
+		}
+		return
+	}
+	if b.state != Closed {
+		return
+	}
+	if failed {
+		b.failures++
+		if b.failures >= b.failureThreshold {
+			b.openedAt = time.Now()
+			b.setState(Open)
+		}
+	} else if succeeded {
+		b.failures = 0
+	}
+}
+
+func (b *Breaker) setState(state State) {
+	if b.state != state {
+		b.state = state
+		b.onChange(state)
+	}
+}
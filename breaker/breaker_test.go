@@ -0,0 +1,158 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// errTimeout is the error of a batch that timed out reaching Neo4j
+var errTimeout = &url.Error{Op: "Post", URL: "http://localhost:7474/db/data/batch", Err: errors.New("i/o timeout")}
+
+type neo4j struct {
+	annotations.Service
+	err   error
+	calls int
+}
+
+func (s *neo4j) Write(contentUUID string, annotationLifecycle string, platformVersion string, tid string, thing interface{}) error {
+	s.calls++
+	return s.err
+}
+
+func (s *neo4j) Read(contentUUID string, tid string, annotationLifecycle string, filter annotations.ReadFilter) (interface{}, bool, error) {
+	s.calls++
+	return annotations.Annotations{}, false, s.err
+}
+
+func (s *neo4j) Export(annotationLifecycle string, handle func(annotations.ContentAnnotations) error) error {
+	s.calls++
+	if s.err != nil {
+		return s.err
+	}
+	return handle(annotations.ContentAnnotations{})
+}
+
+func TestBreaker(t *testing.T) {
+	var states []State
+	b := New(2, 50*time.Millisecond, func(state State) { states = append(states, state) })
+	neo := &neo4j{err: errTimeout}
+	s := b.Service(neo)
+
+	assert.Error(t, s.Write("1234", "annotations-v1", "v1", "tid_test", nil))
+	state, _ := b.State()
+	assert.Equal(t, Closed, state, "The breaker should stay closed until the failure threshold is reached")
+	assert.Error(t, s.Write("1234", "annotations-v1", "v1", "tid_test", nil))
+	state, openedAt := b.State()
+	assert.Equal(t, Open, state)
+	assert.False(t, openedAt.IsZero())
+
+	_, _, err := s.Read("1234", "tid_test", "annotations-v1", annotations.ReadFilter{})
+	retryAfter, open := RetryAfter(err)
+	require.True(t, open, "Calls should fail fast while the breaker is open")
+	assert.True(t, retryAfter > 0 && retryAfter <= 50*time.Millisecond)
+	assert.Equal(t, 2, neo.calls, "Neo4j should not be called while the breaker is open")
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Error(t, s.Write("1234", "annotations-v1", "v1", "tid_test", nil))
+	state, _ = b.State()
+	assert.Equal(t, Open, state, "A failed probe should open the breaker again")
+
+	time.Sleep(60 * time.Millisecond)
+	neo.err = nil
+	assert.NoError(t, s.Write("1234", "annotations-v1", "v1", "tid_test", nil))
+	state, _ = b.State()
+	assert.Equal(t, Closed, state, "A successful probe should close the breaker")
+	assert.Equal(t, []State{Closed, Open, HalfOpen, Open, HalfOpen, Closed}, states)
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	b := New(1, time.Millisecond, nil)
+	b.done(false, errTimeout)
+	time.Sleep(2 * time.Millisecond)
+
+	probe, err := b.allow()
+	require.NoError(t, err)
+	assert.True(t, probe)
+	_, err = b.allow()
+	retryAfter, open := RetryAfter(err)
+	assert.True(t, open, "Only one probe should be let through")
+	assert.Equal(t, probeWait, retryAfter)
+
+	b.done(false, errTimeout)
+	state, _ := b.State()
+	assert.Equal(t, HalfOpen, state, "Calls let through before the breaker opened should not change it")
+}
+
+func TestBreakerIgnoresInvalidCalls(t *testing.T) {
+	b := New(1, time.Minute, nil)
+	s := b.Service(&neo4j{err: annotations.ValidationError{Msg: "invalid"}})
+	for i := 0; i < 3; i++ {
+		assert.Error(t, s.Write("1234", "annotations-v1", "v1", "tid_test", nil))
+	}
+	s = b.Service(&neo4j{err: annotations.UnsupportedPredicateErr})
+	assert.Error(t, s.Write("1234", "annotations-v1", "v1", "tid_test", nil))
+
+	assert.Error(t, b.Service(&neo4j{}).Export("annotations-v1", func(annotations.ContentAnnotations) error {
+		return errors.New("client went away")
+	}))
+	state, _ := b.State()
+	assert.Equal(t, Closed, state)
+}
+
+func TestNeo4jFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		failure bool
+	}{
+		{"success", nil, false},
+		{"transport", fmt.Errorf("executing write queries in neo4j failed: %w", errTimeout), true},
+		{"server", neoism.NeoError{Message: "Java heap space", Exception: "OutOfMemoryError"}, true},
+		{"transaction", &neoism.TxError{Code: "Neo.TransientError.Transaction.DeadlockDetected"}, true},
+		{"not connected", fmt.Errorf("executing count query in neo4j failed: %w", errors.New("not connected to neo4j database")), true},
+		{"cancelled", context.Canceled, false},
+		{"cancelled request", &url.Error{Op: "Post", URL: "http://localhost:7474/db/data/batch", Err: context.Canceled}, false},
+		{"deadline", fmt.Errorf("executing write queries in neo4j failed: %w", context.DeadlineExceeded), false},
+		{"invalid", annotations.ValidationError{Msg: "invalid"}, false},
+		{"other", errors.New("content uuid is required"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.failure, neo4jFailure(test.err))
+		})
+	}
+}
+
+func TestWait(t *testing.T) {
+	b := New(1, 20*time.Millisecond, nil)
+	assert.NoError(t, b.Wait(context.Background()))
+
+	b.done(false, errTimeout)
+	start := time.Now()
+	assert.NoError(t, b.Wait(context.Background()))
+	assert.True(t, time.Since(start) >= 15*time.Millisecond, "Wait should block until the cool-down has passed")
+
+	b.openedAt = time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, b.Wait(ctx))
+}
+
+func TestDisabled(t *testing.T) {
+	var b *Breaker
+	neo := &neo4j{}
+	assert.Equal(t, neo, b.Service(neo))
+	assert.NoError(t, b.Wait(context.Background()))
+	state, _ := b.State()
+	assert.Equal(t, Closed, state)
+}
@@ -0,0 +1,112 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+
+	"github.com/jmcvetta/neoism"
+)
+
+// Service wraps an annotations service for its writes, reads, deletes, counts and exports to be stopped while the breaker
+// is open. Check is left through, so the health checks keep reporting Neo4j itself.
+func (b *Breaker) Service(s annotations.Service) annotations.Service {
+	if b == nil {
+		return s
+	}
+	return service{Service: s, b: b}
+}
+
+type service struct {
+	annotations.Service
+	b *Breaker
+}
+
+// notConnected is the message of the error of a connection still trying to reach Neo4j, whose type is not exported
+const notConnected = "not connected to neo4j database"
+
+// neo4jFailure tells whether the error is a failure to reach Neo4j or of Neo4j running the queries, rather than that of
+// the call, such as an invalid call, a cancelled context or a constraint violation
+func neo4jFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	var neoErr neoism.NeoError
+	var txErr *neoism.TxError
+	switch {
+	case errors.As(err, &netErr), errors.As(err, &neoErr), errors.As(err, &txErr):
+		return true
+	}
+	for ; err != nil; err = errors.Unwrap(err) {
+		if err == neoism.InvalidDatabase || err.Error() == notConnected {
+			return true
+		}
+	}
+	return false
+}
+
+func (s service) WithContext(ctx context.Context) annotations.Service {
+	return service{Service: annotations.WithContext(ctx, s.Service), b: s.b}
+}
+
+func (s service) Write(contentUUID string, annotationLifecycle string, platformVersion string, tid string, thing interface{}) error {
+	probe, err := s.b.allow()
+	if err != nil {
+		return err
+	}
+	err = s.Service.Write(contentUUID, annotationLifecycle, platformVersion, tid, thing)
+	s.b.done(probe, err)
+	return err
+}
+
+func (s service) Read(contentUUID string, tid string, annotationLifecycle string, filter annotations.ReadFilter) (interface{}, bool, error) {
+	probe, err := s.b.allow()
+	if err != nil {
+		return annotations.Annotations{}, false, err
+	}
+	thing, found, err := s.Service.Read(contentUUID, tid, annotationLifecycle, filter)
+	s.b.done(probe, err)
+	return thing, found, err
+}
+
+func (s service) Delete(contentUUID string, tid string, annotationLifecycle string) (bool, error) {
+	probe, err := s.b.allow()
+	if err != nil {
+		return false, err
+	}
+	found, err := s.Service.Delete(contentUUID, tid, annotationLifecycle)
+	s.b.done(probe, err)
+	return found, err
+}
+
+func (s service) Count(annotationLifecycle string, platformVersion string) (int, error) {
+	probe, err := s.b.allow()
+	if err != nil {
+		return 0, err
+	}
+	count, err := s.Service.Count(annotationLifecycle, platformVersion)
+	s.b.done(probe, err)
+	return count, err
+}
+
+// Export only records the failures of Neo4j, not those of handle, such as a client going away
+func (s service) Export(annotationLifecycle string, handle func(annotations.ContentAnnotations) error) error {
+	probe, err := s.b.allow()
+	if err != nil {
+		return err
+	}
+	var handleErr error
+	err = s.Service.Export(annotationLifecycle, func(content annotations.ContentAnnotations) error {
+		handleErr = handle(content)
+		return handleErr
+	})
+	if err != nil && err == handleErr {
+		s.b.done(probe, nil)
+	} else {
+		s.b.done(probe, err)
+	}
+	return err
+}
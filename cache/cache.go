@@ -0,0 +1,138 @@
+// Package cache keeps the annotations read from Neo4j in a least recently used cache, keyed by content UUID and
+// lifecycle, for popular content to be read without reaching Neo4j. Entries expire after a TTL and are invalidated by the
+// writes and deletes of this instance, and of the other instances when an invalidation channel is set.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Channel broadcasts the invalidations of this instance to the other instances
+type Channel interface {
+	Publish(uuid string, lifecycle string)
+}
+
+type key struct {
+	uuid      string
+	lifecycle string
+}
+
+type entry struct {
+	key     key
+	thing   interface{}
+	found   bool
+	expires time.Time
+}
+
+// Cache is a least recently used cache of the annotations read. A nil *Cache caches nothing.
+type Cache struct {
+	mu         sync.Mutex
+	size       int
+	ttl        time.Duration
+	entries    map[key]*list.Element
+	recent     *list.List
+	generation uint64
+	onLookup   func(hit bool)
+	channel    Channel
+}
+
+// New returns a cache of up to size entries, each kept for ttl. onLookup, if any, is called with the outcome of each
+// lookup, for the hit rate to be measured.
+func New(size int, ttl time.Duration, onLookup func(hit bool)) *Cache {
+	if onLookup == nil {
+		onLookup = func(bool) {}
+	}
+	return &Cache{
+		size:     size,
+		ttl:      ttl,
+		entries:  make(map[key]*list.Element),
+		recent:   list.New(),
+		onLookup: onLookup,
+	}
+}
+
+// Broadcast sets the channel the invalidations of this instance are published to. It should be set before the cache
+// is used.
+func (c *Cache) Broadcast(channel Channel) {
+	c.channel = channel
+}
+
+// TTL returns how long entries are kept, 0 when nothing is cached
+func (c *Cache) TTL() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.ttl
+}
+
+// Len returns the number of entries cached
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.recent.Len()
+}
+
+// Invalidate removes the annotations of the content for the lifecycle, without publishing the invalidation
+func (c *Cache) Invalidate(uuid string, lifecycle string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// reads started before the invalidation must not store what they read
+	c.generation++
+	if element, ok := c.entries[key{uuid, lifecycle}]; ok {
+		c.remove(element)
+	}
+}
+
+// invalidate removes the annotations of the content for the lifecycle, here and on the other instances
+func (c *Cache) invalidate(uuid string, lifecycle string) {
+	c.Invalidate(uuid, lifecycle)
+	if c.channel != nil {
+		c.channel.Publish(uuid, lifecycle)
+	}
+}
+
+// get returns the entry of the key, if cached and not expired, along with the generation to store what is read otherwise
+func (c *Cache) get(k key) (*entry, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[k]
+	if !ok {
+		c.onLookup(false)
+		return nil, c.generation
+	}
+	e := element.Value.(*entry)
+	if time.Now().After(e.expires) {
+		c.remove(element)
+		c.onLookup(false)
+		return nil, c.generation
+	}
+	c.recent.MoveToFront(element)
+	c.onLookup(true)
+	return e, c.generation
+}
+
+// put stores what was read, unless the cache was invalidated since the read started
+func (c *Cache) put(k key, thing interface{}, found bool, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	e := &entry{key: k, thing: thing, found: found, expires: time.Now().Add(c.ttl)}
+	if element, ok := c.entries[k]; ok {
+		element.Value = e
+		c.recent.MoveToFront(element)
+		return
+	}
+	c.entries[k] = c.recent.PushFront(e)
+	if c.recent.Len() > c.size {
+		c.remove(c.recent.Back())
+	}
+}
+
+func (c *Cache) remove(element *list.Element) {
+	c.recent.Remove(element)
+	delete(c.entries, element.Value.(*entry).key)
+}
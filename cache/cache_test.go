@@ -0,0 +1,199 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+
+	logger "github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// neo4j counts the reads reaching it
+type neo4j struct {
+	annotations.Service
+	anns  annotations.Annotations
+	err   error
+	reads int
+}
+
+func (s *neo4j) Read(contentUUID string, tid string, annotationLifecycle string, filter annotations.ReadFilter) (interface{}, bool, error) {
+	s.reads++
+	if s.err != nil {
+		return annotations.Annotations{}, false, s.err
+	}
+	return s.anns, len(s.anns) > 0, nil
+}
+
+func (s *neo4j) Write(contentUUID string, annotationLifecycle string, platformVersion string, tid string, thing interface{}) error {
+	s.anns = thing.(annotations.Annotations)
+	return nil
+}
+
+func (s *neo4j) Delete(contentUUID string, tid string, annotationLifecycle string) (bool, error) {
+	found := len(s.anns) > 0
+	s.anns = nil
+	return found, nil
+}
+
+var anns = annotations.Annotations{{
+	Thing:       annotations.Thing{ID: "http://api.ft.com/things/1", Types: []string{"http://www.ft.com/ontology/Topic"}},
+	Provenances: []annotations.Provenance{{Scores: []annotations.Score{{ScoringSystem: "relevance", Value: 1}}}},
+}}
+
+func TestReadThrough(t *testing.T) {
+	var hits, misses int
+	c := New(10, time.Minute, func(hit bool) {
+		if hit {
+			hits++
+		} else {
+			misses++
+		}
+	})
+	neo := &neo4j{anns: anns}
+	s := c.Service(neo)
+
+	for i := 0; i < 3; i++ {
+		thing, found, err := s.Read("1234", "tid_test", "annotations-v1", annotations.ReadFilter{})
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, anns, thing)
+	}
+	assert.Equal(t, 1, neo.reads, "Only the first read should reach Neo4j")
+	assert.Equal(t, 2, hits)
+	assert.Equal(t, 1, misses)
+
+	s.Read("1234", "tid_test", "annotations-pac", annotations.ReadFilter{})
+	s.Read("1234", "tid_test", "annotations-v1", annotations.ReadFilter{Predicates: []string{"about"}})
+	assert.Equal(t, 3, neo.reads, "Other lifecycles and filtered reads should not be served from the cache")
+}
+
+func TestReadReturnsCopies(t *testing.T) {
+	s := New(10, time.Minute, nil).Service(&neo4j{anns: anns})
+	s.Read("1234", "tid_test", "annotations-v1", annotations.ReadFilter{})
+
+	thing, _, _ := s.Read("1234", "tid_test", "annotations-v1", annotations.ReadFilter{})
+	read := thing.(annotations.Annotations)
+	read[0].Thing.Types[0] = "http://www.ft.com/ontology/Person"
+	read[0].Provenances[0].Scores[0].Value = 0
+
+	thing, _, _ = s.Read("1234", "tid_test", "annotations-v1", annotations.ReadFilter{})
+	assert.Equal(t, anns, thing, "Changes to the annotations read should not change those cached")
+}
+
+func TestReadErrorsAreNotCached(t *testing.T) {
+	neo := &neo4j{err: errors.New("unavailable")}
+	s := New(10, time.Minute, nil).Service(neo)
+	_, _, err := s.Read("1234", "tid_test", "annotations-v1", annotations.ReadFilter{})
+	assert.Error(t, err)
+	neo.err = nil
+	neo.anns = anns
+	thing, _, err := s.Read("1234", "tid_test", "annotations-v1", annotations.ReadFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, anns, thing)
+}
+
+func TestWriteAndDeleteInvalidate(t *testing.T) {
+	neo := &neo4j{}
+	s := New(10, time.Minute, nil).Service(neo)
+	_, found, _ := s.Read("1234", "tid_test", "annotations-v1", annotations.ReadFilter{})
+	assert.False(t, found)
+
+	require.NoError(t, s.Write("1234", "annotations-v1", "v1", "tid_test", anns))
+	thing, found, _ := s.Read("1234", "tid_test", "annotations-v1", annotations.ReadFilter{})
+	assert.True(t, found)
+	assert.Equal(t, anns, thing)
+
+	s.Delete("1234", "tid_test", "annotations-v1")
+	_, found, _ = s.Read("1234", "tid_test", "annotations-v1", annotations.ReadFilter{})
+	assert.False(t, found)
+	assert.Equal(t, 3, neo.reads)
+}
+
+func TestInvalidationDuringRead(t *testing.T) {
+	c := New(10, time.Minute, nil)
+	_, generation := c.get(key{"1234", "annotations-v1"})
+	c.Invalidate("1234", "annotations-v1")
+	c.put(key{"1234", "annotations-v1"}, anns, true, generation)
+	assert.Equal(t, 0, c.Len(), "Annotations read before an invalidation should not be cached")
+}
+
+func TestExpiry(t *testing.T) {
+	neo := &neo4j{anns: anns}
+	s := New(10, time.Millisecond, nil).Service(neo)
+	s.Read("1234", "tid_test", "annotations-v1", annotations.ReadFilter{})
+	time.Sleep(2 * time.Millisecond)
+	s.Read("1234", "tid_test", "annotations-v1", annotations.ReadFilter{})
+	assert.Equal(t, 2, neo.reads)
+}
+
+func TestEviction(t *testing.T) {
+	neo := &neo4j{anns: anns}
+	c := New(2, time.Minute, nil)
+	s := c.Service(neo)
+	s.Read("1", "tid_test", "annotations-v1", annotations.ReadFilter{})
+	s.Read("2", "tid_test", "annotations-v1", annotations.ReadFilter{})
+	s.Read("1", "tid_test", "annotations-v1", annotations.ReadFilter{})
+	s.Read("3", "tid_test", "annotations-v1", annotations.ReadFilter{})
+	assert.Equal(t, 2, c.Len())
+	assert.Equal(t, 3, neo.reads)
+
+	s.Read("1", "tid_test", "annotations-v1", annotations.ReadFilter{})
+	assert.Equal(t, 3, neo.reads, "The most recently used content should be kept")
+	s.Read("2", "tid_test", "annotations-v1", annotations.ReadFilter{})
+	assert.Equal(t, 4, neo.reads, "The least recently used content should be evicted")
+}
+
+func TestDisabled(t *testing.T) {
+	var c *Cache
+	neo := &neo4j{}
+	assert.Equal(t, neo, c.Service(neo))
+	assert.Equal(t, time.Duration(0), c.TTL())
+}
+
+type producer struct {
+	kafka.Producer
+	messages []kafka.FTMessage
+}
+
+func (p *producer) SendMessage(message kafka.FTMessage) error {
+	p.messages = append(p.messages, message)
+	return nil
+}
+
+type consumer struct {
+	kafka.Consumer
+	messages []kafka.FTMessage
+}
+
+func (c consumer) StartListening(handler func(message kafka.FTMessage) error) {
+	for _, message := range c.messages {
+		handler(message)
+	}
+}
+
+func TestKafkaChannel(t *testing.T) {
+	log := logger.NewUPPInfoLogger("annotations-rw")
+	p := &producer{}
+	c := New(10, time.Minute, nil)
+	c.Broadcast(NewKafkaChannel(p, consumer{}, "instance-1", log))
+	neo := &neo4j{anns: anns}
+	c.Service(neo).Write("1234", "annotations-v1", "v1", "tid_test", anns)
+	require.Len(t, p.messages, 1)
+	assert.Equal(t, MessageType, p.messages[0].Headers["Message-Type"])
+	assert.JSONEq(t, `{"uuid":"1234","annotationLifecycle":"annotations-v1"}`, p.messages[0].Body)
+
+	other := New(10, time.Minute, nil)
+	s := other.Service(neo)
+	s.Read("1234", "tid_test", "annotations-v1", annotations.ReadFilter{})
+	s.Read("5678", "tid_test", "annotations-v1", annotations.ReadFilter{})
+	own := kafka.NewFTMessage(map[string]string{instanceHeader: "instance-2"}, `{"uuid":"5678","annotationLifecycle":"annotations-v1"}`)
+	NewKafkaChannel(&producer{}, consumer{messages: append(p.messages, own)}, "instance-2", log).Listen(other)
+	assert.Equal(t, 1, other.Len(), "Invalidations of other instances should be applied, and those of the instance ignored")
+	s.Read("5678", "tid_test", "annotations-v1", annotations.ReadFilter{})
+	assert.Equal(t, 2, neo.reads)
+}
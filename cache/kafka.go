@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"encoding/json"
+	"time"
+
+	logger "github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/kafka"
+)
+
+// MessageType is the Message-Type header of the invalidation messages
+const MessageType = "annotations-cache-invalidation"
+
+// instanceHeader is the header of the instance an invalidation message comes from
+const instanceHeader = "Origin-Instance-Id"
+
+type invalidation struct {
+	UUID      string `json:"uuid"`
+	Lifecycle string `json:"annotationLifecycle"`
+}
+
+// KafkaChannel broadcasts invalidations through a Kafka topic. Every instance should consume the topic in a consumer
+// group of its own, to receive the invalidations of all the others.
+type KafkaChannel struct {
+	producer kafka.Producer
+	consumer kafka.Consumer
+	instance string
+	log      *logger.UPPLogger
+}
+
+// NewKafkaChannel returns the channel of the instance, publishing with the producer and listening with the consumer
+func NewKafkaChannel(producer kafka.Producer, consumer kafka.Consumer, instance string, log *logger.UPPLogger) *KafkaChannel {
+	return &KafkaChannel{producer: producer, consumer: consumer, instance: instance, log: log}
+}
+
+// Publish sends the invalidation to the other instances. Failures are logged, as the entries of the other instances
+// still expire after the TTL.
+func (ch *KafkaChannel) Publish(uuid string, lifecycle string) {
+	body, _ := json.Marshal(invalidation{UUID: uuid, Lifecycle: lifecycle})
+	headers := map[string]string{
+		"Message-Timestamp": time.Now().Format("2006-01-02T15:04:05.000Z0700"),
+		"Message-Type":      MessageType,
+		"Content-Type":      "application/json",
+		instanceHeader:      ch.instance,
+	}
+	if err := ch.producer.SendMessage(kafka.NewFTMessage(headers, string(body))); err != nil {
+		ch.log.WithUUID(uuid).WithError(err).Warnf("Failed to publish the cache invalidation of lifecycle %s, other instances will serve it until it expires", lifecycle)
+	}
+}
+
+// Listen invalidates the entries of the cache the other instances publish invalidations of
+func (ch *KafkaChannel) Listen(c *Cache) {
+	ch.consumer.StartListening(func(message kafka.FTMessage) error {
+		if message.Headers[instanceHeader] == ch.instance {
+			return nil
+		}
+		var inv invalidation
+		if err := json.Unmarshal([]byte(message.Body), &inv); err != nil {
+			ch.log.WithError(err).Warn("Ignoring invalid cache invalidation message")
+			return err
+		}
+		c.Invalidate(inv.UUID, inv.Lifecycle)
+		return nil
+	})
+}
+
+// Shutdown stops listening and flushes the invalidations left
+func (ch *KafkaChannel) Shutdown() {
+	ch.consumer.Shutdown()
+	ch.producer.Shutdown()
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+)
+
+// Service wraps an annotations service for its unfiltered reads to go through the cache, and its writes and deletes to
+// invalidate it
+func (c *Cache) Service(s annotations.Service) annotations.Service {
+	if c == nil {
+		return s
+	}
+	return service{Service: s, c: c}
+}
+
+type service struct {
+	annotations.Service
+	c *Cache
+}
+
+func (s service) WithContext(ctx context.Context) annotations.Service {
+	return service{Service: annotations.WithContext(ctx, s.Service), c: s.c}
+}
+
+// Read returns the cached annotations, or reads them and caches them. Filtered reads are not cached.
+func (s service) Read(contentUUID string, tid string, annotationLifecycle string, filter annotations.ReadFilter) (interface{}, bool, error) {
+	if !filter.IsZero() {
+		return s.Service.Read(contentUUID, tid, annotationLifecycle, filter)
+	}
+	k := key{contentUUID, annotationLifecycle}
+	e, generation := s.c.get(k)
+	if e != nil {
+		return clone(e.thing), e.found, nil
+	}
+	thing, found, err := s.Service.Read(contentUUID, tid, annotationLifecycle, filter)
+	if err == nil {
+		s.c.put(k, clone(thing), found, generation)
+	}
+	return thing, found, err
+}
+
+// Write invalidates the annotations of the content, whether they were written or not
+func (s service) Write(contentUUID string, annotationLifecycle string, platformVersion string, tid string, thing interface{}) error {
+	defer s.c.invalidate(contentUUID, annotationLifecycle)
+	return s.Service.Write(contentUUID, annotationLifecycle, platformVersion, tid, thing)
+}
+
+// Delete invalidates the annotations of the content, whether they were deleted or not
+func (s service) Delete(contentUUID string, tid string, annotationLifecycle string) (bool, error) {
+	defer s.c.invalidate(contentUUID, annotationLifecycle)
+	return s.Service.Delete(contentUUID, tid, annotationLifecycle)
+}
+
+// clone copies the annotations read, for the callers not to change those cached
+func clone(thing interface{}) interface{} {
+	anns, ok := thing.(annotations.Annotations)
+	if !ok || anns == nil {
+		return thing
+	}
+	copied := make(annotations.Annotations, len(anns))
+	for i, ann := range anns {
+		ann.Thing.Types = append([]string(nil), ann.Thing.Types...)
+		if ann.Provenances != nil {
+			provenances := make([]annotations.Provenance, len(ann.Provenances))
+			for j, provenance := range ann.Provenances {
+				provenance.Scores = append([]annotations.Score(nil), provenance.Scores...)
+				provenances[j] = provenance
+			}
+			ann.Provenances = provenances
+		}
+		copied[i] = ann
+	}
+	return copied
+}
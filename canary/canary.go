@@ -0,0 +1,163 @@
+// Package canary checks the annotations service end to end: it writes a synthetic annotation for a reserved piece of
+// content and lifecycle, reads it back, verifies it and deletes it, timing each step. Runs happen in the background on an
+// interval and the last result is kept, so that health checks reporting it stay cheap.
+package canary
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+)
+
+// The reserved content, concept and lifecycle the canary annotation is written for. They are left in Neo4j as Thing
+// nodes without relationships once the annotation is deleted.
+const (
+	ContentUUID     = "ca9a71e5-0000-4000-8000-000000000001"
+	ConceptUUID     = "ca9a71e5-0000-4000-8000-000000000002"
+	Lifecycle       = "annotations-canary"
+	PlatformVersion = "canary"
+)
+
+const (
+	conceptID = "http://api.ft.com/things/" + ConceptUUID
+	predicate = "mentions"
+	// relationship is the predicate of the annotation as read back
+	relationship = "MENTIONS"
+)
+
+// Step is a step of a run, such as writing the canary annotation
+type Step struct {
+	Name       string `json:"name"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Result is the outcome of a run
+type Result struct {
+	Time  time.Time `json:"time"`
+	Steps []Step    `json:"steps"`
+	Err   error     `json:"-"`
+}
+
+// Canary runs the round trip against an annotations service
+type Canary struct {
+	service annotations.Service
+	mu      sync.RWMutex
+	last    *Result
+}
+
+// New returns the canary of the service
+func New(service annotations.Service) *Canary {
+	return &Canary{service: service}
+}
+
+// Run writes, reads back and deletes the canary annotation, and keeps the result as the last one. The annotation is
+// deleted even when it could not be read back.
+func (c *Canary) Run() Result {
+	tid := fmt.Sprintf("tid_canary_%d", time.Now().UnixNano())
+	result := Result{Time: time.Now()}
+	step := func(name string, run func() error) error {
+		start := time.Now()
+		err := run()
+		s := Step{Name: name, DurationMs: time.Since(start).Milliseconds()}
+		if err != nil {
+			s.Error = err.Error()
+			if result.Err == nil {
+				result.Err = fmt.Errorf("canary %s failed: %w", name, err)
+			}
+		}
+		result.Steps = append(result.Steps, s)
+		return err
+	}
+
+	err := step("write", func() error {
+		return c.service.Write(ContentUUID, Lifecycle, PlatformVersion, tid, annotation())
+	})
+	if err == nil {
+		step("read", func() error {
+			thing, found, err := c.service.Read(ContentUUID, tid, Lifecycle, annotations.ReadFilter{})
+			if err != nil {
+				return err
+			}
+			if !found {
+				return errors.New("annotation written not found")
+			}
+			return verify(thing)
+		})
+		step("delete", func() error {
+			found, err := c.service.Delete(ContentUUID, tid, Lifecycle)
+			if err == nil && !found {
+				return errors.New("annotation written not deleted")
+			}
+			return err
+		})
+	}
+
+	c.mu.Lock()
+	c.last = &result
+	c.mu.Unlock()
+	return result
+}
+
+// Last returns the result of the last run, if any
+func (c *Canary) Last() (Result, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.last == nil {
+		return Result{}, false
+	}
+	return *c.last, true
+}
+
+// Watch runs the canary straight away, then every interval until stop is closed. Runs are skipped while paused returns
+// true, for the canary not to write while writes are not allowed.
+func (c *Canary) Watch(interval time.Duration, stop <-chan struct{}, paused func() bool) {
+	if !paused() {
+		c.Run()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !paused() {
+				c.Run()
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func annotation() annotations.Annotations {
+	return annotations.Annotations{{
+		Thing: annotations.Thing{ID: conceptID, Predicate: predicate},
+		Provenances: []annotations.Provenance{{
+			Scores: []annotations.Score{
+				{ScoringSystem: "http://api.ft.com/scoringsystem/FT-RELEVANCE-SYSTEM", Value: 0.5},
+				{ScoringSystem: "http://api.ft.com/scoringsystem/FT-CONFIDENCE-SYSTEM", Value: 0.5},
+			},
+		}},
+	}}
+}
+
+// verify checks that the annotations read back are the canary annotation
+func verify(thing interface{}) error {
+	anns, ok := thing.(annotations.Annotations)
+	if !ok {
+		return fmt.Errorf("unexpected annotations of type %T", thing)
+	}
+	if len(anns) != 1 {
+		return fmt.Errorf("expected the annotation written but read %d", len(anns))
+	}
+	if anns[0].Thing.ID != conceptID {
+		return fmt.Errorf("expected an annotation of %s but read one of %s", conceptID, anns[0].Thing.ID)
+	}
+	if anns[0].Thing.Predicate != relationship {
+		return fmt.Errorf("expected a %s annotation but read a %s one", relationship, anns[0].Thing.Predicate)
+	}
+	return nil
+}
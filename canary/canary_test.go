@@ -0,0 +1,126 @@
+package canary
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// service stores the annotations written in memory, read back as Neo4j returns them
+type service struct {
+	annotations.Service
+	written  annotations.Annotations
+	writeErr error
+	readErr  error
+	deleted  bool
+}
+
+func (s *service) Write(contentUUID string, annotationLifecycle string, platformVersion string, tid string, thing interface{}) error {
+	if s.writeErr != nil {
+		return s.writeErr
+	}
+	s.written = append(annotations.Annotations{}, thing.(annotations.Annotations)...)
+	return nil
+}
+
+func (s *service) Read(contentUUID string, tid string, annotationLifecycle string, filter annotations.ReadFilter) (interface{}, bool, error) {
+	if s.readErr != nil || len(s.written) == 0 {
+		return annotations.Annotations{}, false, s.readErr
+	}
+	read := append(annotations.Annotations{}, s.written...)
+	read[0].Thing.Predicate = "MENTIONS"
+	return read, true, nil
+}
+
+func (s *service) Delete(contentUUID string, tid string, annotationLifecycle string) (bool, error) {
+	found := len(s.written) > 0
+	s.written = nil
+	s.deleted = true
+	return found, nil
+}
+
+func stepNames(result Result) []string {
+	var names []string
+	for _, step := range result.Steps {
+		names = append(names, step.Name)
+	}
+	return names
+}
+
+func TestRun(t *testing.T) {
+	s := &service{}
+	c := New(s)
+	_, ran := c.Last()
+	assert.False(t, ran)
+
+	result := c.Run()
+	require.NoError(t, result.Err)
+	assert.Equal(t, []string{"write", "read", "delete"}, stepNames(result))
+	assert.Empty(t, s.written, "The canary annotation should be deleted")
+
+	last, ran := c.Last()
+	assert.True(t, ran)
+	assert.Equal(t, result.Time, last.Time)
+}
+
+func TestRunWriteFailure(t *testing.T) {
+	s := &service{writeErr: errors.New("constraint violated")}
+	result := New(s).Run()
+	assert.EqualError(t, result.Err, "canary write failed: constraint violated")
+	assert.Equal(t, []string{"write"}, stepNames(result))
+	assert.Equal(t, "constraint violated", result.Steps[0].Error)
+	assert.False(t, s.deleted, "Nothing should be deleted when nothing was written")
+}
+
+func TestRunReadFailure(t *testing.T) {
+	s := &service{readErr: errors.New("unavailable")}
+	result := New(s).Run()
+	assert.EqualError(t, result.Err, "canary read failed: unavailable")
+	assert.Equal(t, []string{"write", "read", "delete"}, stepNames(result))
+	assert.True(t, s.deleted, "The canary annotation should be deleted even when it could not be read back")
+}
+
+func TestVerify(t *testing.T) {
+	assert.NoError(t, verify(annotations.Annotations{{Thing: annotations.Thing{ID: conceptID, Predicate: "MENTIONS"}}}))
+	assert.Error(t, verify(annotations.Annotations{}))
+	assert.Error(t, verify(annotations.Annotations{{Thing: annotations.Thing{ID: "http://api.ft.com/things/1234", Predicate: "MENTIONS"}}}))
+	assert.Error(t, verify(annotations.Annotations{{Thing: annotations.Thing{ID: conceptID, Predicate: "ABOUT"}}}))
+}
+
+func TestWatch(t *testing.T) {
+	c := New(&service{})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.Watch(time.Hour, stop, func() bool { return false })
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		_, ran := c.Last()
+		return ran
+	}, time.Second, time.Millisecond, "The canary should run straight away")
+	close(stop)
+	<-done
+}
+
+func TestWatchPaused(t *testing.T) {
+	c := New(&service{})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.Watch(time.Millisecond, stop, func() bool { return true })
+		close(done)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	<-done
+	_, ran := c.Last()
+	assert.False(t, ran, "The canary should not run while paused")
+}
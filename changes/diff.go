@@ -0,0 +1,43 @@
+package changes
+
+import (
+	"reflect"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+)
+
+// Diff compares the annotations of a piece of content before and after a change. Annotations are matched by concept
+// and predicate; a matched annotation has changed when its provenances differ.
+func Diff(before annotations.Annotations, after annotations.Annotations) (added annotations.Annotations, removed annotations.Annotations, changed annotations.Annotations) {
+	previous := map[string]annotations.Annotation{}
+	for _, a := range before {
+		previous[key(a)] = a
+	}
+
+	seen := map[string]bool{}
+	for _, a := range after {
+		k := key(a)
+		seen[k] = true
+		old, found := previous[k]
+		switch {
+		case !found:
+			added = append(added, a)
+		case !reflect.DeepEqual(old.Provenances, a.Provenances):
+			changed = append(changed, a)
+		}
+	}
+	for _, a := range before {
+		if !seen[key(a)] {
+			removed = append(removed, a)
+		}
+	}
+	return added, removed, changed
+}
+
+func key(a annotations.Annotation) string {
+	predicate, err := annotations.PredicateName(a.Thing.Predicate)
+	if err != nil {
+		predicate = a.Thing.Predicate
+	}
+	return a.Thing.ID + " " + predicate
+}
@@ -0,0 +1,196 @@
+// Package changes keeps a live stream of the changes made to annotations, for clients that watch them without
+// connecting to Kafka. Each change says which annotations were added, removed or changed. The most recent changes are
+// kept in a bounded in-memory buffer, so clients that reconnect can resume from the last change they received.
+package changes
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+
+	logger "github.com/Financial-Times/go-logger/v2"
+)
+
+// Change types
+const (
+	Written = "annotations.written"
+	Deleted = "annotations.deleted"
+)
+
+// subscriberBuffer is the number of changes waiting to be sent to a subscriber before it is dropped
+const subscriberBuffer = 256
+
+// Change is a write or delete of the annotations of a piece of content in a lifecycle
+type Change struct {
+	ID            uint64                  `json:"id"`
+	Type          string                  `json:"type"`
+	UUID          string                  `json:"uuid"`
+	Lifecycle     string                  `json:"annotationLifecycle"`
+	TransactionID string                  `json:"transactionId"`
+	Time          time.Time               `json:"time"`
+	Added         annotations.Annotations `json:"added"`
+	Removed       annotations.Annotations `json:"removed"`
+	Changed       annotations.Annotations `json:"changed"`
+}
+
+// Reader reads the annotations of a piece of content in a lifecycle, returning none when there are none
+type Reader func(uuid string, tid string, lifecycle string) (annotations.Annotations, error)
+
+// Stream records changes and sends them to its subscribers. A nil *Stream records nothing.
+type Stream struct {
+	read Reader
+	log  *logger.UPPLogger
+	now  func() time.Time
+
+	mu          sync.Mutex
+	buffer      []Change
+	start       int
+	lastID      uint64
+	subscribers map[*Subscription]bool
+	closed      bool
+}
+
+// NewStream returns a Stream keeping the last size changes, which reads the annotations after each write with read to
+// work out what changed
+func NewStream(size int, read Reader, log *logger.UPPLogger) *Stream {
+	return &Stream{
+		read:        read,
+		log:         log,
+		now:         time.Now,
+		buffer:      make([]Change, 0, size),
+		subscribers: map[*Subscription]bool{},
+	}
+}
+
+// Written records a write of the annotations of a piece of content, given the annotations it replaced. When those
+// are nil, as they could not be read, every annotation is listed as added.
+func (s *Stream) Written(uuid string, tid string, lifecycle string, before annotations.Annotations) {
+	if s == nil {
+		return
+	}
+	after, err := s.read(uuid, tid, lifecycle)
+	if err != nil {
+		s.log.WithTransactionID(tid).WithUUID(uuid).WithError(err).Warn("Failed to read the annotations written, the change is left out of the change stream")
+		return
+	}
+	added, removed, changed := Diff(before, after)
+	s.Publish(Change{Type: Written, UUID: uuid, Lifecycle: lifecycle, TransactionID: tid, Added: added, Removed: removed, Changed: changed})
+}
+
+// Deleted records the deletion of the annotations of a piece of content, given the annotations deleted
+func (s *Stream) Deleted(uuid string, tid string, lifecycle string, before annotations.Annotations) {
+	if s == nil {
+		return
+	}
+	s.Publish(Change{Type: Deleted, UUID: uuid, Lifecycle: lifecycle, TransactionID: tid, Removed: before})
+}
+
+// Publish assigns the change the next id, keeps it in the buffer and sends it to the subscribers.
+// Subscribers too slow to keep up are dropped, and can resume from the buffer.
+func (s *Stream) Publish(c Change) Change {
+	if c.Added == nil {
+		c.Added = annotations.Annotations{}
+	}
+	if c.Removed == nil {
+		c.Removed = annotations.Annotations{}
+	}
+	if c.Changed == nil {
+		c.Changed = annotations.Annotations{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	c.ID = s.lastID
+	c.Time = s.now().UTC()
+
+	if cap(s.buffer) > 0 {
+		if len(s.buffer) < cap(s.buffer) {
+			s.buffer = append(s.buffer, c)
+		} else {
+			s.buffer[s.start] = c
+			s.start = (s.start + 1) % len(s.buffer)
+		}
+	}
+
+	for sub := range s.subscribers {
+		select {
+		case sub.changes <- c:
+		default:
+			s.log.Warn("Change stream subscriber is too slow, dropping it")
+			s.unsubscribeLocked(sub)
+		}
+	}
+	return c
+}
+
+// Subscription receives the changes published after it was made
+type Subscription struct {
+	stream  *Stream
+	changes chan Change
+}
+
+// Changes returns the channel of changes, closed when the subscriber is dropped or the stream closed
+func (sub *Subscription) Changes() <-chan Change {
+	return sub.changes
+}
+
+// Cancel stops the subscription
+func (sub *Subscription) Cancel() {
+	sub.stream.mu.Lock()
+	defer sub.stream.mu.Unlock()
+	sub.stream.unsubscribeLocked(sub)
+}
+
+// Subscribe returns the buffered changes after lastID and a subscription to the following ones. complete is false
+// when changes after lastID are missing from the buffer, because they are too old or lastID is from before a restart.
+func (s *Stream) Subscribe(lastID uint64) (backlog []Change, complete bool, sub *Subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub = &Subscription{stream: s, changes: make(chan Change, subscriberBuffer)}
+	if s.closed {
+		close(sub.changes)
+	} else {
+		s.subscribers[sub] = true
+	}
+
+	if lastID == 0 {
+		return nil, true, sub
+	}
+	complete = lastID <= s.lastID
+	for i := range s.buffer {
+		c := s.buffer[(s.start+i)%len(s.buffer)]
+		if i == 0 && c.ID > lastID+1 {
+			complete = false
+		}
+		if c.ID > lastID || lastID > s.lastID {
+			backlog = append(backlog, c)
+		}
+	}
+	if len(s.buffer) == 0 && lastID < s.lastID {
+		complete = false
+	}
+	return backlog, complete, sub
+}
+
+// Close ends every subscription, so streaming responses finish and the server can shut down
+func (s *Stream) Close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for sub := range s.subscribers {
+		s.unsubscribeLocked(sub)
+	}
+}
+
+func (s *Stream) unsubscribeLocked(sub *Subscription) {
+	if s.subscribers[sub] {
+		delete(s.subscribers, sub)
+		close(sub.changes)
+	}
+}
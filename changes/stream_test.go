@@ -0,0 +1,130 @@
+package changes
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+
+	logger "github.com/Financial-Times/go-logger/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func annotation(id string, predicate string, agentRole string) annotations.Annotation {
+	return annotations.Annotation{
+		Thing:       annotations.Thing{ID: id, Predicate: predicate},
+		Provenances: []annotations.Provenance{{AgentRole: agentRole}},
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := annotations.Annotations{
+		annotation("http://api.ft.com/things/1", "MENTIONS", "editor"),
+		annotation("http://api.ft.com/things/2", "ABOUT", "editor"),
+		annotation("http://api.ft.com/things/3", "MENTIONS", "editor"),
+	}
+	after := annotations.Annotations{
+		annotation("http://api.ft.com/things/1", "", "editor"),
+		annotation("http://api.ft.com/things/2", "about", "machine"),
+		annotation("http://api.ft.com/things/3", "about", "editor"),
+	}
+
+	added, removed, changed := Diff(before, after)
+	assert.Equal(t, annotations.Annotations{after[2]}, added)
+	assert.Equal(t, annotations.Annotations{before[2]}, removed)
+	assert.Equal(t, annotations.Annotations{after[1]}, changed)
+}
+
+func publish(s *Stream, n int) {
+	for i := 0; i < n; i++ {
+		s.Publish(Change{Type: Written, UUID: "1234"})
+	}
+}
+
+func ids(changes []Change) []uint64 {
+	var ids []uint64
+	for _, c := range changes {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+func TestSubscribeResumesFromBuffer(t *testing.T) {
+	s := NewStream(3, nil, logger.NewUPPInfoLogger("test"))
+	publish(s, 5)
+
+	tests := []struct {
+		name     string
+		lastID   uint64
+		backlog  []uint64
+		complete bool
+	}{
+		{"new subscriber", 0, nil, true},
+		{"up to date", 5, nil, true},
+		{"missed buffered changes", 3, []uint64{4, 5}, true},
+		{"missed changes no longer buffered", 1, []uint64{3, 4, 5}, false},
+		{"id from before a restart", 99, []uint64{3, 4, 5}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backlog, complete, sub := s.Subscribe(test.lastID)
+			defer sub.Cancel()
+			assert.Equal(t, test.backlog, ids(backlog))
+			assert.Equal(t, test.complete, complete)
+		})
+	}
+}
+
+func TestSubscribersGetPublishedChanges(t *testing.T) {
+	s := NewStream(3, nil, logger.NewUPPInfoLogger("test"))
+	_, _, sub := s.Subscribe(0)
+	_, _, slow := s.Subscribe(0)
+
+	publish(s, 1)
+	c := <-sub.Changes()
+	assert.Equal(t, uint64(1), c.ID)
+
+	publish(s, subscriberBuffer)
+	for i := 0; i < subscriberBuffer; i++ {
+		<-sub.Changes()
+	}
+	received := 0
+	for range slow.Changes() {
+		received++
+	}
+	assert.Equal(t, subscriberBuffer, received, "A subscriber that does not keep up should be dropped once its buffer is full")
+
+	_, _, sub = s.Subscribe(0)
+	s.Close()
+	_, open := <-sub.Changes()
+	assert.False(t, open, "Closing the stream should end the subscriptions")
+}
+
+func TestWrittenAndDeleted(t *testing.T) {
+	stored := annotations.Annotations{annotation("http://api.ft.com/things/1", "MENTIONS", "editor")}
+	var readErr error
+	s := NewStream(10, func(uuid string, tid string, lifecycle string) (annotations.Annotations, error) {
+		return stored, readErr
+	}, logger.NewUPPInfoLogger("test"))
+
+	before := stored
+	stored = annotations.Annotations{annotation("http://api.ft.com/things/2", "ABOUT", "editor")}
+	s.Written("1234", "tid_1", "annotations-v1", before)
+	s.Deleted("1234", "tid_2", "annotations-v1", stored)
+
+	readErr = errors.New("neo4j is down")
+	s.Written("1234", "tid_3", "annotations-v1", nil)
+
+	require.Len(t, s.buffer, 2, "The write that could not be read back should be left out")
+	deleted := s.buffer[1]
+	assert.Equal(t, Deleted, deleted.Type)
+	assert.Equal(t, stored, deleted.Removed)
+	assert.Equal(t, annotations.Annotations{}, deleted.Added)
+
+	written := s.buffer[0]
+	assert.Equal(t, Written, written.Type)
+	assert.Equal(t, "tid_1", written.TransactionID)
+	assert.Equal(t, annotations.Annotations{annotation("http://api.ft.com/things/2", "ABOUT", "editor")}, written.Added)
+	assert.Equal(t, annotations.Annotations{annotation("http://api.ft.com/things/1", "MENTIONS", "editor")}, written.Removed)
+}
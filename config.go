@@ -0,0 +1,307 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	logger "github.com/Financial-Times/go-logger/v2"
+)
+
+// lifecycleConfig is the content of the lifecycle configuration file: the lifecycle of each origin system,
+// the platform version of each lifecycle and the type of the messages forwarded
+type lifecycleConfig struct {
+	OriginMap    map[string]string `json:"originMap"`
+	LifecycleMap map[string]string `json:"lifecycleMap"`
+	MessageType  string            `json:"messageType"`
+}
+
+func readLifecycleConfig(jsonPath string) (lifecycleConfig, error) {
+	var c lifecycleConfig
+	file, err := ioutil.ReadFile(jsonPath)
+	if err != nil {
+		return c, fmt.Errorf("error reading configuration file: %w", err)
+	}
+
+	err = json.Unmarshal(file, &c)
+	if err != nil {
+		return c, fmt.Errorf("error marshalling config file: %w", err)
+	}
+	return c, c.validate()
+}
+
+// ConfigValidationError lists every problem found in a lifecycle configuration
+type ConfigValidationError struct {
+	Problems []string
+}
+
+func (e ConfigValidationError) Error() string {
+	return "invalid lifecycle configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// validate checks that the configuration is complete and consistent: every origin system maps to a lifecycle that
+// has a platform version, and every lifecycle is written by at least one origin system. HTTP writes to a lifecycle
+// written by several origin systems must say which one they come from.
+func (c lifecycleConfig) validate() error {
+	var problems []string
+	if c.MessageType == "" {
+		problems = append(problems, "message type is not configured")
+	}
+	if len(c.OriginMap) == 0 {
+		problems = append(problems, "originMap has no origin systems")
+	}
+	if len(c.LifecycleMap) == 0 {
+		problems = append(problems, "lifecycleMap has no lifecycles")
+	}
+
+	lifecyclesWritten := map[string]bool{}
+	for origin, lifecycle := range c.OriginMap {
+		switch {
+		case origin == "":
+			problems = append(problems, fmt.Sprintf("originMap has an empty origin system for lifecycle %s", lifecycle))
+		case lifecycle == "":
+			problems = append(problems, fmt.Sprintf("origin system %s maps to an empty lifecycle", origin))
+		default:
+			if _, found := c.LifecycleMap[lifecycle]; !found {
+				problems = append(problems, fmt.Sprintf("origin system %s maps to lifecycle %s, which is not in lifecycleMap", origin, lifecycle))
+			}
+		}
+		lifecyclesWritten[lifecycle] = true
+	}
+
+	for lifecycle, platformVersion := range c.LifecycleMap {
+		switch {
+		case lifecycle == "":
+			problems = append(problems, fmt.Sprintf("lifecycleMap has an empty lifecycle for platform version %s", platformVersion))
+		case platformVersion == "":
+			problems = append(problems, fmt.Sprintf("lifecycle %s has an empty platform version", lifecycle))
+		}
+		if !lifecyclesWritten[lifecycle] && lifecycle != "" {
+			problems = append(problems, fmt.Sprintf("lifecycle %s has no origin system in originMap", lifecycle))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return ConfigValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// diff describes the changes from c to next, one line per changed entry
+func (c lifecycleConfig) diff(next lifecycleConfig) []string {
+	changes := diffMaps("originMap", c.OriginMap, next.OriginMap)
+	changes = append(changes, diffMaps("lifecycleMap", c.LifecycleMap, next.LifecycleMap)...)
+	if c.MessageType != next.MessageType {
+		changes = append(changes, fmt.Sprintf("messageType: changed from %s to %s", c.MessageType, next.MessageType))
+	}
+	return changes
+}
+
+func diffMaps(name string, old map[string]string, next map[string]string) []string {
+	var changes []string
+	for k, v := range next {
+		previous, found := old[k]
+		switch {
+		case !found:
+			changes = append(changes, fmt.Sprintf("%s: added %s = %s", name, k, v))
+		case previous != v:
+			changes = append(changes, fmt.Sprintf("%s: changed %s from %s to %s", name, k, previous, v))
+		}
+	}
+	for k, v := range old {
+		if _, found := next[k]; !found {
+			changes = append(changes, fmt.Sprintf("%s: removed %s = %s", name, k, v))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// liveConfig holds the lifecycle configuration in use, shared by the HTTP and queue handlers.
+// A new configuration is swapped in atomically, so each request or message sees either the old or the new one.
+type liveConfig struct {
+	v atomic.Value
+}
+
+func newLiveConfig(c lifecycleConfig) *liveConfig {
+	l := &liveConfig{}
+	l.v.Store(c)
+	return l
+}
+
+func (l *liveConfig) get() lifecycleConfig {
+	return l.v.Load().(lifecycleConfig)
+}
+
+func (l *liveConfig) set(c lifecycleConfig) {
+	l.v.Store(c)
+}
+
+// configReloader reloads the lifecycle configuration file into a liveConfig. An invalid configuration is rejected and
+// the one in use is kept.
+type configReloader struct {
+	path   string
+	config *liveConfig
+	log    *logger.UPPLogger
+
+	mu     sync.Mutex
+	digest [sha256.Size]byte
+}
+
+func newConfigReloader(path string, config *liveConfig, log *logger.UPPLogger) *configReloader {
+	r := &configReloader{path: path, config: config, log: log}
+	if file, err := ioutil.ReadFile(path); err == nil {
+		r.digest = sha256.Sum256(file)
+	}
+	return r
+}
+
+// Reload reads the configuration file and swaps it in, returning the changes made.
+// The trigger says what asked for the reload, for the logs.
+func (r *configReloader) Reload(trigger string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	log := r.log.WithField("trigger", trigger).WithField("path", r.path)
+	file, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		log.WithError(err).Error("Lifecycle configuration reload failed, keeping the current configuration")
+		return nil, fmt.Errorf("error reading configuration file: %w", err)
+	}
+	r.digest = sha256.Sum256(file)
+
+	var next lifecycleConfig
+	if err = json.Unmarshal(file, &next); err != nil {
+		err = fmt.Errorf("error marshalling config file: %w", err)
+	} else if err = next.validate(); err == nil && next.MessageType != r.config.get().MessageType {
+		err = errors.New("messageType cannot be changed without a restart")
+	}
+	if err != nil {
+		log.WithError(err).Error("Lifecycle configuration reload failed, keeping the current configuration")
+		return nil, err
+	}
+
+	changes := r.config.get().diff(next)
+	r.config.set(next)
+	if len(changes) == 0 {
+		log.Info("Lifecycle configuration reloaded, no changes")
+		return changes, nil
+	}
+	for _, change := range changes {
+		log.WithField("change", change).Info("Lifecycle configuration changed")
+	}
+	log.Infof("Lifecycle configuration reloaded with %d changes", len(changes))
+	return changes, nil
+}
+
+// changed tells whether the configuration file differs from the one last read
+func (r *configReloader) changed() bool {
+	file, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return sha256.Sum256(file) != r.digest
+}
+
+// Watch reloads the configuration whenever the file changes, checking every interval, and on SIGHUP.
+// The file is polled rather than watched for events, as Kubernetes updates mounted ConfigMaps by swapping symlinks.
+func (r *configReloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if r.changed() {
+				r.Reload("file change")
+			}
+		case <-hup:
+			r.Reload("SIGHUP")
+		case <-stop:
+			return
+		}
+	}
+}
+
+var helmLifecycleConfigPath = regexp.MustCompile(`^\s*LIFECYCLE_CONFIG_PATH:\s*["']?([^"'\s#]+)`)
+
+// lifecycleConfigPathFromHelmValues returns the lifecycle configuration file set by the env.LIFECYCLE_CONFIG_PATH
+// entry of a Helm values file, relative to the root of the repository, which is the working directory of the image
+func lifecycleConfigPathFromHelmValues(path string) (string, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading Helm values file: %w", err)
+	}
+	for _, line := range strings.Split(string(file), "\n") {
+		if m := helmLifecycleConfigPath.FindStringSubmatch(line); m != nil {
+			return m[1], nil
+		}
+	}
+	return "", errors.New("Helm values file does not set LIFECYCLE_CONFIG_PATH")
+}
+
+// validateConfigFiles validates lifecycle configuration files, or the ones named by Helm values files, and reports
+// the problems found in each to w. It returns whether they are all valid.
+func validateConfigFiles(w io.Writer, paths []string) bool {
+	valid := true
+	for _, path := range paths {
+		configPath := path
+		if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+			var err error
+			if configPath, err = lifecycleConfigPathFromHelmValues(path); err != nil {
+				fmt.Fprintf(w, "%s: %v\n", path, err)
+				valid = false
+				continue
+			}
+			fmt.Fprintf(w, "%s: uses %s\n", path, configPath)
+		}
+
+		_, err := readLifecycleConfig(configPath)
+		if validationErr, ok := err.(ConfigValidationError); ok {
+			fmt.Fprintf(w, "%s: invalid\n", configPath)
+			for _, problem := range validationErr.Problems {
+				fmt.Fprintf(w, "  - %s\n", problem)
+			}
+			valid = false
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(w, "%s: %v\n", configPath, err)
+			valid = false
+			continue
+		}
+		fmt.Fprintf(w, "%s: valid\n", configPath)
+	}
+	return valid
+}
+
+// originsOf returns the origin systems writing the lifecycle, sorted
+func (c lifecycleConfig) originsOf(lifecycle string) []string {
+	var origins []string
+	for origin, l := range c.OriginMap {
+		if l == lifecycle {
+			origins = append(origins, origin)
+		}
+	}
+	sort.Strings(origins)
+	return origins
+}
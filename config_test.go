@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/auth"
+
+	logger "github.com/Financial-Times/go-logger/v2"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const reloadTestConfig = `{
+  "originMap": {"http://cmdb.ft.com/systems/methode-web-pub": "annotations-v1"},
+  "lifecycleMap": {"annotations-v1": "v1"},
+  "messageType": "Annotations"
+}`
+
+func writeTestConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "annotation-config.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func newTestReloader(t *testing.T) (*configReloader, string) {
+	path := writeTestConfig(t, reloadTestConfig)
+	c, err := readLifecycleConfig(path)
+	require.NoError(t, err)
+	return newConfigReloader(path, newLiveConfig(c), logger.NewUPPInfoLogger("annotations-rw")), path
+}
+
+func TestLifecycleConfigDiff(t *testing.T) {
+	old := lifecycleConfig{
+		OriginMap:    map[string]string{"methode": "annotations-v1", "pac": "annotations-pac"},
+		LifecycleMap: map[string]string{"annotations-v1": "v1", "annotations-pac": "pac"},
+		MessageType:  "Annotations",
+	}
+	next := lifecycleConfig{
+		OriginMap:    map[string]string{"methode": "annotations-v1", "pac": "annotations-pac-v2", "video": "annotations-next-video"},
+		LifecycleMap: map[string]string{"annotations-v1": "v1", "annotations-pac-v2": "pac", "annotations-next-video": "next-video"},
+		MessageType:  "Annotations",
+	}
+
+	assert.Empty(t, next.diff(next), "A config should not differ from itself")
+	assert.Equal(t, []string{
+		"originMap: added video = annotations-next-video",
+		"originMap: changed pac from annotations-pac to annotations-pac-v2",
+		"lifecycleMap: added annotations-next-video = next-video",
+		"lifecycleMap: added annotations-pac-v2 = pac",
+		"lifecycleMap: removed annotations-pac = pac",
+	}, old.diff(next))
+}
+
+func TestConfigReloaderSwapsValidConfig(t *testing.T) {
+	reloader, path := newTestReloader(t)
+	assert.False(t, reloader.changed())
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{
+  "originMap": {"http://cmdb.ft.com/systems/methode-web-pub": "annotations-v1", "http://cmdb.ft.com/systems/pac": "annotations-pac"},
+  "lifecycleMap": {"annotations-v1": "v1", "annotations-pac": "pac"},
+  "messageType": "Annotations"
+}`), 0600))
+	assert.True(t, reloader.changed())
+
+	changes, err := reloader.Reload("test")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"originMap: added http://cmdb.ft.com/systems/pac = annotations-pac", "lifecycleMap: added annotations-pac = pac"}, changes)
+	assert.Equal(t, "pac", reloader.config.get().LifecycleMap["annotations-pac"])
+	assert.False(t, reloader.changed())
+}
+
+func TestConfigReloaderKeepsConfigWhenInvalid(t *testing.T) {
+	tests := map[string]string{
+		"not json":             `{"originMap": `,
+		"no message type":      `{"originMap": {}, "lifecycleMap": {}}`,
+		"message type changed": `{"originMap": {}, "lifecycleMap": {}, "messageType": "Suggestions"}`,
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			reloader, path := newTestReloader(t)
+			before := reloader.config.get()
+
+			require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+			_, err := reloader.Reload("test")
+			assert.Error(t, err)
+			assert.Equal(t, before, reloader.config.get())
+			assert.False(t, reloader.changed(), "A rejected file should not be retried until it changes again")
+		})
+	}
+}
+
+func TestReloadConfigEndpoint(t *testing.T) {
+	reloader, path := newTestReloader(t)
+	hh := &httpHandler{config: reloader.config, configReloader: reloader, log: reloader.log}
+	handler := router(hh, &healthCheckHandler{}, reloader.log)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/__reload-config", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"changes": []}`, rec.Body.String())
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"messageType": ""}`), 0600))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/__reload-config", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), "the current one is kept")
+
+	rec = httptest.NewRecorder()
+	router(&httpHandler{}, &healthCheckHandler{}, reloader.log).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/__reload-config", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestReloadConfigEndpointNeedsTheAdminGrant(t *testing.T) {
+	reloader, _ := newTestReloader(t)
+	guard, err := newTestGuard()
+	require.NoError(t, err)
+	hh := &httpHandler{config: reloader.config, configReloader: reloader, log: reloader.log, guard: guard}
+	handler := router(hh, &healthCheckHandler{}, reloader.log)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/__reload-config", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "Reloads should need credentials")
+
+	request := httptest.NewRequest(http.MethodPost, "/__reload-config", nil)
+	request.Header.Set(auth.APIKeyHeader, "methode-key")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, request)
+	assert.Equal(t, http.StatusForbidden, rec.Code, "Reloads should need the admin grant")
+
+	request = httptest.NewRequest(http.MethodPost, "/__reload-config", nil)
+	request.Header.Set(auth.APIKeyHeader, "ops-key")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, request)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"changes": []}`, rec.Body.String())
+}
+
+func TestLifecycleConfigValidate(t *testing.T) {
+	valid := func() lifecycleConfig {
+		return lifecycleConfig{
+			OriginMap:    map[string]string{"methode": "annotations-v1", "pac": "annotations-pac"},
+			LifecycleMap: map[string]string{"annotations-v1": "v1", "annotations-pac": "pac"},
+			MessageType:  "Annotations",
+		}
+	}
+	assert.NoError(t, valid().validate())
+
+	shared := valid()
+	shared.OriginMap["video"] = "annotations-v1"
+	assert.NoError(t, shared.validate(), "Several origin systems may write the same lifecycle")
+	assert.Equal(t, []string{"methode", "video"}, shared.originsOf("annotations-v1"))
+
+	tests := []struct {
+		name     string
+		change   func(c *lifecycleConfig)
+		problems []string
+	}{
+		{"no message type", func(c *lifecycleConfig) { c.MessageType = "" }, []string{"message type is not configured"}},
+		{"empty maps", func(c *lifecycleConfig) { c.OriginMap = nil; c.LifecycleMap = nil }, []string{"lifecycleMap has no lifecycles", "originMap has no origin systems"}},
+		{"unknown lifecycle", func(c *lifecycleConfig) { c.OriginMap["pac"] = "annotations-pac-v2" }, []string{
+			"lifecycle annotations-pac has no origin system in originMap",
+			"origin system pac maps to lifecycle annotations-pac-v2, which is not in lifecycleMap",
+		}},
+		{"empty values", func(c *lifecycleConfig) { c.OriginMap["video"] = ""; c.LifecycleMap["annotations-pac"] = "" }, []string{
+			"lifecycle annotations-pac has an empty platform version",
+			"origin system video maps to an empty lifecycle",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := valid()
+			test.change(&c)
+			assert.Equal(t, ConfigValidationError{Problems: test.problems}, c.validate())
+		})
+	}
+}
+
+func TestShippedConfigsAreValid(t *testing.T) {
+	for _, path := range []string{"annotation-config.json", "suggestion-config.json"} {
+		_, err := readLifecycleConfig(path)
+		assert.NoError(t, err, path)
+	}
+}
+
+func TestValidateConfigFiles(t *testing.T) {
+	invalid := writeTestConfig(t, `{"originMap": {"methode": "annotations-v1"}, "lifecycleMap": {}, "messageType": "Annotations"}`)
+	helmValues := filepath.Join(filepath.Dir(invalid), "values.yaml")
+	require.NoError(t, ioutil.WriteFile(helmValues, []byte("env:\n  SHOULD_FORWARD_MESSAGES: true\n  LIFECYCLE_CONFIG_PATH: \"suggestion-config.json\"\n"), 0600))
+	noPath := filepath.Join(filepath.Dir(invalid), "no-path.yaml")
+	require.NoError(t, ioutil.WriteFile(noPath, []byte("env:\n  SHOULD_FORWARD_MESSAGES: true\n"), 0600))
+
+	var out bytes.Buffer
+	assert.True(t, validateConfigFiles(&out, []string{"annotation-config.json", helmValues}))
+	assert.Equal(t, "annotation-config.json: valid\n"+helmValues+": uses suggestion-config.json\nsuggestion-config.json: valid\n", out.String())
+
+	out.Reset()
+	assert.False(t, validateConfigFiles(&out, []string{invalid, noPath}))
+	assert.Equal(t, invalid+": invalid\n"+
+		"  - lifecycleMap has no lifecycles\n"+
+		"  - origin system methode maps to lifecycle annotations-v1, which is not in lifecycleMap\n"+
+		noPath+": Helm values file does not set LIFECYCLE_CONFIG_PATH\n", out.String())
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// negotiateMediaType returns the media type from offered that the Accept header value prefers, honouring q-values and
+// wildcards. The first offered type is the default, used when the header is empty; an empty string is returned
+// when none of the offered types is acceptable.
+func negotiateMediaType(accept string, offered ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offered[0]
+	}
+
+	best := ""
+	bestQ := 0.0
+	bestSpecificity := -1
+	for _, offer := range offered {
+		q, specificity := acceptQuality(accept, offer)
+		if q > bestQ || (q == bestQ && q > 0 && specificity > bestSpecificity) {
+			best, bestQ, bestSpecificity = offer, q, specificity
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q-value given to offer by the most specific matching range of the Accept header,
+// and how specific that range is (0 for */*, 1 for type/*, 2 for type/subtype)
+func acceptQuality(accept string, offer string) (float64, int) {
+	q := 0.0
+	specificity := -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		s := -1
+		switch {
+		case mediaRange == offer:
+			s = 2
+		case mediaRange == "*/*":
+			s = 0
+		case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mediaRange, "*")):
+			s = 1
+		}
+		if s <= specificity {
+			continue
+		}
+
+		rangeQ := 1.0
+		if value, found := params["q"]; found {
+			if rangeQ, err = strconv.ParseFloat(value, 64); err != nil {
+				rangeQ = 0
+			}
+		}
+		q, specificity = rangeQ, s
+	}
+	return q, specificity
+}
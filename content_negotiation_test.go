@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateMediaType(t *testing.T) {
+	var tests = []struct {
+		accept   string
+		expected string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"application/json", "application/json"},
+		{"application/ld+json", "application/ld+json"},
+		{"application/ld+json;profile=\"http://www.w3.org/ns/json-ld#expanded\"", "application/ld+json"},
+		{"application/json;q=0.5, application/ld+json", "application/ld+json"},
+		{"application/ld+json;q=0.5, application/json", "application/json"},
+		{"application/ld+json, */*;q=0.1", "application/ld+json"},
+		{"application/*", "application/json"},
+		{"text/html", ""},
+		{"application/ld+json;q=0, */*", "application/json"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, negotiateMediaType(test.accept, "application/json", "application/ld+json"), test.accept)
+	}
+}
@@ -1,14 +1,17 @@
 package forwarder
 
 import (
+	"context"
 	"encoding/json"
 	"strings"
 	"time"
 
 	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/tracing"
 	"github.com/Financial-Times/kafka-client-go/kafka"
 
 	uuid "github.com/satori/go.uuid"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // The outputMessage represents the structure of the JSON object that is written in the body of the message
@@ -29,15 +32,40 @@ type QueueForwarder interface {
 	SendMessage(transactionID string, originSystem string, platformVersion string, uuid string, annotations annotations.Annotations) error
 }
 
+// WithContext returns the forwarder sending its messages as part of ctx. Forwarders that cannot are returned as they are.
+func WithContext(ctx context.Context, f QueueForwarder) QueueForwarder {
+	if c, ok := f.(interface {
+		WithContext(ctx context.Context) QueueForwarder
+	}); ok {
+		return c.WithContext(ctx)
+	}
+	return f
+}
+
 // A Forwarder facilitates sending a message to Kafka via kafka.Producer.
 type Forwarder struct {
 	Producer    kafka.Producer
 	MessageType string
+	ctx         context.Context
+}
+
+// WithContext returns the forwarder sending its messages as part of ctx, with its trace context in their headers
+func (f Forwarder) WithContext(ctx context.Context) QueueForwarder {
+	f.ctx = ctx
+	return f
 }
 
 // SendMessage marshals an annotations payload using the outputMessage format and sends it to a Kafka.
-func (f Forwarder) SendMessage(transactionID string, originSystem string, platformVersion string, uuid string, annotations annotations.Annotations) error {
+func (f Forwarder) SendMessage(transactionID string, originSystem string, platformVersion string, uuid string, annotations annotations.Annotations) (err error) {
+	ctx := f.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := tracing.Start(ctx, "Forwarder.SendMessage", trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(tracing.TransactionID(transactionID)))
+	defer func() { tracing.End(span, err) }()
+
 	headers := CreateHeaders(transactionID, originSystem)
+	tracing.Inject(ctx, headers)
 	body, err := f.prepareBody(platformVersion, uuid, annotations, headers["Message-Timestamp"])
 	if err != nil {
 		return err
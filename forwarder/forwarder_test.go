@@ -1,6 +1,7 @@
 package forwarder_test
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -12,6 +13,12 @@ import (
 	"github.com/Financial-Times/annotations-rw-neo4j/v4/forwarder"
 
 	"github.com/Financial-Times/kafka-client-go/kafka"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 type InputMessage struct {
@@ -107,6 +114,34 @@ func TestCreateHeaders(t *testing.T) {
 	}
 }
 
+func TestSendMessageWithContext(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	p := new(mockProducer)
+	f := forwarder.WithContext(ctx, forwarder.Forwarder{Producer: p, MessageType: "Annotations"})
+	if err := f.SendMessage(transactionID, originSystem, "pac", "3a636e78-5a47-11e7-9bc8-8055f264aa8b", nil); err != nil {
+		t.Fatal("Error sending message")
+	}
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 || spans[0].Name() != "Forwarder.SendMessage" {
+		t.Fatalf("Unexpected spans, expected the span of the message and its parent but recorded %d", len(spans))
+	}
+	sent := spans[0].SpanContext()
+	if spans[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("Unexpected parent span, expected `%s` but recorded `%s`", parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	}
+	expected := fmt.Sprintf("00-%s-%s-01", sent.TraceID(), sent.SpanID())
+	if traceparent := p.getLastMessage().Headers["traceparent"]; traceparent != expected {
+		t.Errorf("Unexpected Kafka traceparent, expected `%s` but recevied `%s`", expected, traceparent)
+	}
+}
+
 type mockProducer struct {
 	message kafka.FTMessage
 }
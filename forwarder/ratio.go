@@ -0,0 +1,80 @@
+package forwarder
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+)
+
+// SuccessRatio keeps the outcome of the last messages forwarded, for the health of the forwarding to be checked
+type SuccessRatio struct {
+	sync.Mutex
+	outcomes []outcome
+	next     int
+	maxAge   time.Duration
+}
+
+type outcome struct {
+	at   time.Time
+	sent bool
+}
+
+// NewSuccessRatio returns the success ratio over the last window messages forwarded in the last maxAge, so that
+// failures are forgotten once no message is forwarded
+func NewSuccessRatio(window int, maxAge time.Duration) *SuccessRatio {
+	return &SuccessRatio{outcomes: make([]outcome, window), maxAge: maxAge}
+}
+
+// Forwarder wraps a forwarder to record the outcome of each message it sends
+func (r *SuccessRatio) Forwarder(f QueueForwarder) QueueForwarder {
+	if r == nil || len(r.outcomes) == 0 {
+		return f
+	}
+	return recordedForwarder{QueueForwarder: f, ratio: r}
+}
+
+// Ratio returns the share of the last messages forwarded that were sent, along with the number of messages it is
+// computed on. The ratio is 1 when no message has been forwarded recently.
+func (r *SuccessRatio) Ratio() (float64, int) {
+	r.Lock()
+	defer r.Unlock()
+	since := time.Now().Add(-r.maxAge)
+	count, sent := 0, 0
+	for _, o := range r.outcomes {
+		if o.at.IsZero() || o.at.Before(since) {
+			continue
+		}
+		count++
+		if o.sent {
+			sent++
+		}
+	}
+	if count == 0 {
+		return 1, 0
+	}
+	return float64(sent) / float64(count), count
+}
+
+func (r *SuccessRatio) record(sent bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.outcomes[r.next] = outcome{at: time.Now(), sent: sent}
+	r.next = (r.next + 1) % len(r.outcomes)
+}
+
+type recordedForwarder struct {
+	QueueForwarder
+	ratio *SuccessRatio
+}
+
+func (f recordedForwarder) WithContext(ctx context.Context) QueueForwarder {
+	return recordedForwarder{QueueForwarder: WithContext(ctx, f.QueueForwarder), ratio: f.ratio}
+}
+
+func (f recordedForwarder) SendMessage(transactionID string, originSystem string, platformVersion string, uuid string, anns annotations.Annotations) error {
+	err := f.QueueForwarder.SendMessage(transactionID, originSystem, platformVersion, uuid, anns)
+	f.ratio.record(err == nil)
+	return err
+}
@@ -0,0 +1,64 @@
+package forwarder_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/forwarder"
+)
+
+type failingForwarder struct {
+	err error
+}
+
+func (f *failingForwarder) SendMessage(transactionID string, originSystem string, platformVersion string, uuid string, anns annotations.Annotations) error {
+	return f.err
+}
+
+func TestSuccessRatio(t *testing.T) {
+	ratio := forwarder.NewSuccessRatio(4, time.Hour)
+	if r, count := ratio.Ratio(); r != 1 || count != 0 {
+		t.Errorf("Unexpected ratio before any message, expected 1 over 0 messages but got %v over %d", r, count)
+	}
+
+	inner := &failingForwarder{}
+	f := ratio.Forwarder(inner)
+	send := func(err error) {
+		inner.err = err
+		if sendErr := f.SendMessage(transactionID, originSystem, "pac", "1234", nil); sendErr != err {
+			t.Errorf("Unexpected error, expected `%v` but got `%v`", err, sendErr)
+		}
+	}
+
+	send(nil)
+	send(errors.New("unavailable"))
+	if r, count := ratio.Ratio(); r != 0.5 || count != 2 {
+		t.Errorf("Unexpected ratio, expected 0.5 over 2 messages but got %v over %d", r, count)
+	}
+
+	for i := 0; i < 4; i++ {
+		send(nil)
+	}
+	if r, count := ratio.Ratio(); r != 1 || count != 4 {
+		t.Errorf("Unexpected ratio, expected failures to leave the window but got %v over %d", r, count)
+	}
+}
+
+func TestSuccessRatioMaxAge(t *testing.T) {
+	ratio := forwarder.NewSuccessRatio(4, time.Nanosecond)
+	ratio.Forwarder(&failingForwarder{err: errors.New("unavailable")}).SendMessage(transactionID, originSystem, "pac", "1234", nil)
+	time.Sleep(time.Millisecond)
+	if r, count := ratio.Ratio(); r != 1 || count != 0 {
+		t.Errorf("Unexpected ratio, expected old failures to be forgotten but got %v over %d", r, count)
+	}
+}
+
+func TestSuccessRatioDisabled(t *testing.T) {
+	var ratio *forwarder.SuccessRatio
+	inner := &failingForwarder{}
+	if f := ratio.Forwarder(inner); f != inner {
+		t.Error("Unexpected forwarder, expected the forwarder to be returned as it is")
+	}
+}
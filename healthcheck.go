@@ -1,10 +1,16 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/breaker"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/canary"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/forwarder"
 
 	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
 	"github.com/Financial-Times/kafka-client-go/kafka"
@@ -14,6 +20,15 @@ import (
 type healthCheckHandler struct {
 	annotationsService annotations.Service
 	consumer           kafka.Consumer
+	producer           kafka.Producer
+	forwarded          *forwarder.SuccessRatio
+	minForwardedRatio  float64
+	forwardingInGTG    bool
+	canary             *canary.Canary
+	canaryInterval     time.Duration
+	breaker            *breaker.Breaker
+	maintenance        *maintenanceMode
+	shutdown           *shutdownState
 }
 
 func (h healthCheckHandler) Health() func(w http.ResponseWriter, r *http.Request) {
@@ -21,6 +36,21 @@ func (h healthCheckHandler) Health() func(w http.ResponseWriter, r *http.Request
 	if h.consumer != nil {
 		checks = append(checks, h.readQueueCheck())
 	}
+	if h.producer != nil {
+		checks = append(checks, h.writeQueueCheck())
+	}
+	if h.forwarded != nil {
+		checks = append(checks, h.forwardingCheck())
+	}
+	if h.canary != nil {
+		checks = append(checks, h.canaryCheck())
+	}
+	if h.breaker != nil {
+		checks = append(checks, h.breakerCheck())
+	}
+	if h.maintenance != nil {
+		checks = append(checks, h.maintenanceCheck())
+	}
 	hc := fthealth.TimedHealthCheck{
 		HealthCheck: fthealth.HealthCheck{
 			SystemCode:  "annotation-rw",
@@ -34,22 +64,37 @@ func (h healthCheckHandler) Health() func(w http.ResponseWriter, r *http.Request
 }
 
 func (h healthCheckHandler) GTG() gtg.Status {
+	if h.shutdown.inProgress() {
+		return gtg.Status{GoodToGo: false, Message: "Service is shutting down"}
+	}
+
 	writerCheck := func() gtg.Status {
 		return gtgCheck(h.Checker)
 	}
 
-	if h.consumer == nil {
-		return writerCheck()
+	var checks []gtg.StatusChecker
+	if h.consumer != nil {
+		checks = append(checks, func() gtg.Status {
+			return gtgCheck(h.checkKafkaConnectivity)
+		})
+	}
+	checks = append(checks, writerCheck)
+	if h.forwardingInGTG && h.producer != nil {
+		checks = append(checks, func() gtg.Status {
+			return gtgCheck(h.checkProducerConnectivity)
+		})
+	}
+	if h.forwardingInGTG && h.forwarded != nil {
+		checks = append(checks, func() gtg.Status {
+			return gtgCheck(h.checkForwardedRatio)
+		})
 	}
 
-	consumerCheck := func() gtg.Status {
-		return gtgCheck(h.checkKafkaConnectivity)
+	if len(checks) == 1 {
+		return writerCheck()
 	}
 
-	return gtg.FailFastParallelCheck([]gtg.StatusChecker{
-		consumerCheck,
-		writerCheck,
-	})()
+	return gtg.FailFastParallelCheck(checks)()
 }
 
 func (h healthCheckHandler) readQueueCheck() fthealth.Check {
@@ -64,6 +109,66 @@ func (h healthCheckHandler) readQueueCheck() fthealth.Check {
 	}
 }
 
+func (h healthCheckHandler) writeQueueCheck() fthealth.Check {
+	return fthealth.Check{
+		ID:               "write-message-queue-reachable",
+		Name:             "Write Message Queue Reachable",
+		Severity:         1,
+		BusinessImpact:   "Annotation writes fail after being saved, and are not published downstream. This will negatively impact metadata/annotations availability.",
+		TechnicalSummary: "Write message queue is not reachable/healthy, PUT requests return 500 after writing to Neo4j",
+		PanicGuide:       "https://runbooks.in.ft.com/annotations-rw-neo4j",
+		Checker:          h.checkProducerConnectivity,
+	}
+}
+
+func (h healthCheckHandler) forwardingCheck() fthealth.Check {
+	return fthealth.Check{
+		ID:               "forwarded-messages-success-ratio",
+		Name:             "Forwarded Messages Success Ratio",
+		Severity:         2,
+		BusinessImpact:   "Some annotation changes are not published downstream. This will negatively impact metadata/annotations availability.",
+		TechnicalSummary: "Too many of the last messages forwarded to the write message queue failed to be sent",
+		PanicGuide:       "https://runbooks.in.ft.com/annotations-rw-neo4j",
+		Checker:          h.checkForwardedRatio,
+	}
+}
+
+func (h healthCheckHandler) canaryCheck() fthealth.Check {
+	return fthealth.Check{
+		ID:               "annotations-round-trip",
+		Name:             "Annotations Round Trip",
+		Severity:         2,
+		BusinessImpact:   "Annotations may fail to be written, read or deleted even though Neo4j is reachable",
+		TechnicalSummary: "Writing, reading back and deleting a canary annotation failed, or has not run recently. Check the failing step for constraint, permission or query errors",
+		PanicGuide:       "https://runbooks.in.ft.com/annotations-rw-neo4j",
+		Checker:          h.checkCanary,
+	}
+}
+
+func (h healthCheckHandler) breakerCheck() fthealth.Check {
+	return fthealth.Check{
+		ID:               "neo4j-circuit-breaker-closed",
+		Name:             "Neo4j Circuit Breaker Closed",
+		Severity:         1,
+		BusinessImpact:   "Annotation API requests fail fast with a 503 and annotation messages are not consumed until Neo4j recovers",
+		TechnicalSummary: "Too many consecutive calls to Neo4j failed, so the circuit breaker stopped them. It probes Neo4j once its cool-down has passed",
+		PanicGuide:       "https://runbooks.in.ft.com/annotations-rw-neo4j",
+		Checker:          h.checkBreaker,
+	}
+}
+
+func (h healthCheckHandler) maintenanceCheck() fthealth.Check {
+	return fthealth.Check{
+		ID:               "maintenance-mode-disabled",
+		Name:             "Maintenance Mode Disabled",
+		Severity:         2,
+		BusinessImpact:   "Annotations can be read but not written or deleted, and annotation messages are not consumed until maintenance mode is disabled",
+		TechnicalSummary: "The service was put in maintenance mode, by the --maintenance flag or PUT /__maintenance, usually while Neo4j is maintained or migrated. Disable it with PUT /__maintenance once the maintenance is over",
+		PanicGuide:       "https://runbooks.in.ft.com/annotations-rw-neo4j",
+		Checker:          h.checkMaintenance,
+	}
+}
+
 func (h healthCheckHandler) writerCheck() fthealth.Check {
 	return fthealth.Check{
 		ID:               "write-message-datastore-reachable",
@@ -83,9 +188,76 @@ func (h healthCheckHandler) checkKafkaConnectivity() (string, error) {
 	return "Successfully connected to Kafka", nil
 }
 
+func (h healthCheckHandler) checkProducerConnectivity() (string, error) {
+	if err := h.producer.ConnectivityCheck(); err != nil {
+		return "Error connecting the producer with Kafka", err
+	}
+	return "Successfully connected the producer to Kafka", nil
+}
+
+func (h healthCheckHandler) checkForwardedRatio() (string, error) {
+	ratio, count := h.forwarded.Ratio()
+	msg := fmt.Sprintf("%.0f%% of the last %d messages were forwarded", ratio*100, count)
+	if ratio < h.minForwardedRatio {
+		return msg, fmt.Errorf("only %s, below %.0f%%", msg, h.minForwardedRatio*100)
+	}
+	return msg, nil
+}
+
+// checkCanary reports the last run of the canary rather than running it, for __health to stay cheap
+func (h healthCheckHandler) checkCanary() (string, error) {
+	if h.maintenance.enabled() {
+		return "The canary is paused while the service is in maintenance mode", nil
+	}
+	result, ran := h.canary.Last()
+	if !ran {
+		return "The canary has not run yet", nil
+	}
+	var steps []string
+	for _, step := range result.Steps {
+		steps = append(steps, fmt.Sprintf("%s %dms", step.Name, step.DurationMs))
+	}
+	msg := fmt.Sprintf("Canary run at %s: %s", result.Time.Format(time.RFC3339), strings.Join(steps, ", "))
+	if result.Err != nil {
+		return msg, result.Err
+	}
+	if time.Since(result.Time) > 3*h.canaryInterval {
+		return msg, fmt.Errorf("the canary has not run since %s", result.Time.Format(time.RFC3339))
+	}
+	return msg, nil
+}
+
+func (h healthCheckHandler) checkBreaker() (string, error) {
+	state, openedAt := h.breaker.State()
+	if state == breaker.Closed {
+		return "Neo4j circuit breaker is closed", nil
+	}
+	msg := fmt.Sprintf("Neo4j circuit breaker is %s, it opened at %s", state, openedAt.Format(time.RFC3339))
+	return msg, errors.New(msg)
+}
+
+func (h healthCheckHandler) checkMaintenance() (string, error) {
+	status := h.maintenance.get()
+	if !status.Enabled {
+		return "Maintenance mode is disabled", nil
+	}
+	msg := fmt.Sprintf("Maintenance mode is enabled since %s", status.Since.Format(time.RFC3339))
+	if status.Reason != "" {
+		msg = fmt.Sprintf("%s: %s", msg, status.Reason)
+	}
+	return msg, errors.New(msg)
+}
+
 // Checker does more stuff
 //TODO use the shared utility check
 func (hc healthCheckHandler) Checker() (string, error) {
+	if hc.maintenance.enabled() {
+		// Neo4j is usually read-only while it is maintained, and nothing is written to it in maintenance mode anyway
+		if err := hc.annotationsService.CheckReadable(); err != nil {
+			return "Error connecting to neo4j", err
+		}
+		return "Connectivity to neo4j is ok, it is not checked for writes in maintenance mode", nil
+	}
 	if err := hc.annotationsService.Check(); err != nil {
 		return "Error connecting to neo4j", err
 	}
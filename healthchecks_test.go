@@ -1,14 +1,23 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/breaker"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/canary"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/forwarder"
 
 	logger "github.com/Financial-Times/go-logger/v2"
+	"github.com/jmcvetta/neoism"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -70,6 +79,19 @@ func (suite *HealthCheckHandlerTestSuite) TestHealthCheckHandler_GTG_Success() {
 	assert.True(suite.T(), http.StatusOK == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusOK))
 }
 
+func (suite *HealthCheckHandlerTestSuite) TestHealthCheckHandler_GTG_ShuttingDown() {
+	suite.annotationsService.On("Check").Return(nil)
+	req, err := http.NewRequest(http.MethodGet, "/__gtg", nil)
+	assert.NoError(suite.T(), err, "Unexpected error")
+	state := &shutdownState{}
+	state.begin()
+	healthCheckHandler := healthCheckHandler{annotationsService: suite.annotationsService, consumer: mockConsumer{}, shutdown: state}
+	rec := httptest.NewRecorder()
+	router(&suite.httpHandler, &healthCheckHandler, suite.log).ServeHTTP(rec, req)
+	assert.True(suite.T(), http.StatusServiceUnavailable == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusServiceUnavailable))
+	assert.Equal(suite.T(), "Service is shutting down", rec.Body.String())
+}
+
 func (suite *HealthCheckHandlerTestSuite) TestHealthCheckHandler_GTG_AnnotationsServiceNotHealthy() {
 	suite.annotationsService.On("Check").Return(errors.New("not healthy"))
 	req, err := http.NewRequest(http.MethodGet, "/__gtg", nil)
@@ -100,3 +122,199 @@ func (suite *HealthCheckHandlerTestSuite) TestHealthCheckHandler_GTG_NilConsumer
 	router(&suite.httpHandler, &healthCheckHandler, suite.log).ServeHTTP(rec, req)
 	assert.True(suite.T(), http.StatusOK == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusOK))
 }
+
+func (suite *HealthCheckHandlerTestSuite) TestHealthCheckHandler_Health_ProducerNotHealthy() {
+	suite.annotationsService.On("Check").Return(nil)
+	req, err := http.NewRequest(http.MethodGet, "/__health", nil)
+	assert.NoError(suite.T(), err, "Unexpected error")
+	healthCheckHandler := healthCheckHandler{annotationsService: suite.annotationsService, producer: mockProducer{err: errors.New("producer error")}}
+	rec := httptest.NewRecorder()
+	router(&suite.httpHandler, &healthCheckHandler, suite.log).ServeHTTP(rec, req)
+	assert.True(suite.T(), http.StatusOK == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusOK))
+	assert.Contains(suite.T(), rec.Body.String(), `"id":"write-message-queue-reachable"`)
+	assert.Contains(suite.T(), rec.Body.String(), `"ok":false`)
+}
+
+func (suite *HealthCheckHandlerTestSuite) TestHealthCheckHandler_Health_ForwardedRatioTooLow() {
+	suite.annotationsService.On("Check").Return(nil)
+	req, err := http.NewRequest(http.MethodGet, "/__health", nil)
+	assert.NoError(suite.T(), err, "Unexpected error")
+	healthCheckHandler := healthCheckHandler{annotationsService: suite.annotationsService, producer: mockProducer{}, forwarded: failedForwarding(), minForwardedRatio: 0.9}
+	rec := httptest.NewRecorder()
+	router(&suite.httpHandler, &healthCheckHandler, suite.log).ServeHTTP(rec, req)
+	assert.True(suite.T(), http.StatusOK == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusOK))
+	assert.Contains(suite.T(), rec.Body.String(), `"id":"forwarded-messages-success-ratio"`)
+	assert.Contains(suite.T(), rec.Body.String(), "only 50% of the last 2 messages were forwarded, below 90%")
+}
+
+func (suite *HealthCheckHandlerTestSuite) TestHealthCheckHandler_GTG_ForwardingNotHealthy() {
+	suite.annotationsService.On("Check").Return(nil)
+	handlers := map[string]healthCheckHandler{
+		"producer":        {annotationsService: suite.annotationsService, producer: mockProducer{err: errors.New("producer error")}, forwardingInGTG: true},
+		"forwarded ratio": {annotationsService: suite.annotationsService, producer: mockProducer{}, forwarded: failedForwarding(), minForwardedRatio: 0.9, forwardingInGTG: true},
+	}
+	for name, healthCheckHandler := range handlers {
+		req, err := http.NewRequest(http.MethodGet, "/__gtg", nil)
+		assert.NoError(suite.T(), err, "Unexpected error")
+		rec := httptest.NewRecorder()
+		router(&suite.httpHandler, &healthCheckHandler, suite.log).ServeHTTP(rec, req)
+		assert.True(suite.T(), http.StatusServiceUnavailable == rec.Code, fmt.Sprintf("Wrong response code for the %s, was %d, should be %d", name, rec.Code, http.StatusServiceUnavailable))
+	}
+}
+
+func (suite *HealthCheckHandlerTestSuite) TestHealthCheckHandler_GTG_ForwardingNotIncluded() {
+	suite.annotationsService.On("Check").Return(nil)
+	req, err := http.NewRequest(http.MethodGet, "/__gtg", nil)
+	assert.NoError(suite.T(), err, "Unexpected error")
+	healthCheckHandler := healthCheckHandler{annotationsService: suite.annotationsService, producer: mockProducer{err: errors.New("producer error")}, forwarded: failedForwarding(), minForwardedRatio: 0.9}
+	rec := httptest.NewRecorder()
+	router(&suite.httpHandler, &healthCheckHandler, suite.log).ServeHTTP(rec, req)
+	assert.True(suite.T(), http.StatusOK == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusOK))
+}
+
+// failedForwarding returns a success ratio of one message sent and one failed
+func failedForwarding() *forwarder.SuccessRatio {
+	ratio := forwarder.NewSuccessRatio(10, time.Hour)
+	sent := new(mockForwarder)
+	sent.On("SendMessage", "tid_test", "origin", "v1", "1234", annotations.Annotations(nil)).Return(nil)
+	ratio.Forwarder(sent).SendMessage("tid_test", "origin", "v1", "1234", nil)
+	failed := new(mockForwarder)
+	failed.On("SendMessage", "tid_test", "origin", "v1", "1234", annotations.Annotations(nil)).Return(errors.New("unavailable"))
+	ratio.Forwarder(failed).SendMessage("tid_test", "origin", "v1", "1234", nil)
+	return ratio
+}
+
+func (suite *HealthCheckHandlerTestSuite) TestHealthCheckHandler_Health_Canary() {
+	suite.annotationsService.On("Check").Return(nil)
+	suite.annotationsService.On("Write", canary.ContentUUID, canary.Lifecycle, canary.PlatformVersion, mock.Anything, mock.Anything).Return(nil)
+	read := annotations.Annotations{{Thing: annotations.Thing{ID: "http://api.ft.com/things/" + canary.ConceptUUID, Predicate: "MENTIONS"}}}
+	suite.annotationsService.On("Read", canary.ContentUUID, mock.Anything, canary.Lifecycle, annotations.ReadFilter{}).Return(read, true, nil)
+	suite.annotationsService.On("Delete", canary.ContentUUID, mock.Anything, canary.Lifecycle).Return(true, nil)
+
+	roundTrip := canary.New(suite.annotationsService)
+	healthCheckHandler := healthCheckHandler{annotationsService: suite.annotationsService, canary: roundTrip, canaryInterval: time.Minute}
+	health := func() string {
+		req, err := http.NewRequest(http.MethodGet, "/__health", nil)
+		assert.NoError(suite.T(), err, "Unexpected error")
+		rec := httptest.NewRecorder()
+		router(&suite.httpHandler, &healthCheckHandler, suite.log).ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	assert.Contains(suite.T(), health(), "The canary has not run yet")
+	suite.annotationsService.AssertNotCalled(suite.T(), "Write", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	roundTrip.Run()
+	body := health()
+	assert.Contains(suite.T(), body, `"id":"annotations-round-trip"`)
+	assert.NotContains(suite.T(), body, `"ok":false`)
+	assert.Regexp(suite.T(), `write \d+ms, read \d+ms, delete \d+ms`, body)
+}
+
+func (suite *HealthCheckHandlerTestSuite) TestHealthCheckHandler_Health_CanaryFailed() {
+	suite.annotationsService.On("Check").Return(nil)
+	suite.annotationsService.On("Write", canary.ContentUUID, canary.Lifecycle, canary.PlatformVersion, mock.Anything, mock.Anything).Return(errors.New("constraint violated"))
+
+	roundTrip := canary.New(suite.annotationsService)
+	roundTrip.Run()
+	req, err := http.NewRequest(http.MethodGet, "/__health", nil)
+	assert.NoError(suite.T(), err, "Unexpected error")
+	healthCheckHandler := healthCheckHandler{annotationsService: suite.annotationsService, canary: roundTrip, canaryInterval: time.Minute}
+	rec := httptest.NewRecorder()
+	router(&suite.httpHandler, &healthCheckHandler, suite.log).ServeHTTP(rec, req)
+	assert.Contains(suite.T(), rec.Body.String(), `"ok":false`)
+	assert.Contains(suite.T(), rec.Body.String(), "canary write failed: constraint violated")
+}
+
+func (suite *HealthCheckHandlerTestSuite) TestHealthCheckHandler_Health_CanaryStale() {
+	suite.annotationsService.On("Write", canary.ContentUUID, canary.Lifecycle, canary.PlatformVersion, mock.Anything, mock.Anything).Return(nil)
+	read := annotations.Annotations{{Thing: annotations.Thing{ID: "http://api.ft.com/things/" + canary.ConceptUUID, Predicate: "MENTIONS"}}}
+	suite.annotationsService.On("Read", canary.ContentUUID, mock.Anything, canary.Lifecycle, annotations.ReadFilter{}).Return(read, true, nil)
+	suite.annotationsService.On("Delete", canary.ContentUUID, mock.Anything, canary.Lifecycle).Return(true, nil)
+
+	roundTrip := canary.New(suite.annotationsService)
+	roundTrip.Run()
+	time.Sleep(time.Millisecond)
+	healthCheckHandler := healthCheckHandler{annotationsService: suite.annotationsService, canary: roundTrip, canaryInterval: time.Nanosecond}
+	_, err := healthCheckHandler.checkCanary()
+	assert.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "the canary has not run since")
+}
+
+func (suite *HealthCheckHandlerTestSuite) TestHealthCheckHandler_Health_BreakerOpen() {
+	suite.annotationsService.On("Check").Return(nil)
+	suite.annotationsService.On("Delete", knownUUID, mock.Anything, annotationLifecycle).Return(false, errNeo4jTimeout)
+	neoBreaker := breaker.New(1, time.Minute, nil)
+	neoBreaker.Service(suite.annotationsService).Delete(knownUUID, "tid_test", annotationLifecycle)
+
+	req, err := http.NewRequest(http.MethodGet, "/__health", nil)
+	assert.NoError(suite.T(), err, "Unexpected error")
+	healthCheckHandler := healthCheckHandler{annotationsService: suite.annotationsService, breaker: neoBreaker}
+	rec := httptest.NewRecorder()
+	router(&suite.httpHandler, &healthCheckHandler, suite.log).ServeHTTP(rec, req)
+	assert.Contains(suite.T(), rec.Body.String(), `"id":"neo4j-circuit-breaker-closed"`)
+	assert.Contains(suite.T(), rec.Body.String(), "Neo4j circuit breaker is open")
+	assert.Contains(suite.T(), rec.Body.String(), `"ok":false`)
+}
+
+func (suite *HealthCheckHandlerTestSuite) TestHealthCheckHandler_Health_Maintenance() {
+	suite.annotationsService.On("Check").Return(nil)
+	suite.annotationsService.On("CheckReadable").Return(nil)
+	maintenance := newMaintenanceMode()
+	healthCheckHandler := healthCheckHandler{annotationsService: suite.annotationsService, maintenance: maintenance}
+	_, err := healthCheckHandler.checkMaintenance()
+	assert.NoError(suite.T(), err)
+
+	maintenance.set(true, "Neo4j migration")
+	req, err := http.NewRequest(http.MethodGet, "/__health", nil)
+	assert.NoError(suite.T(), err, "Unexpected error")
+	rec := httptest.NewRecorder()
+	router(&suite.httpHandler, &healthCheckHandler, suite.log).ServeHTTP(rec, req)
+	assert.Contains(suite.T(), rec.Body.String(), `"id":"maintenance-mode-disabled"`)
+	assert.Contains(suite.T(), rec.Body.String(), "Neo4j migration")
+	assert.True(suite.T(), healthCheckHandler.GTG().GoodToGo, "Instances in maintenance mode should keep serving reads")
+
+	healthCheckHandler.canary = canary.New(suite.annotationsService)
+	_, err = healthCheckHandler.checkCanary()
+	assert.NoError(suite.T(), err, "The canary should not be reported as stale while it is paused")
+}
+
+// readOnlyNeo4j is a Neo4j instance that is not the leader of its cluster, so it can be read but not written to
+type readOnlyNeo4j struct{}
+
+func (readOnlyNeo4j) CypherBatch(queries []*neoism.CypherQuery) error {
+	for _, q := range queries {
+		result := `[]`
+		if q.Statement == `CALL dbms.cluster.role()` {
+			result = `[{"role": "FOLLOWER"}]`
+		}
+		if err := json.Unmarshal([]byte(result), q.Result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (readOnlyNeo4j) EnsureConstraints(indexes map[string]string) error {
+	return nil
+}
+
+func (readOnlyNeo4j) EnsureIndexes(indexes map[string]string) error {
+	return nil
+}
+
+func (suite *HealthCheckHandlerTestSuite) TestHealthCheckHandler_GTG_ReadOnlyNeo4jInMaintenance() {
+	maintenance := newMaintenanceMode()
+	healthCheckHandler := healthCheckHandler{annotationsService: annotations.NewCypherAnnotationsService(readOnlyNeo4j{}), maintenance: maintenance}
+	status := healthCheckHandler.GTG()
+	assert.False(suite.T(), status.GoodToGo, "A read-only Neo4j should fail the writer check")
+	assert.Equal(suite.T(), "role has to be LEADER for writing but it's FOLLOWER", status.Message)
+
+	maintenance.set(true, "Neo4j migration")
+	rec := httptest.NewRecorder()
+	router(&suite.httpHandler, &healthCheckHandler, suite.log).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/__gtg", nil))
+	assert.Equal(suite.T(), http.StatusOK, rec.Code, "A read-only Neo4j should not fail the writer check in maintenance mode")
+	msg, err := healthCheckHandler.Checker()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "Connectivity to neo4j is ok, it is not checked for writes in maintenance mode", msg)
+}
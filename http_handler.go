@@ -1,16 +1,33 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/audit"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/auth"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/breaker"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/changes"
 	"github.com/Financial-Times/annotations-rw-neo4j/v4/forwarder"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/jobs"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/limits"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/linkeddata"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/stats"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/tracing"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/webhooks"
 
 	logger "github.com/Financial-Times/go-logger/v2"
+	status "github.com/Financial-Times/service-status-go/httphandlers"
 	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
 
 	"github.com/gorilla/mux"
@@ -19,20 +36,39 @@ import (
 
 const (
 	lifecyclePropertyName = "annotationLifecycle"
+	originSystemHeader    = "X-Origin-System-Id"
+	changeStreamHeartbeat = 15 * time.Second
+	authChallenge         = `Bearer, ` + auth.HMACScheme + `, ApiKey header="` + auth.APIKeyHeader + `"`
 )
 
 //service def
 type httpHandler struct {
 	annotationsService annotations.Service
 	forwarder          forwarder.QueueForwarder
-	originMap          map[string]string
-	lifecycleMap       map[string]string
+	config             *liveConfig
+	configReloader     *configReloader
 	messageType        string
 	log                *logger.UPPLogger
+	apiSpec            *apiSpec
+	jsonLDContextURL   string
+	guard              *auth.Guard
+	limits             *limits.Limits
+	consuming          bool
+	jobs               *jobs.Pool
+	webhooks           *webhooks.Notifier
+	changes            *changes.Stream
+	audit              *audit.Log
+	metrics            *stats.Metrics
+	cacheMaxAge        time.Duration
+	maintenance        *maintenanceMode
 }
 
 // GetAnnotations returns a view of the annotations written - it is NOT the public annotations API, and
-// the response format should be consistent with the PUT request body format
+// the response format should be consistent with the PUT request body format.
+// The types query parameter selects between the full type hierarchy of each concept (default) and its most specific type.
+// The predicate, type, agentRole, minRelevance, minConfidence, annotatedAfter and annotatedBefore query parameters filter the annotations returned.
+// Clients that prefer application/ld+json in their Accept header get the annotations as a JSON-LD document instead.
+// When reads are cached, the annotations returned may be as old as the cache TTL, which Cache-Control tells clients.
 func (hh *httpHandler) GetAnnotations(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
@@ -47,33 +83,349 @@ func (hh *httpHandler) GetAnnotations(w http.ResponseWriter, r *http.Request) {
 	if lifecycle == "" {
 		writeJSONError(w, "annotationLifecycle required", http.StatusBadRequest)
 		return
-	} else if _, ok := hh.lifecycleMap[lifecycle]; !ok {
+	} else if _, ok := hh.config.get().LifecycleMap[lifecycle]; !ok {
 		writeJSONError(w, "annotationLifecycle not supported by this application", http.StatusBadRequest)
 		return
 	}
 
+	typesFormat := r.URL.Query().Get("types")
+	if typesFormat == "" {
+		typesFormat = annotations.HierarchyTypes
+	} else if !annotations.IsValidTypesFormat(typesFormat) {
+		writeJSONError(w, fmt.Sprintf("types must be either %s or %s", annotations.MostSpecificTypes, annotations.HierarchyTypes), http.StatusBadRequest)
+		return
+	}
+
+	filter, err := readFilterFromQuery(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	tid := transactionidutils.GetTransactionIDFromRequest(r)
-	annotations, found, err := hh.annotationsService.Read(uuid, tid, lifecycle)
+	anns, found, err := annotations.WithContext(r.Context(), hh.annotationsService).Read(uuid, tid, lifecycle, filter)
+	if _, ok := err.(annotations.ValidationError); ok {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		hh.log.WithUUID(uuid).WithTransactionID(tid).WithError(err).Error("failed getting annotations")
 		msg := fmt.Sprintf("Error getting annotations (%v)", err)
-		writeJSONError(w, msg, http.StatusServiceUnavailable)
+		writeUnavailable(w, msg, err)
 		return
 	}
 	if !found {
 		writeJSONError(w, fmt.Sprintf("No annotations found for content with uuid %s.", uuid), http.StatusNotFound)
 		return
 	}
-	annotationJson, _ := json.Marshal(annotations)
+	if readAnns, ok := anns.(annotations.Annotations); ok && typesFormat == annotations.MostSpecificTypes {
+		anns = annotations.WithMostSpecificType(readAnns)
+	}
+
+	w.Header().Set("Vary", "Accept")
+	if hh.cacheMaxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(hh.cacheMaxAge.Seconds())))
+	}
+	if negotiateMediaType(r.Header.Get("Accept"), "application/json", linkeddata.JSONLDMediaType) == linkeddata.JSONLDMediaType {
+		hh.writeJSONLD(w, uuid, tid, lifecycle, anns)
+		return
+	}
+
+	annotationJson, _ := json.Marshal(anns)
 	hh.log.Debugf("Annotations for content (uuid:%s): %s\n", uuid, annotationJson)
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(annotations)
+	json.NewEncoder(w).Encode(anns)
+}
+
+func (hh *httpHandler) writeJSONLD(w http.ResponseWriter, uuid string, tid string, lifecycle string, anns interface{}) {
+	readAnns, ok := anns.(annotations.Annotations)
+	if !ok {
+		writeJSONError(w, "Error converting annotations to JSON-LD", http.StatusInternalServerError)
+		return
+	}
+	doc, err := linkeddata.JSONLD(uuid, lifecycle, readAnns, hh.jsonLDContextURL)
+	if err != nil {
+		hh.log.WithUUID(uuid).WithTransactionID(tid).WithError(err).Error("failed converting annotations to JSON-LD")
+		writeJSONError(w, fmt.Sprintf("Error converting annotations to JSON-LD (%v)", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", linkeddata.JSONLDMediaType+"; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(doc)
+}
+
+// GetJSONLDContext returns the JSON-LD context used by the JSON-LD representation of annotations
+func (hh *httpHandler) GetJSONLDContext(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", linkeddata.JSONLDMediaType+"; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"@context": linkeddata.Context()})
+}
+
+// openPaths are the routes without a lifecycle that stay open when requests are authenticated:
+// the probes of the platform, the API specification and the JSON-LD context that documents link to
+var openPaths = map[string]bool{
+	"/__health":            true,
+	"/__gtg":               true,
+	"/metrics":             true,
+	status.PingPath:        true,
+	status.PingPathDW:      true,
+	status.BuildInfoPath:   true,
+	status.BuildInfoPathDW: true,
+	apiPath:                true,
+	"/__context.jsonld":    true,
+}
+
+// authorise is the middleware allowing a request to a lifecycle only when the guard lets its client use the lifecycle.
+// A job can be read by the client that submitted it. Routes without a lifecycle, apart from the open paths, are admin
+// endpoints and need a client granted them.
+func (hh *httpHandler) authorise(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var client string
+		var err error
+		lifecycle := mux.Vars(r)[lifecyclePropertyName]
+		switch {
+		case lifecycle != "":
+			client, err = hh.guard.Authorise(r, lifecycle)
+		case openPaths[r.URL.Path]:
+			next.ServeHTTP(w, r)
+			return
+		case strings.HasPrefix(r.URL.Path, jobsPath):
+			client, err = hh.guard.AuthoriseClient(r, hh.jobCaller(mux.Vars(r)["id"]))
+		default:
+			client, err = hh.guard.AuthoriseAdmin(r)
+		}
+		if err == nil {
+			if client != "" {
+				hh.log.WithTransactionID(transactionidutils.GetTransactionIDFromRequest(r)).Debugf("Request authorised for client %s", client)
+				r = r.WithContext(auth.NewContext(r.Context(), client))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		hh.log.WithTransactionID(transactionidutils.GetTransactionIDFromRequest(r)).WithError(err).Warn("Request not authorised")
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		switch err.(type) {
+		case auth.ForbiddenError:
+			writeJSONError(w, err.Error(), http.StatusForbidden)
+		case auth.InvalidCredentialsError:
+			w.Header().Set("WWW-Authenticate", authChallenge)
+			writeJSONError(w, err.Error(), http.StatusUnauthorized)
+		case auth.BodyTooLargeError:
+			writeJSONError(w, err.Error(), http.StatusRequestEntityTooLarge)
+		default:
+			if err == auth.ErrNoCredentials {
+				w.Header().Set("WWW-Authenticate", authChallenge)
+				writeJSONError(w, "Credentials are required for this request", http.StatusUnauthorized)
+				return
+			}
+			writeJSONError(w, fmt.Sprintf("Error authorising request (%v)", err), http.StatusBadRequest)
+		}
+	})
+}
+
+// GetWebhooks lists the webhook subscribers, without their secrets, and how many events each was delivered
+func (hh *httpHandler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if hh.webhooks == nil {
+		writeJSONError(w, "Webhooks are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"subscribers": hh.webhooks.Subscribers()})
+}
+
+// GetWebhookDeadLetters lists the most recent webhook events that could not be delivered
+func (hh *httpHandler) GetWebhookDeadLetters(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if hh.webhooks == nil {
+		writeJSONError(w, "Webhooks are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"deadLetters": hh.webhooks.DeadLetters()})
+}
+
+// StreamChanges streams the writes and deletes of annotations as Server-Sent Events. A client reconnecting with the
+// Last-Event-ID header first gets the buffered changes it missed, after a reset event if some are no longer buffered.
+func (hh *httpHandler) StreamChanges(w http.ResponseWriter, r *http.Request) {
+	if hh.changes == nil {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		writeJSONError(w, "The change stream is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		writeJSONError(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var lastID uint64
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		var err error
+		if lastID, err = strconv.ParseUint(value, 10, 64); err != nil {
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			writeJSONError(w, "Last-Event-ID must be the id of a change event", http.StatusBadRequest)
+			return
+		}
+	}
+
+	backlog, complete, sub := hh.changes.Subscribe(lastID)
+	defer sub.Cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if !complete {
+		fmt.Fprintf(w, "event: reset\ndata: %s\n\n", jsonMessage(fmt.Sprintf("Changes after event %d are no longer available", lastID)))
+	}
+	for _, c := range backlog {
+		writeChangeEvent(w, c)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(changeStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case c, open := <-sub.Changes():
+			if !open {
+				return
+			}
+			writeChangeEvent(w, c)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeChangeEvent(w io.Writer, c changes.Change) {
+	data, _ := json.Marshal(c)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", c.ID, c.Type, data)
+}
+
+// lifecycleInfo describes a configured lifecycle in the __lifecycles response
+type lifecycleInfo struct {
+	Lifecycle       string   `json:"lifecycle"`
+	PlatformVersion string   `json:"platformVersion"`
+	OriginSystems   []string `json:"originSystems"`
+	Predicates      []string `json:"predicates"`
+}
+
+// GetLifecycles lists the lifecycles of the configuration in use, with their platform version, origin systems and
+// allowed predicates, and whether messages are forwarded and consumed, so clients need not know the configuration file
+func (hh *httpHandler) GetLifecycles(w http.ResponseWriter, r *http.Request) {
+	config := hh.config.get()
+	names := make([]string, 0, len(config.LifecycleMap))
+	for lifecycle := range config.LifecycleMap {
+		names = append(names, lifecycle)
+	}
+	sort.Strings(names)
+
+	lifecycles := make([]lifecycleInfo, 0, len(names))
+	for _, lifecycle := range names {
+		origins := config.originsOf(lifecycle)
+		if origins == nil {
+			origins = []string{}
+		}
+		lifecycles = append(lifecycles, lifecycleInfo{
+			Lifecycle:       lifecycle,
+			PlatformVersion: config.LifecycleMap[lifecycle],
+			OriginSystems:   origins,
+			Predicates:      annotations.PredicateNames(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"messageType": config.MessageType,
+		"forwarding":  hh.forwarder != nil,
+		"consuming":   hh.consuming,
+		"lifecycles":  lifecycles,
+	})
+}
+
+// ReloadConfig reloads the lifecycle configuration file and responds with the changes made.
+// An invalid configuration is rejected, and the one in use is kept.
+func (hh *httpHandler) ReloadConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if hh.configReloader == nil {
+		writeJSONError(w, "Configuration reloading is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	changes, err := hh.configReloader.Reload("admin endpoint")
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]string{"message": fmt.Sprintf("Configuration rejected, the current one is kept: %v", err)})
+		return
+	}
+	if changes == nil {
+		changes = []string{}
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"changes": changes})
+}
+
+// GetMaintenance returns whether the service is in maintenance mode
+func (hh *httpHandler) GetMaintenance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(hh.maintenance.get())
+}
+
+// SetMaintenance enables or disables maintenance mode, in which annotations can be read but not written or deleted
+func (hh *httpHandler) SetMaintenance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if hh.maintenance == nil {
+		writeJSONError(w, "Maintenance mode is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	var requested maintenanceStatus
+	if err := json.NewDecoder(r.Body).Decode(&requested); err != nil {
+		writeJSONError(w, fmt.Sprintf("Error decoding maintenance mode (%v)", err), http.StatusBadRequest)
+		return
+	}
+	if hh.maintenance.set(requested.Enabled, requested.Reason) {
+		tid := transactionidutils.GetTransactionIDFromRequest(r)
+		if requested.Enabled {
+			hh.log.WithTransactionID(tid).Warnf("Maintenance mode enabled, writes and deletes are rejected and consumption is paused: %s", requested.Reason)
+		} else {
+			hh.log.WithTransactionID(tid).Info("Maintenance mode disabled, writes and deletes are accepted and consumption resumes")
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(hh.maintenance.get())
+}
+
+// writeInMaintenance responds with a 503 if the service is in maintenance mode, returning whether it did
+func (hh *httpHandler) writeInMaintenance(w http.ResponseWriter) bool {
+	status := hh.maintenance.get()
+	if !status.Enabled {
+		return false
+	}
+	msg := fmt.Sprintf("The service is in maintenance mode since %s, annotations can be read but not written or deleted", status.Since.Format(time.RFC3339))
+	if status.Reason != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, status.Reason)
+	}
+	writeJSONError(w, msg, http.StatusServiceUnavailable)
+	return true
 }
 
 // DeleteAnnotations will delete all the annotations for a piece of content
 func (hh *httpHandler) DeleteAnnotations(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if hh.writeInMaintenance(w) {
+		return
+	}
 
 	vars := mux.Vars(r)
 	uuid := vars["uuid"]
@@ -86,22 +438,52 @@ func (hh *httpHandler) DeleteAnnotations(w http.ResponseWriter, r *http.Request)
 	if lifecycle == "" {
 		writeJSONError(w, "annotationLifecycle required", http.StatusBadRequest)
 		return
-	} else if _, ok := hh.lifecycleMap[lifecycle]; !ok {
+	}
+	config := hh.config.get()
+	if _, ok := config.LifecycleMap[lifecycle]; !ok {
 		writeJSONError(w, "annotationLifecycle not supported by this application", http.StatusBadRequest)
 		return
 	}
 
+	originSystem, err := originSystemOf(r, config, lifecycle)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	tid := transactionidutils.GetTransactionIDFromRequest(r)
-	found, err := hh.annotationsService.Delete(uuid, tid, lifecycle)
+	service := annotations.WithContext(r.Context(), hh.annotationsService)
+	before := readBefore(service, hh.changes != nil || hh.audit != nil, hh.log, uuid, tid, lifecycle)
+	found, err := service.Delete(uuid, tid, lifecycle)
 	if err != nil {
 		hh.log.WithUUID(uuid).WithTransactionID(tid).WithError(err).Error("failed deleting annotations")
-		writeJSONError(w, err.Error(), http.StatusServiceUnavailable)
+		writeUnavailable(w, err.Error(), err)
 		return
 	}
 	if !found {
 		writeJSONError(w, fmt.Sprintf("No annotations found for content with uuid %s.", uuid), http.StatusNotFound)
 		return
 	}
+	hh.log.WithTransactionID(tid).WithUUID(uuid).WithField("originSystem", originSystem).Infof("Annotations for lifecycle %s deleted", lifecycle)
+	hh.audit.Record(audit.Record{
+		Action:        audit.Delete,
+		Source:        audit.HTTP,
+		TransactionID: tid,
+		OriginSystem:  originSystem,
+		Caller:        auth.ClientFrom(r.Context()),
+		Lifecycle:     lifecycle,
+		UUID:          uuid,
+		Before:        before,
+	})
+	hh.webhooks.Notify(webhooks.Event{
+		Type:            webhooks.Deleted,
+		UUID:            uuid,
+		Lifecycle:       lifecycle,
+		PlatformVersion: config.LifecycleMap[lifecycle],
+		OriginSystem:    originSystem,
+		TransactionID:   tid,
+	})
+	hh.changes.Deleted(uuid, tid, lifecycle, before)
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusNoContent)
 	w.Write([]byte(jsonMessage(fmt.Sprintf("Annotations for content %s deleted", uuid))))
@@ -113,23 +495,25 @@ func (hh *httpHandler) CountAnnotations(w http.ResponseWriter, r *http.Request)
 	if lifecycle == "" {
 		writeJSONError(w, "annotationLifecycle required", http.StatusBadRequest)
 		return
-	} else if _, ok := hh.lifecycleMap[lifecycle]; !ok {
+	}
+	lifecycleMap := hh.config.get().LifecycleMap
+	if _, ok := lifecycleMap[lifecycle]; !ok {
 		writeJSONError(w, "annotationLifecycle not supported by this application", http.StatusBadRequest)
 		return
 	}
 
-	platformVersion, found := hh.lifecycleMap[lifecycle]
+	platformVersion, found := lifecycleMap[lifecycle]
 	if !found {
 		writeJSONError(w, "platformVersion not found for this annotation lifecycle", http.StatusBadRequest)
 		return
 	}
 
-	count, err := hh.annotationsService.Count(lifecycle, platformVersion)
+	count, err := annotations.WithContext(r.Context(), hh.annotationsService).Count(lifecycle, platformVersion)
 
 	w.Header().Add("Content-Type", "application/json")
 
 	if err != nil {
-		writeJSONError(w, err.Error(), http.StatusServiceUnavailable)
+		writeUnavailable(w, err.Error(), err)
 		return
 	}
 	enc := json.NewEncoder(w)
@@ -140,10 +524,67 @@ func (hh *httpHandler) CountAnnotations(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// ExportAnnotations streams every annotation in the lifecycle as RDF, in N-Triples or, if the Accept header prefers it, Turtle.
+// Errors after the first triples were sent can't change the response status, so they are reported in a trailing comment.
+func (hh *httpHandler) ExportAnnotations(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	lifecycle := vars[lifecyclePropertyName]
+	if _, ok := hh.config.get().LifecycleMap[lifecycle]; !ok {
+		writeJSONError(w, "annotationLifecycle not supported by this application", http.StatusBadRequest)
+		return
+	}
+
+	mediaType := negotiateMediaType(r.Header.Get("Accept"), linkeddata.NTriplesMediaType, linkeddata.TurtleMediaType)
+	if mediaType == "" {
+		writeJSONError(w, fmt.Sprintf("Export is only available as %s or %s", linkeddata.NTriplesMediaType, linkeddata.TurtleMediaType), http.StatusNotAcceptable)
+		return
+	}
+	rw, _ := linkeddata.NewRDFWriter(w, mediaType)
+
+	tid := transactionidutils.GetTransactionIDFromRequest(r)
+	started := false
+	start := func() {
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", mediaType+"; charset=UTF-8")
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+
+	count := 0
+	err := annotations.WithContext(r.Context(), hh.annotationsService).Export(lifecycle, func(content annotations.ContentAnnotations) error {
+		start()
+		count++
+		if err := rw.Write(lifecycle, content); err != nil {
+			return err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		hh.log.WithTransactionID(tid).WithError(err).Errorf("failed exporting annotations for lifecycle %s after %d pieces of content", lifecycle, count)
+		if !started {
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			writeUnavailable(w, fmt.Sprintf("Error exporting annotations (%v)", err), err)
+			return
+		}
+		rw.Comment(fmt.Sprintf("export incomplete: %v", err))
+		return
+	}
+	start()
+	hh.log.WithTransactionID(tid).Infof("exported annotations of %d pieces of content for lifecycle %s", count, lifecycle)
+}
+
 // PutAnnotations handles the replacement of a set of annotations for a given bit of content
 func (hh *httpHandler) PutAnnotations(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if hh.writeInMaintenance(w) {
+		return
+	}
 	if err := isContentTypeJSON(r); err != nil {
+		hh.metrics.ValidationFailed("content_type")
 		http.Error(w, string(jsonMessage(err.Error())), http.StatusBadRequest)
 		return
 	}
@@ -160,62 +601,64 @@ func (hh *httpHandler) PutAnnotations(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	platformVersion, ok := hh.lifecycleMap[lifecycle]
+	config := hh.config.get()
+	platformVersion, ok := config.LifecycleMap[lifecycle]
 	if !ok {
 		writeJSONError(w, "annotationLifecycle not supported by this application", http.StatusBadRequest)
 		return
 	}
 
-	var originSystem string
-	for k, v := range hh.originMap {
-		if v == lifecycle {
-			originSystem = k
-			break
-		}
+	originSystem, err := originSystemOf(r, config, lifecycle)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	if originSystem == "" {
-		writeJSONError(w, "No Origin-System-Id could be deduced from the lifecycle parameter", http.StatusBadRequest)
+
+	if allowed, retryAfter := hh.limits.Allow(lifecycle, originSystem); !allowed {
+		w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+		writeJSONError(w, fmt.Sprintf("Too many requests for annotation lifecycle %s", lifecycle), http.StatusTooManyRequests)
 		return
 	}
 
-	anns, err := decode(r.Body)
+	anns, status, err := hh.validate(r)
 	if err != nil {
-		msg := fmt.Sprintf("Error (%v) parsing annotation request", err)
-		writeJSONError(w, msg, http.StatusBadRequest)
+		writeJSONError(w, err.Error(), status)
 		return
 	}
 
-	tid := transactionidutils.GetTransactionIDFromRequest(r)
-	err = hh.annotationsService.Write(uuid, lifecycle, platformVersion, tid, anns)
+	write := annotationsWrite{
+		uuid:            uuid,
+		lifecycle:       lifecycle,
+		platformVersion: platformVersion,
+		originSystem:    originSystem,
+		caller:          auth.ClientFrom(r.Context()),
+		tid:             transactionidutils.GetTransactionIDFromRequest(r),
+		annotations:     anns,
+	}
+	if hh.jobs != nil && prefersAsync(r) {
+		hh.putAsync(tracing.Detach(r.Context()), w, write)
+		return
+	}
+
+	err = hh.save(r.Context(), write)
 	if err == annotations.UnsupportedPredicateErr {
-		hh.log.WithUUID(uuid).WithTransactionID(tid).WithError(err).Error("invalid predicate provided")
 		writeJSONError(w, "Please provide a valid predicate, or leave blank for the default predicate (MENTIONS)", http.StatusBadRequest)
 		return
 	}
-
 	if err != nil {
-		hh.log.WithUUID(uuid).WithTransactionID(tid).WithError(err).Error("failed writing annotations")
 		msg := fmt.Sprintf("Error creating annotations (%v)", err)
 		if _, ok := err.(annotations.ValidationError); ok {
 			writeJSONError(w, msg, http.StatusBadRequest)
 			return
 		}
-		hh.log.WithMonitoringEvent("SaveNeo4j", tid, hh.messageType).WithUUID(uuid).WithError(err).Error(msg)
-		writeJSONError(w, msg, http.StatusServiceUnavailable)
+		writeUnavailable(w, msg, err)
 		return
 	}
-	hh.log.WithMonitoringEvent("SaveNeo4j", tid, hh.messageType).WithUUID(uuid).Infof("%s successfully written in Neo4j", hh.messageType)
 
-	if hh.forwarder != nil {
-		hh.log.WithTransactionID(tid).WithUUID(uuid).Debug("Forwarding message to the next queue")
-		err = hh.forwarder.SendMessage(tid, originSystem, platformVersion, uuid, anns)
-		if err != nil {
-			msg := "Failed to forward message to queue"
-			hh.log.WithTransactionID(tid).WithUUID(uuid).WithError(err).Error(msg)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(jsonMessage(msg)))
-			return
-		}
+	if err = hh.forward(r.Context(), write); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(jsonMessage(forwardFailedMessage)))
+		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
@@ -223,9 +666,271 @@ func (hh *httpHandler) PutAnnotations(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+const forwardFailedMessage = "Failed to forward message to queue"
+
+// validate reads the annotations of a PUT request body and checks them against the quotas and the API specification,
+// returning the status code of the response when they are invalid
+func (hh *httpHandler) validate(r *http.Request) (anns annotations.Annotations, status int, err error) {
+	_, span := tracing.Start(r.Context(), "validate")
+	defer func() { tracing.End(span, err) }()
+
+	body, err := hh.limits.ReadBody(r.Body)
+	if _, ok := err.(limits.QuotaExceededError); ok {
+		hh.metrics.ValidationFailed("body_too_large")
+		return nil, http.StatusRequestEntityTooLarge, err
+	}
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Errorf("Error (%v) reading annotation request", err)
+	}
+	if hh.apiSpec != nil {
+		if err = hh.apiSpec.validate("Annotations", body); err != nil {
+			hh.metrics.ValidationFailed("schema")
+			return nil, http.StatusBadRequest, errors.Errorf("Invalid annotation request: %v", err)
+		}
+	}
+
+	anns, err = decode(bytes.NewReader(body))
+	if err != nil {
+		hh.metrics.ValidationFailed("invalid_json")
+		return nil, http.StatusBadRequest, errors.Errorf("Error (%v) parsing annotation request", err)
+	}
+	if err = hh.limits.CheckAnnotations(len(anns)); err != nil {
+		hh.metrics.ValidationFailed("too_many_annotations")
+		return nil, http.StatusRequestEntityTooLarge, err
+	}
+	return anns, http.StatusOK, nil
+}
+
+// annotationsWrite is the replacement of the annotations of a piece of content, validated and ready to be written
+type annotationsWrite struct {
+	uuid            string
+	lifecycle       string
+	platformVersion string
+	originSystem    string
+	caller          string
+	tid             string
+	annotations     annotations.Annotations
+}
+
+// save writes the annotations to Neo4j, as part of ctx
+func (hh *httpHandler) save(ctx context.Context, write annotationsWrite) error {
+	service := annotations.WithContext(ctx, hh.annotationsService)
+	before := readBefore(service, hh.changes != nil || hh.audit != nil, hh.log, write.uuid, write.tid, write.lifecycle)
+	err := service.Write(write.uuid, write.lifecycle, write.platformVersion, write.tid, write.annotations)
+	if err == annotations.UnsupportedPredicateErr {
+		hh.log.WithUUID(write.uuid).WithTransactionID(write.tid).WithError(err).Error("invalid predicate provided")
+		return err
+	}
+	if err != nil {
+		hh.log.WithUUID(write.uuid).WithTransactionID(write.tid).WithError(err).Error("failed writing annotations")
+		if _, ok := err.(annotations.ValidationError); !ok {
+			hh.log.WithMonitoringEvent("SaveNeo4j", write.tid, hh.messageType).WithUUID(write.uuid).WithError(err).Error(fmt.Sprintf("Error creating annotations (%v)", err))
+		}
+		return err
+	}
+	hh.log.WithMonitoringEvent("SaveNeo4j", write.tid, hh.messageType).WithUUID(write.uuid).WithField("originSystem", write.originSystem).Infof("%s successfully written in Neo4j", hh.messageType)
+	hh.audit.Record(audit.Record{
+		Action:        audit.Write,
+		Source:        audit.HTTP,
+		TransactionID: write.tid,
+		OriginSystem:  write.originSystem,
+		Caller:        write.caller,
+		Lifecycle:     write.lifecycle,
+		UUID:          write.uuid,
+		Before:        before,
+		After:         write.annotations,
+	})
+	hh.webhooks.Notify(webhooks.Event{
+		Type:            webhooks.Written,
+		UUID:            write.uuid,
+		Lifecycle:       write.lifecycle,
+		PlatformVersion: write.platformVersion,
+		OriginSystem:    write.originSystem,
+		TransactionID:   write.tid,
+		Annotations:     write.annotations,
+	})
+	hh.changes.Written(write.uuid, write.tid, write.lifecycle, before)
+	return nil
+}
+
+// forward sends the annotations written to the next queue, as part of ctx, when forwarding is enabled
+func (hh *httpHandler) forward(ctx context.Context, write annotationsWrite) error {
+	if hh.forwarder == nil {
+		return nil
+	}
+	hh.log.WithTransactionID(write.tid).WithUUID(write.uuid).Debug("Forwarding message to the next queue")
+	err := forwarder.WithContext(ctx, hh.forwarder).SendMessage(write.tid, write.originSystem, write.platformVersion, write.uuid, write.annotations)
+	if err != nil {
+		hh.log.WithTransactionID(write.tid).WithUUID(write.uuid).WithError(err).Error(forwardFailedMessage)
+	}
+	return err
+}
+
+// putAsync queues the write as a job and responds with its status, for clients that prefer not to wait for it.
+// The job runs as part of ctx, which should outlive the request.
+func (hh *httpHandler) putAsync(ctx context.Context, w http.ResponseWriter, write annotationsWrite) {
+	attributes := map[string]string{
+		"uuid":                write.uuid,
+		lifecyclePropertyName: write.lifecycle,
+		"originSystem":        write.originSystem,
+		"transactionId":       write.tid,
+	}
+	if write.caller != "" {
+		// the client that submitted the job may follow it up without the admin grant
+		attributes[callerAttribute] = write.caller
+	}
+	job, err := hh.jobs.Submit(attributes, func(run *jobs.Run) error {
+		if err := run.Stage("neo4j", func() error { return hh.save(ctx, write) }); err != nil {
+			return err
+		}
+		if hh.forwarder == nil {
+			return nil
+		}
+		return run.Stage("forward", func() error { return hh.forward(ctx, write) })
+	})
+	if err != nil {
+		hh.log.WithTransactionID(write.tid).WithUUID(write.uuid).WithError(err).Warn("asynchronous write refused")
+		writeJSONError(w, fmt.Sprintf("Asynchronous write refused: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	hh.log.WithTransactionID(write.tid).WithUUID(write.uuid).WithField("job", job.ID).Info("asynchronous write queued")
+	w.Header().Set("Location", jobsPath+job.ID)
+	w.Header().Set("Preference-Applied", respondAsync)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+const (
+	respondAsync    = "respond-async"
+	jobsPath        = "/__jobs/"
+	callerAttribute = "caller"
+)
+
+// prefersAsync tells whether the client asked for an asynchronous response with a Prefer: respond-async header
+func prefersAsync(r *http.Request) bool {
+	for _, header := range r.Header["Prefer"] {
+		for _, preference := range strings.Split(header, ",") {
+			token := strings.TrimSpace(strings.SplitN(preference, ";", 2)[0])
+			if strings.EqualFold(token, respondAsync) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetJob reports the status of an asynchronous write, with the timing and error of each of its stages
+func (hh *httpHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if hh.jobs == nil {
+		writeJSONError(w, "Asynchronous writes are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	job, found := hh.jobs.Get(id)
+	if !found {
+		writeJSONError(w, fmt.Sprintf("No job found with id %s, it may have expired", id), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(job)
+}
+
+// jobCaller returns the client that submitted the job, empty when it is unknown
+func (hh *httpHandler) jobCaller(id string) string {
+	if hh.jobs == nil {
+		return ""
+	}
+	job, _ := hh.jobs.Get(id)
+	return job.Attributes[callerAttribute]
+}
+
+// ListJobs lists the asynchronous writes that have not expired, most recent first, optionally only those with a status
+func (hh *httpHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if hh.jobs == nil {
+		writeJSONError(w, "Asynchronous writes are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	status := jobs.Status(r.URL.Query().Get("status"))
+	list := []jobs.Job{}
+	for _, job := range hh.jobs.List() {
+		if status == "" || job.Status == status {
+			list = append(list, job)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"jobs": list})
+}
+
+// GetAuditRecords lists the audit records of a piece of content, oldest first, optionally only those of a lifecycle
+// and those made in the time range given by the from and to RFC 3339 times
+func (hh *httpHandler) GetAuditRecords(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if hh.audit == nil {
+		writeJSONError(w, "The audit log is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	params := r.URL.Query()
+	query := audit.Query{UUID: mux.Vars(r)["uuid"], Lifecycle: params.Get(lifecyclePropertyName)}
+	for name, t := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		if value := params.Get(name); value != "" {
+			var err error
+			if *t, err = time.Parse(time.RFC3339, value); err != nil {
+				writeJSONError(w, fmt.Sprintf("%s must be an RFC 3339 time, such as 2006-01-02T15:04:05Z", name), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	records, err := hh.audit.Query(query)
+	if err == audit.ErrQueryNotSupported {
+		writeJSONError(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		hh.log.WithUUID(query.UUID).WithError(err).Error("failed querying the audit log")
+		writeJSONError(w, "Error querying the audit log", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"records": records})
+}
+
+// GetMetrics exposes the metrics of the service in the Prometheus text format
+func (hh *httpHandler) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	if hh.metrics == nil {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		writeJSONError(w, "Metrics are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	hh.metrics.ServeHTTP(w, r)
+}
+
 func writeJSONError(w http.ResponseWriter, errorMsg string, statusCode int) {
 	w.WriteHeader(statusCode)
-	fmt.Fprintln(w, fmt.Sprintf("{\"message\": \"%s\"}", errorMsg))
+	json.NewEncoder(w).Encode(map[string]string{"message": errorMsg})
+}
+
+// writeUnavailable responds with a 503, telling the client when to retry while the Neo4j circuit breaker is open
+func writeUnavailable(w http.ResponseWriter, errorMsg string, err error) {
+	if retryAfter, open := breaker.RetryAfter(err); open {
+		w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+	}
+	writeJSONError(w, errorMsg, http.StatusServiceUnavailable)
+}
+
+// retryAfterSeconds formats a wait as the whole number of seconds of a Retry-After header, rounded up
+func retryAfterSeconds(wait time.Duration) string {
+	seconds := int64(wait / time.Second)
+	if wait%time.Second != 0 {
+		seconds++
+	}
+	return strconv.FormatInt(seconds, 10)
 }
 
 func jsonMessage(msgText string) []byte {
@@ -234,10 +939,59 @@ func jsonMessage(msgText string) []byte {
 
 func decode(body io.Reader) (annotations.Annotations, error) {
 	var anns annotations.Annotations
-	err := json.NewDecoder(body).Decode(&anns)
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&anns)
 	return anns, err
 }
 
+func readFilterFromQuery(query url.Values) (annotations.ReadFilter, error) {
+	filter := annotations.ReadFilter{
+		Predicates: query["predicate"],
+		Types:      query["type"],
+		AgentRoles: query["agentRole"],
+	}
+
+	var err error
+	if filter.MinRelevance, err = parseScoreParam(query, "minRelevance"); err != nil {
+		return filter, err
+	}
+	if filter.MinConfidence, err = parseScoreParam(query, "minConfidence"); err != nil {
+		return filter, err
+	}
+	if filter.AnnotatedAfter, err = parseTimeParam(query, "annotatedAfter"); err != nil {
+		return filter, err
+	}
+	if filter.AnnotatedBefore, err = parseTimeParam(query, "annotatedBefore"); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+func parseScoreParam(query url.Values, name string) (*float64, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	score, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, errors.Errorf("%s must be a number", name)
+	}
+	return &score, nil
+}
+
+func parseTimeParam(query url.Values, name string) (time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.Errorf("%s must be an RFC3339 date-time", name)
+	}
+	return t, nil
+}
+
 func isContentTypeJSON(r *http.Request) error {
 	contentType := strings.ToLower(r.Header.Get("Content-Type"))
 	if !strings.Contains(contentType, "application/json") {
@@ -245,3 +999,27 @@ func isContentTypeJSON(r *http.Request) error {
 	}
 	return nil
 }
+
+// originSystemOf returns the origin system of a write to the lifecycle: the one named by the X-Origin-System-Id header,
+// which must write that lifecycle, or else the only origin system writing it
+func originSystemOf(r *http.Request, config lifecycleConfig, lifecycle string) (string, error) {
+	if originSystem := r.Header.Get(originSystemHeader); originSystem != "" {
+		originLifecycle, found := config.OriginMap[originSystem]
+		if !found {
+			return "", errors.Errorf("Origin system %s is not supported by this application", originSystem)
+		}
+		if originLifecycle != lifecycle {
+			return "", errors.Errorf("Origin system %s does not write annotationLifecycle %s", originSystem, lifecycle)
+		}
+		return originSystem, nil
+	}
+
+	origins := config.originsOf(lifecycle)
+	switch len(origins) {
+	case 0:
+		return "", errors.New("No Origin-System-Id could be deduced from the lifecycle parameter")
+	case 1:
+		return origins[0], nil
+	}
+	return "", errors.Errorf("%s header required, as annotationLifecycle %s is written by origin systems %s", originSystemHeader, lifecycle, strings.Join(origins, ", "))
+}
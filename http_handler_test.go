@@ -2,14 +2,29 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/audit"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/auth"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/breaker"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/changes"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/jobs"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/limits"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/stats"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/webhooks"
 
 	logger "github.com/Financial-Times/go-logger/v2"
 	"github.com/Financial-Times/kafka-client-go/kafka"
@@ -60,6 +75,16 @@ func (suite *HttpHandlerTestSuite) SetupTest() {
 	assert.NoError(suite.T(), err, "Unexpected error")
 }
 
+func (suite *HttpHandlerTestSuite) newHTTPHandler() *httpHandler {
+	return &httpHandler{
+		annotationsService: suite.annotationsService,
+		forwarder:          suite.forwarder,
+		config:             newLiveConfig(lifecycleConfig{OriginMap: suite.originMap, LifecycleMap: suite.lifecycleMap, MessageType: suite.messageType}),
+		messageType:        suite.messageType,
+		log:                suite.log,
+	}
+}
+
 func TestHttpHandlerTestSuite(t *testing.T) {
 	suite.Run(t, new(HttpHandlerTestSuite))
 }
@@ -69,37 +94,263 @@ func (suite *HttpHandlerTestSuite) TestPutHandler_Success() {
 	suite.forwarder.On("SendMessage", suite.tid, "http://cmdb.ft.com/systems/methode-web-pub", platformVersion, knownUUID, suite.annotations).Return(nil).Once()
 	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
 	request.Header.Add("X-Request-Id", suite.tid)
-	handler := httpHandler{suite.annotationsService, suite.forwarder, suite.originMap, suite.lifecycleMap, suite.messageType, suite.log}
+	handler := suite.newHTTPHandler()
 	rec := httptest.NewRecorder()
-	router(&handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
 	assert.True(suite.T(), http.StatusCreated == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusCreated))
 	assert.JSONEq(suite.T(), message("Annotations for content 12345 created"), rec.Body.String(), "Wrong body")
 	suite.forwarder.AssertExpectations(suite.T())
 }
 
+func (suite *HttpHandlerTestSuite) TestPutHandler_OriginSystemHeader() {
+	originSystem := "http://cmdb.ft.com/systems/methode-web-pub-v2"
+	suite.originMap[originSystem] = annotationLifecycle
+	suite.annotationsService.On("Write", knownUUID, annotationLifecycle, platformVersion, suite.tid, suite.annotations).Return(nil)
+	suite.forwarder.On("SendMessage", suite.tid, originSystem, platformVersion, knownUUID, suite.annotations).Return(nil).Once()
+	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
+	request.Header.Add("X-Request-Id", suite.tid)
+	request.Header.Add("X-Origin-System-Id", originSystem)
+	rec := httptest.NewRecorder()
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.Equal(suite.T(), http.StatusCreated, rec.Code, "Wrong response code")
+	suite.forwarder.AssertExpectations(suite.T())
+}
+
+func (suite *HttpHandlerTestSuite) TestPutHandler_OriginSystemRequiredForSharedLifecycle() {
+	suite.originMap["http://cmdb.ft.com/systems/methode-web-pub-v2"] = annotationLifecycle
+	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
+	rec := httptest.NewRecorder()
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code, "Wrong response code")
+	assert.JSONEq(suite.T(), message("X-Origin-System-Id header required, as annotationLifecycle annotations-v1 is written by origin systems http://cmdb.ft.com/systems/methode-web-pub, http://cmdb.ft.com/systems/methode-web-pub-v2"), rec.Body.String(), "Wrong body")
+	suite.annotationsService.AssertNotCalled(suite.T(), "Write", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (suite *HttpHandlerTestSuite) TestPutHandler_InvalidOriginSystem() {
+	tests := []struct {
+		originSystem string
+		msg          string
+	}{
+		{"http://cmdb.ft.com/systems/unknown", "Origin system http://cmdb.ft.com/systems/unknown is not supported by this application"},
+		{"http://cmdb.ft.com/systems/pac", "Origin system http://cmdb.ft.com/systems/pac does not write annotationLifecycle annotations-v1"},
+	}
+	for _, test := range tests {
+		request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
+		request.Header.Add("X-Origin-System-Id", test.originSystem)
+		rec := httptest.NewRecorder()
+		router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+		assert.Equal(suite.T(), http.StatusBadRequest, rec.Code, test.originSystem)
+		assert.JSONEq(suite.T(), message(test.msg), rec.Body.String(), test.originSystem)
+	}
+	suite.annotationsService.AssertNotCalled(suite.T(), "Write", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (suite *HttpHandlerTestSuite) TestPutHandler_Async() {
+	suite.annotationsService.On("Write", knownUUID, annotationLifecycle, platformVersion, suite.tid, suite.annotations).Return(nil)
+	suite.forwarder.On("SendMessage", suite.tid, "http://cmdb.ft.com/systems/methode-web-pub", platformVersion, knownUUID, suite.annotations).Return(errors.New("kafka is down"))
+	handler := suite.newHTTPHandler()
+	handler.jobs = jobs.NewPool(1, 1, time.Hour)
+	r := router(handler, &suite.healthCheckHandler, suite.log)
+
+	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
+	request.Header.Add("X-Request-Id", suite.tid)
+	request.Header.Add("Prefer", "respond-async, wait=10")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, request)
+	assert.Equal(suite.T(), http.StatusAccepted, rec.Code, "Wrong response code")
+	assert.Equal(suite.T(), "respond-async", rec.Header().Get("Preference-Applied"))
+
+	var job jobs.Job
+	assert.NoError(suite.T(), json.Unmarshal(rec.Body.Bytes(), &job))
+	assert.Equal(suite.T(), "/__jobs/"+job.ID, rec.Header().Get("Location"))
+	assert.Equal(suite.T(), map[string]string{
+		"uuid":                knownUUID,
+		"annotationLifecycle": annotationLifecycle,
+		"originSystem":        "http://cmdb.ft.com/systems/methode-web-pub",
+		"transactionId":       suite.tid,
+	}, job.Attributes)
+
+	assert.NoError(suite.T(), handler.jobs.Drain(context.Background()))
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest("GET", "/__jobs/"+job.ID, "", nil))
+	assert.Equal(suite.T(), http.StatusOK, rec.Code, "Wrong response code")
+	assert.NoError(suite.T(), json.Unmarshal(rec.Body.Bytes(), &job))
+	assert.Equal(suite.T(), jobs.Failed, job.Status)
+	assert.Equal(suite.T(), "kafka is down", job.Error)
+	if assert.Len(suite.T(), job.Stages, 2) {
+		assert.Equal(suite.T(), "neo4j", job.Stages[0].Name)
+		assert.Equal(suite.T(), jobs.Succeeded, job.Stages[0].Status)
+		assert.Equal(suite.T(), "forward", job.Stages[1].Name)
+		assert.Equal(suite.T(), jobs.Failed, job.Stages[1].Status)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest("GET", "/__jobs?status=succeeded", "", nil))
+	assert.JSONEq(suite.T(), `{"jobs": []}`, rec.Body.String(), "Wrong body")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest("GET", "/__jobs?status=failed", "", nil))
+	var list struct {
+		Jobs []jobs.Job `json:"jobs"`
+	}
+	assert.NoError(suite.T(), json.Unmarshal(rec.Body.Bytes(), &list))
+	assert.Len(suite.T(), list.Jobs, 1)
+}
+
+func (suite *HttpHandlerTestSuite) TestPutHandler_AsyncDisabled() {
+	suite.annotationsService.On("Write", knownUUID, annotationLifecycle, platformVersion, suite.tid, suite.annotations).Return(nil)
+	suite.forwarder.On("SendMessage", suite.tid, "http://cmdb.ft.com/systems/methode-web-pub", platformVersion, knownUUID, suite.annotations).Return(nil)
+	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
+	request.Header.Add("X-Request-Id", suite.tid)
+	request.Header.Add("Prefer", "respond-async")
+	rec := httptest.NewRecorder()
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.Equal(suite.T(), http.StatusCreated, rec.Code, "The preference should be ignored when asynchronous writes are disabled")
+
+	rec = httptest.NewRecorder()
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, newRequest("GET", "/__jobs/1234", "", nil))
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, rec.Code, "Wrong response code")
+}
+
+func (suite *HttpHandlerTestSuite) TestGetJob_NotFound() {
+	handler := suite.newHTTPHandler()
+	handler.jobs = jobs.NewPool(1, 1, time.Hour)
+	rec := httptest.NewRecorder()
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, newRequest("GET", "/__jobs/1234", "", nil))
+	assert.Equal(suite.T(), http.StatusNotFound, rec.Code, "Wrong response code")
+}
+
+func (suite *HttpHandlerTestSuite) TestWritesNotifyWebhooks() {
+	var mu sync.Mutex
+	var events []webhooks.Event
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e webhooks.Event
+		json.NewDecoder(r.Body).Decode(&e)
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}))
+	defer subscriber.Close()
+
+	suite.annotationsService.On("Write", knownUUID, annotationLifecycle, platformVersion, suite.tid, suite.annotations).Return(nil)
+	suite.annotationsService.On("Delete", knownUUID, suite.tid, annotationLifecycle).Return(true, nil)
+	suite.forwarder.On("SendMessage", suite.tid, "http://cmdb.ft.com/systems/methode-web-pub", platformVersion, knownUUID, suite.annotations).Return(nil)
+	handler := suite.newHTTPHandler()
+	handler.webhooks = webhooks.New([]webhooks.Subscriber{{ID: "test", URL: subscriber.URL, Secret: "s3cret"}}, webhooks.Config{}.Options(), suite.log)
+	r := router(handler, &suite.healthCheckHandler, suite.log)
+
+	for _, method := range []string{"PUT", "DELETE"} {
+		request := newRequest(method, fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
+		request.Header.Add("X-Request-Id", suite.tid)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, request)
+		assert.True(suite.T(), rec.Code < 300, "Wrong response code to %s, was %d", method, rec.Code)
+	}
+	assert.NoError(suite.T(), handler.webhooks.Drain(context.Background()))
+
+	if assert.Len(suite.T(), events, 2) {
+		assert.Equal(suite.T(), webhooks.Written, events[0].Type)
+		assert.Equal(suite.T(), "http://cmdb.ft.com/systems/methode-web-pub", events[0].OriginSystem)
+		assert.Equal(suite.T(), suite.annotations, events[0].Annotations)
+		assert.Equal(suite.T(), webhooks.Deleted, events[1].Type)
+		assert.Equal(suite.T(), platformVersion, events[1].PlatformVersion)
+	}
+}
+
+func (suite *HttpHandlerTestSuite) TestStreamChanges() {
+	suite.annotationsService.On("Read", knownUUID, suite.tid, annotationLifecycle, annotations.ReadFilter{}).Return(annotations.Annotations{}, false, nil).Once()
+	suite.annotationsService.On("Read", knownUUID, suite.tid, annotationLifecycle, annotations.ReadFilter{}).Return(suite.annotations, true, nil)
+	suite.annotationsService.On("Write", knownUUID, annotationLifecycle, platformVersion, suite.tid, suite.annotations).Return(nil)
+	suite.annotationsService.On("Delete", knownUUID, suite.tid, annotationLifecycle).Return(true, nil)
+	suite.forwarder.On("SendMessage", suite.tid, "http://cmdb.ft.com/systems/methode-web-pub", platformVersion, knownUUID, suite.annotations).Return(nil)
+	handler := suite.newHTTPHandler()
+	handler.changes = changes.NewStream(10, annotationsReader(suite.annotationsService), suite.log)
+	r := router(handler, &suite.healthCheckHandler, suite.log)
+
+	for _, method := range []string{"PUT", "DELETE"} {
+		request := newRequest(method, fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
+		request.Header.Add("X-Request-Id", suite.tid)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, request)
+		assert.True(suite.T(), rec.Code < 300, "Wrong response code to %s, was %d", method, rec.Code)
+	}
+	// Closing the stream ends the responses once the buffered changes are sent
+	handler.changes.Close()
+
+	stream := func(lastEventID string) *httptest.ResponseRecorder {
+		request := newRequest("GET", "/__changes/stream", "", nil)
+		request.Header.Set("Last-Event-ID", lastEventID)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, request)
+		return rec
+	}
+
+	rec := stream("1")
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.Equal(suite.T(), "text/event-stream", rec.Header().Get("Content-Type"))
+	written, _ := json.Marshal(suite.annotations)
+	assert.Regexp(suite.T(), `^id: 2\nevent: annotations.deleted\ndata: \{"id":2,"type":"annotations.deleted","uuid":"12345","annotationLifecycle":"annotations-v1","transactionId":"tid_sample",.*"added":\[\],"removed":`+regexp.QuoteMeta(string(written))+`,"changed":\[\]\}\n\n$`, rec.Body.String())
+
+	rec = stream("99")
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.Regexp(suite.T(), `^event: reset\ndata: \{"message":"Changes after event 99 are no longer available"\}\n\nid: 1\nevent: annotations.written\n.*\n\nid: 2\nevent: annotations.deleted\n`, rec.Body.String())
+
+	assert.Equal(suite.T(), http.StatusBadRequest, stream("latest").Code)
+
+	handler.changes = nil
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, stream("").Code)
+}
+
 func (suite *HttpHandlerTestSuite) TestPutHandler_ParseError() {
 	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", []byte(`{"id": "1234"}`))
 	request.Header.Add("X-Request-Id", suite.tid)
-	handler := httpHandler{suite.annotationsService, suite.forwarder, suite.originMap, suite.lifecycleMap, suite.messageType, suite.log}
+	handler := suite.newHTTPHandler()
 	rec := httptest.NewRecorder()
-	router(&handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
 	assert.True(suite.T(), http.StatusBadRequest == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusBadRequest))
 }
 
 func (suite *HttpHandlerTestSuite) TestPutHandler_ValidationError() {
 	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", []byte(`"{"thing": {"prefLabel": "Apple"}`))
 	request.Header.Add("X-Request-Id", suite.tid)
-	handler := httpHandler{suite.annotationsService, suite.forwarder, suite.originMap, suite.lifecycleMap, suite.messageType, suite.log}
+	handler := suite.newHTTPHandler()
+	rec := httptest.NewRecorder()
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusBadRequest == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusBadRequest))
+}
+
+func (suite *HttpHandlerTestSuite) TestPutHandler_SchemaValidationError() {
+	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", []byte(`[{"thing": {"id": "http://api.ft.com/things/2384fa7a-d514-3d6a-a0ea-3a711f66d0d8", "predicte": "about"}}]`))
+	request.Header.Add("X-Request-Id", suite.tid)
+	handler := suite.newHTTPHandler()
+	var err error
+	handler.apiSpec, err = loadAPISpec("openapi.json")
+	assert.NoError(suite.T(), err, "Unexpected error")
+	rec := httptest.NewRecorder()
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusBadRequest == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusBadRequest))
+	assert.JSONEq(suite.T(), message("Invalid annotation request: body[0].thing.predicte is not a supported field"), rec.Body.String(), "Wrong body")
+	suite.annotationsService.AssertNotCalled(suite.T(), "Write", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (suite *HttpHandlerTestSuite) TestPutHandler_UnknownFieldWithoutSchema() {
+	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", []byte(`[{"thing": {"id": "http://api.ft.com/things/2384fa7a-d514-3d6a-a0ea-3a711f66d0d8", "predicte": "about"}}]`))
+	request.Header.Add("X-Request-Id", suite.tid)
 	rec := httptest.NewRecorder()
-	router(&handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
 	assert.True(suite.T(), http.StatusBadRequest == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusBadRequest))
+	var body struct {
+		Message string `json:"message"`
+	}
+	suite.Require().NoError(json.NewDecoder(rec.Body).Decode(&body), "The error should be valid JSON, even with quotes in the message")
+	assert.Contains(suite.T(), body.Message, `unknown field "predicte"`)
+	suite.annotationsService.AssertNotCalled(suite.T(), "Write", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 }
 
 func (suite *HttpHandlerTestSuite) TestPutHandler_NotJson() {
 	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "text/html", suite.body)
-	handler := httpHandler{suite.annotationsService, suite.forwarder, suite.originMap, suite.lifecycleMap, suite.messageType, suite.log}
+	handler := suite.newHTTPHandler()
 	rec := httptest.NewRecorder()
-	router(&handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
 	assert.True(suite.T(), http.StatusBadRequest == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusBadRequest))
 }
 
@@ -107,9 +358,9 @@ func (suite *HttpHandlerTestSuite) TestPutHandler_WriteFailed() {
 	suite.annotationsService.On("Write", knownUUID, annotationLifecycle, platformVersion, suite.tid, suite.annotations).Return(errors.New("Write failed"))
 	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
 	request.Header.Add("X-Request-Id", suite.tid)
-	handler := httpHandler{suite.annotationsService, suite.forwarder, suite.originMap, suite.lifecycleMap, suite.messageType, suite.log}
+	handler := suite.newHTTPHandler()
 	rec := httptest.NewRecorder()
-	router(&handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
 	assert.True(suite.T(), http.StatusServiceUnavailable == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusServiceUnavailable))
 }
 
@@ -117,9 +368,9 @@ func (suite *HttpHandlerTestSuite) TestPutHandler_InvalidPredicate() {
 	suite.annotationsService.On("Write", knownUUID, annotationLifecycle, platformVersion, suite.tid, suite.annotations).Return(annotations.UnsupportedPredicateErr)
 	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
 	request.Header.Add("X-Request-Id", suite.tid)
-	handler := httpHandler{suite.annotationsService, suite.forwarder, suite.originMap, suite.lifecycleMap, suite.messageType, suite.log}
+	handler := suite.newHTTPHandler()
 	rec := httptest.NewRecorder()
-	router(&handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
 	assert.True(suite.T(), http.StatusBadRequest == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusBadRequest))
 }
 
@@ -128,53 +379,204 @@ func (suite *HttpHandlerTestSuite) TestPutHandler_ForwardingFailed() {
 	suite.forwarder.On("SendMessage", suite.tid, "http://cmdb.ft.com/systems/methode-web-pub", platformVersion, knownUUID, suite.annotations).Return(errors.New("forwarding failed"))
 	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
 	request.Header.Add("X-Request-Id", suite.tid)
-	handler := httpHandler{suite.annotationsService, suite.forwarder, suite.originMap, suite.lifecycleMap, suite.messageType, suite.log}
+	handler := suite.newHTTPHandler()
 	rec := httptest.NewRecorder()
-	router(&handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
 	assert.True(suite.T(), http.StatusInternalServerError == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusInternalServerError))
 	suite.forwarder.AssertExpectations(suite.T())
 }
 
 func (suite *HttpHandlerTestSuite) TestGetHandler_Success() {
-	suite.annotationsService.On("Read", knownUUID, mock.Anything, annotationLifecycle).Return(suite.annotations, true, nil)
+	suite.annotationsService.On("Read", knownUUID, mock.Anything, annotationLifecycle, annotations.ReadFilter{}).Return(suite.annotations, true, nil)
 	request := newRequest("GET", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", nil)
 	rec := httptest.NewRecorder()
-	router(&httpHandler{suite.annotationsService, suite.forwarder, suite.originMap, suite.lifecycleMap, suite.messageType, suite.log}, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
 	assert.True(suite.T(), http.StatusOK == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusOK))
 	expectedResponse, err := json.Marshal(suite.annotations)
 	assert.NoError(suite.T(), err, "")
 	assert.JSONEq(suite.T(), string(expectedResponse), rec.Body.String(), "Wrong body")
 }
 
+func (suite *HttpHandlerTestSuite) TestGetHandler_MostSpecificTypes() {
+	suite.annotationsService.On("Read", knownUUID, mock.Anything, annotationLifecycle, annotations.ReadFilter{}).Return(suite.annotations, true, nil)
+	request := newRequest("GET", fmt.Sprintf("/content/%s/annotations/%s?types=mostSpecific", knownUUID, annotationLifecycle), "application/json", nil)
+	rec := httptest.NewRecorder()
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusOK == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusOK))
+	expectedResponse, err := json.Marshal(annotations.WithMostSpecificType(suite.annotations))
+	assert.NoError(suite.T(), err, "")
+	assert.JSONEq(suite.T(), string(expectedResponse), rec.Body.String(), "Wrong body")
+}
+
+func (suite *HttpHandlerTestSuite) TestGetHandler_InvalidTypesFormat() {
+	request := newRequest("GET", fmt.Sprintf("/content/%s/annotations/%s?types=all", knownUUID, annotationLifecycle), "application/json", nil)
+	rec := httptest.NewRecorder()
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusBadRequest == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusBadRequest))
+	suite.annotationsService.AssertNotCalled(suite.T(), "Read", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (suite *HttpHandlerTestSuite) TestGetHandler_Filters() {
+	minRelevance := 0.5
+	expectedFilter := annotations.ReadFilter{
+		Predicates:     []string{"mentions", "about"},
+		Types:          []string{"http://www.ft.com/ontology/Topic"},
+		MinRelevance:   &minRelevance,
+		AnnotatedAfter: time.Date(2016, 1, 20, 19, 43, 47, 0, time.UTC),
+	}
+	suite.annotationsService.On("Read", knownUUID, mock.Anything, annotationLifecycle, expectedFilter).Return(suite.annotations, true, nil)
+	request := newRequest("GET", fmt.Sprintf("/content/%s/annotations/%s?predicate=mentions&predicate=about&type=http://www.ft.com/ontology/Topic&minRelevance=0.5&annotatedAfter=2016-01-20T19:43:47Z", knownUUID, annotationLifecycle), "application/json", nil)
+	rec := httptest.NewRecorder()
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusOK == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusOK))
+	suite.annotationsService.AssertExpectations(suite.T())
+}
+
+func (suite *HttpHandlerTestSuite) TestGetHandler_InvalidFilter() {
+	for _, query := range []string{"minRelevance=high", "minConfidence=0.5x", "annotatedBefore=yesterday"} {
+		request := newRequest("GET", fmt.Sprintf("/content/%s/annotations/%s?%s", knownUUID, annotationLifecycle, query), "application/json", nil)
+		rec := httptest.NewRecorder()
+		router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+		assert.True(suite.T(), http.StatusBadRequest == rec.Code, fmt.Sprintf("Wrong response code for %s, was %d, should be %d", query, rec.Code, http.StatusBadRequest))
+	}
+}
+
+func (suite *HttpHandlerTestSuite) TestGetHandler_FilterValidationError() {
+	filter := annotations.ReadFilter{Predicates: []string{"hasAFakePredicate"}}
+	suite.annotationsService.On("Read", knownUUID, mock.Anything, annotationLifecycle, filter).Return(nil, false, annotations.ValidationError{Msg: "unsupported predicate hasAFakePredicate"})
+	request := newRequest("GET", fmt.Sprintf("/content/%s/annotations/%s?predicate=hasAFakePredicate", knownUUID, annotationLifecycle), "application/json", nil)
+	rec := httptest.NewRecorder()
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusBadRequest == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusBadRequest))
+}
+
+func (suite *HttpHandlerTestSuite) TestGetHandler_JSONLD() {
+	suite.annotationsService.On("Read", knownUUID, mock.Anything, annotationLifecycle, annotations.ReadFilter{}).Return(suite.annotations, true, nil)
+	request := newRequest("GET", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", nil)
+	request.Header.Set("Accept", "application/ld+json")
+	rec := httptest.NewRecorder()
+	handler := suite.newHTTPHandler()
+	handler.jsonLDContextURL = "https://example.com/__context.jsonld"
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusOK == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusOK))
+	assert.Equal(suite.T(), "application/ld+json; charset=UTF-8", rec.Header().Get("Content-Type"))
+	assert.Equal(suite.T(), "Accept", rec.Header().Get("Vary"))
+
+	var doc map[string]interface{}
+	assert.NoError(suite.T(), json.Unmarshal(rec.Body.Bytes(), &doc))
+	assert.Equal(suite.T(), "https://example.com/__context.jsonld", doc["@context"])
+	assert.Equal(suite.T(), "http://www.ft.com/thing/12345", doc["@id"])
+	assert.Len(suite.T(), doc["mentions"], len(suite.annotations))
+}
+
+func (suite *HttpHandlerTestSuite) TestGetHandler_CacheControl() {
+	suite.annotationsService.On("Read", knownUUID, mock.Anything, annotationLifecycle, annotations.ReadFilter{}).Return(suite.annotations, true, nil)
+	request := newRequest("GET", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", nil)
+	rec := httptest.NewRecorder()
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.Empty(suite.T(), rec.Header().Get("Cache-Control"), "Cache-Control should only be set when reads are cached")
+
+	handler := suite.newHTTPHandler()
+	handler.cacheMaxAge = 30 * time.Second
+	rec = httptest.NewRecorder()
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusOK == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusOK))
+	assert.Equal(suite.T(), "max-age=30", rec.Header().Get("Cache-Control"))
+}
+
+func (suite *HttpHandlerTestSuite) TestGetJSONLDContext() {
+	request := newRequest("GET", "/__context.jsonld", "application/json", nil)
+	rec := httptest.NewRecorder()
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusOK == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusOK))
+	assert.Contains(suite.T(), rec.Body.String(), `"@context"`)
+}
+
 func (suite *HttpHandlerTestSuite) TestGetHandler_NotFound() {
-	suite.annotationsService.On("Read", knownUUID, mock.Anything, annotationLifecycle).Return(nil, false, nil)
+	suite.annotationsService.On("Read", knownUUID, mock.Anything, annotationLifecycle, annotations.ReadFilter{}).Return(nil, false, nil)
 	request := newRequest("GET", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", nil)
 	rec := httptest.NewRecorder()
-	router(&httpHandler{suite.annotationsService, suite.forwarder, suite.originMap, suite.lifecycleMap, suite.messageType, suite.log}, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
 	assert.True(suite.T(), http.StatusNotFound == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusNotFound))
 }
 
 func (suite *HttpHandlerTestSuite) TestGetHandler_ReadError() {
-	suite.annotationsService.On("Read", knownUUID, mock.Anything, annotationLifecycle).Return(nil, false, errors.New("Read error"))
+	suite.annotationsService.On("Read", knownUUID, mock.Anything, annotationLifecycle, annotations.ReadFilter{}).Return(nil, false, errors.New("Read error"))
 	request := newRequest("GET", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", nil)
 	rec := httptest.NewRecorder()
-	router(&httpHandler{suite.annotationsService, suite.forwarder, suite.originMap, suite.lifecycleMap, suite.messageType, suite.log}, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
 	assert.True(suite.T(), http.StatusServiceUnavailable == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusServiceUnavailable))
 }
 
+func (suite *HttpHandlerTestSuite) TestGetHandler_BreakerOpen() {
+	suite.annotationsService.On("Read", knownUUID, mock.Anything, annotationLifecycle, annotations.ReadFilter{}).Return(nil, false, &breaker.OpenError{RetryAfter: 1500 * time.Millisecond})
+	request := newRequest("GET", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", nil)
+	rec := httptest.NewRecorder()
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, rec.Code, "Wrong response code")
+	assert.Equal(suite.T(), "2", rec.Header().Get("Retry-After"))
+}
+
+func (suite *HttpHandlerTestSuite) TestPutHandler_BreakerOpen() {
+	suite.annotationsService.On("Write", knownUUID, annotationLifecycle, platformVersion, mock.Anything, mock.Anything).Return(&breaker.OpenError{RetryAfter: 30 * time.Second})
+	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
+	rec := httptest.NewRecorder()
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, rec.Code, "Wrong response code")
+	assert.Equal(suite.T(), "30", rec.Header().Get("Retry-After"))
+	suite.forwarder.AssertNotCalled(suite.T(), "SendMessage", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (suite *HttpHandlerTestSuite) TestHandlers_Maintenance() {
+	suite.annotationsService.On("Read", knownUUID, mock.Anything, annotationLifecycle, annotations.ReadFilter{}).Return(suite.annotations, true, nil)
+	suite.annotationsService.On("Count", annotationLifecycle, platformVersion).Return(2, nil)
+	handler := suite.newHTTPHandler()
+	handler.maintenance = newMaintenanceMode()
+	handler.maintenance.set(true, "Neo4j migration")
+
+	rec := httptest.NewRecorder()
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body))
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, rec.Code, "Wrong response code")
+	assert.Contains(suite.T(), rec.Body.String(), "maintenance mode")
+	assert.Contains(suite.T(), rec.Body.String(), "Neo4j migration")
+
+	rec = httptest.NewRecorder()
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, newRequest("DELETE", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", nil))
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, rec.Code, "Wrong response code")
+	suite.annotationsService.AssertNotCalled(suite.T(), "Write", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	suite.annotationsService.AssertNotCalled(suite.T(), "Delete", mock.Anything, mock.Anything, mock.Anything)
+
+	rec = httptest.NewRecorder()
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, newRequest("GET", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", nil))
+	assert.Equal(suite.T(), http.StatusOK, rec.Code, "Reads should keep working in maintenance mode")
+
+	rec = httptest.NewRecorder()
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, newRequest("GET", fmt.Sprintf("/content/annotations/%s/__count", annotationLifecycle), "application/json", nil))
+	assert.Equal(suite.T(), http.StatusOK, rec.Code, "Counts should keep working in maintenance mode")
+}
+
 func (suite *HttpHandlerTestSuite) TestDeleteHandler_Success() {
 	suite.annotationsService.On("Delete", knownUUID, mock.Anything, annotationLifecycle).Return(true, nil)
 	request := newRequest("DELETE", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", nil)
 	rec := httptest.NewRecorder()
-	router(&httpHandler{suite.annotationsService, suite.forwarder, suite.originMap, suite.lifecycleMap, suite.messageType, suite.log}, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
 	assert.True(suite.T(), http.StatusNoContent == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusNoContent))
 }
 
+func (suite *HttpHandlerTestSuite) TestDeleteHandler_InvalidOriginSystem() {
+	request := newRequest("DELETE", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", nil)
+	request.Header.Add("X-Origin-System-Id", "http://cmdb.ft.com/systems/pac")
+	rec := httptest.NewRecorder()
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code, "Wrong response code")
+	suite.annotationsService.AssertNotCalled(suite.T(), "Delete", mock.Anything, mock.Anything, mock.Anything)
+}
+
 func (suite *HttpHandlerTestSuite) TestDeleteHandler_NotFound() {
 	suite.annotationsService.On("Delete", knownUUID, mock.Anything, annotationLifecycle).Return(false, nil)
 	request := newRequest("DELETE", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", nil)
 	rec := httptest.NewRecorder()
-	router(&httpHandler{suite.annotationsService, suite.forwarder, suite.originMap, suite.lifecycleMap, suite.messageType, suite.log}, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
 	assert.True(suite.T(), http.StatusNotFound == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusNotFound))
 }
 
@@ -182,15 +584,42 @@ func (suite *HttpHandlerTestSuite) TestDeleteHandler_DeleteError() {
 	suite.annotationsService.On("Delete", knownUUID, mock.Anything, annotationLifecycle).Return(false, errors.New("Delete error"))
 	request := newRequest("DELETE", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", nil)
 	rec := httptest.NewRecorder()
-	router(&httpHandler{suite.annotationsService, suite.forwarder, suite.originMap, suite.lifecycleMap, suite.messageType, suite.log}, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
 	assert.True(suite.T(), http.StatusServiceUnavailable == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusServiceUnavailable))
 }
 
+func (suite *HttpHandlerTestSuite) TestGetLifecycles() {
+	suite.originMap["http://cmdb.ft.com/systems/methode-web-pub-v2"] = annotationLifecycle
+	handler := suite.newHTTPHandler()
+	handler.consuming = true
+	rec := httptest.NewRecorder()
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, newRequest("GET", "/__lifecycles", "", nil))
+	assert.Equal(suite.T(), http.StatusOK, rec.Code, "Wrong response code")
+
+	var body struct {
+		MessageType string          `json:"messageType"`
+		Forwarding  bool            `json:"forwarding"`
+		Consuming   bool            `json:"consuming"`
+		Lifecycles  []lifecycleInfo `json:"lifecycles"`
+	}
+	assert.NoError(suite.T(), json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(suite.T(), "Annotations", body.MessageType)
+	assert.True(suite.T(), body.Forwarding)
+	assert.True(suite.T(), body.Consuming)
+
+	predicates := annotations.PredicateNames()
+	assert.Equal(suite.T(), []lifecycleInfo{
+		{Lifecycle: "annotations-next-video", PlatformVersion: "next-video", OriginSystems: []string{"http://cmdb.ft.com/systems/next-video-editor"}, Predicates: predicates},
+		{Lifecycle: "annotations-pac", PlatformVersion: "pac", OriginSystems: []string{"http://cmdb.ft.com/systems/pac"}, Predicates: predicates},
+		{Lifecycle: "annotations-v1", PlatformVersion: "v1", OriginSystems: []string{"http://cmdb.ft.com/systems/methode-web-pub", "http://cmdb.ft.com/systems/methode-web-pub-v2"}, Predicates: predicates},
+	}, body.Lifecycles)
+}
+
 func (suite *HttpHandlerTestSuite) TestCount_Success() {
 	suite.annotationsService.On("Count", annotationLifecycle, platformVersion).Return(10, nil)
 	request := newRequest("GET", fmt.Sprintf("/content/annotations/%s/__count", annotationLifecycle), "application/json", nil)
 	rec := httptest.NewRecorder()
-	router(&httpHandler{suite.annotationsService, suite.forwarder, suite.originMap, suite.lifecycleMap, suite.messageType, suite.log}, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
 	assert.True(suite.T(), http.StatusOK == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusOK))
 }
 
@@ -198,11 +627,378 @@ func (suite *HttpHandlerTestSuite) TestCount_CountError() {
 	suite.annotationsService.On("Count", annotationLifecycle, platformVersion).Return(0, errors.New("Count error"))
 	request := newRequest("GET", fmt.Sprintf("/content/annotations/%s/__count", annotationLifecycle), "application/json", nil)
 	rec := httptest.NewRecorder()
-	handler := httpHandler{suite.annotationsService, suite.forwarder, suite.originMap, suite.lifecycleMap, suite.messageType, suite.log}
-	router(&handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	handler := suite.newHTTPHandler()
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
 	assert.True(suite.T(), http.StatusServiceUnavailable == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusServiceUnavailable))
 }
 
+func (suite *HttpHandlerTestSuite) TestExport_Success() {
+	contents := []annotations.ContentAnnotations{{ContentUUID: knownUUID, Annotations: annotations.Annotations{{Thing: annotations.Thing{ID: "http://api.ft.com/things/2384fa7a-d514-3d6a-a0ea-3a711f66d0d8", Predicate: "ABOUT"}}}}}
+	suite.annotationsService.On("Export", annotationLifecycle, mock.Anything).Return(contents, nil)
+	request := newRequest("GET", fmt.Sprintf("/content/annotations/%s/__export", annotationLifecycle), "application/json", nil)
+	request.Header.Set("Accept", "text/turtle")
+	rec := httptest.NewRecorder()
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusOK == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusOK))
+	assert.Equal(suite.T(), "text/turtle; charset=UTF-8", rec.Header().Get("Content-Type"))
+	assert.Contains(suite.T(), rec.Body.String(), "<http://www.ft.com/thing/12345> ftann:about <http://api.ft.com/things/2384fa7a-d514-3d6a-a0ea-3a711f66d0d8> .")
+}
+
+func (suite *HttpHandlerTestSuite) TestExport_FailsBeforeStreaming() {
+	suite.annotationsService.On("Export", annotationLifecycle, mock.Anything).Return(nil, errors.New("Export error"))
+	request := newRequest("GET", fmt.Sprintf("/content/annotations/%s/__export", annotationLifecycle), "application/json", nil)
+	rec := httptest.NewRecorder()
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusServiceUnavailable == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusServiceUnavailable))
+	assert.Equal(suite.T(), "application/json; charset=UTF-8", rec.Header().Get("Content-Type"))
+}
+
+func (suite *HttpHandlerTestSuite) TestExport_FailsWhileStreaming() {
+	contents := []annotations.ContentAnnotations{{ContentUUID: knownUUID}}
+	suite.annotationsService.On("Export", annotationLifecycle, mock.Anything).Return(contents, errors.New("Export error"))
+	request := newRequest("GET", fmt.Sprintf("/content/annotations/%s/__export", annotationLifecycle), "application/json", nil)
+	rec := httptest.NewRecorder()
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusOK == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusOK))
+	assert.Equal(suite.T(), "application/n-triples; charset=UTF-8", rec.Header().Get("Content-Type"))
+	assert.Equal(suite.T(), "# export incomplete: Export error\n", rec.Body.String())
+}
+
+func (suite *HttpHandlerTestSuite) TestExport_NotAcceptable() {
+	request := newRequest("GET", fmt.Sprintf("/content/annotations/%s/__export", annotationLifecycle), "application/json", nil)
+	request.Header.Set("Accept", "application/rdf+xml")
+	rec := httptest.NewRecorder()
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusNotAcceptable == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusNotAcceptable))
+}
+
+// newTestGuard returns a guard granting the client with the API key "methode-key" writes and deletes in
+// annotationLifecycle, and the client with the API key "ops-key" the admin endpoints. Reads are open.
+func newTestGuard() (*auth.Guard, error) {
+	// the SHA-256 digest of the API key "methode-key"
+	config := auth.Config{Clients: map[string]auth.ClientConfig{
+		"methode": {
+			APIKeySHA256: []string{"688a5f194281dd77cf1c4cafcaa455ad9a35dbe8a2b169918bb28deedab99074"},
+			Grants:       []auth.Grant{{Lifecycles: []string{annotationLifecycle}, Methods: []string{"PUT", "DELETE"}}},
+		},
+		// the SHA-256 digest of the API key "ops-key"
+		"ops": {
+			APIKeySHA256: []string{"2c69bc9111c27110a9b9a7974ba3f8ac0c053c16b23a0738115ee829fbc4d57b"},
+			Grants:       []auth.Grant{{Admin: true}},
+		},
+	}}
+	return config.Guard(true)
+}
+
+func (suite *HttpHandlerTestSuite) newGuardedHTTPHandler() *httpHandler {
+	guard, err := newTestGuard()
+	suite.Require().NoError(err)
+
+	handler := suite.newHTTPHandler()
+	handler.guard = guard
+	return handler
+}
+
+func (suite *HttpHandlerTestSuite) TestAuth_GrantedClient() {
+	suite.annotationsService.On("Write", knownUUID, annotationLifecycle, platformVersion, suite.tid, suite.annotations).Return(nil)
+	suite.forwarder.On("SendMessage", suite.tid, "http://cmdb.ft.com/systems/methode-web-pub", platformVersion, knownUUID, suite.annotations).Return(nil).Once()
+	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
+	request.Header.Add("X-Request-Id", suite.tid)
+	request.Header.Set(auth.APIKeyHeader, "methode-key")
+	rec := httptest.NewRecorder()
+	router(suite.newGuardedHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusCreated == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusCreated))
+}
+
+func (suite *HttpHandlerTestSuite) TestAuth_NoCredentials() {
+	request := newRequest("DELETE", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", nil)
+	rec := httptest.NewRecorder()
+	router(suite.newGuardedHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusUnauthorized == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusUnauthorized))
+	assert.Contains(suite.T(), rec.Header().Get("WWW-Authenticate"), auth.HMACScheme)
+	suite.annotationsService.AssertNotCalled(suite.T(), "Delete", mock.Anything, mock.Anything)
+}
+
+func (suite *HttpHandlerTestSuite) TestAuth_InvalidCredentials() {
+	request := newRequest("DELETE", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", nil)
+	request.Header.Set(auth.APIKeyHeader, "guess")
+	rec := httptest.NewRecorder()
+	router(suite.newGuardedHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusUnauthorized == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusUnauthorized))
+	assert.JSONEq(suite.T(), message("unknown API key"), rec.Body.String(), "Wrong body")
+}
+
+func (suite *HttpHandlerTestSuite) TestAuth_LifecycleNotGranted() {
+	request := newRequest("DELETE", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, "annotations-pac"), "application/json", nil)
+	request.Header.Set(auth.APIKeyHeader, "methode-key")
+	rec := httptest.NewRecorder()
+	router(suite.newGuardedHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusForbidden == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusForbidden))
+	assert.JSONEq(suite.T(), message("client methode may not DELETE annotations in lifecycle annotations-pac"), rec.Body.String(), "Wrong body")
+}
+
+func (suite *HttpHandlerTestSuite) TestAuth_SignedBodyTooLarge() {
+	config := auth.Config{
+		Clients: map[string]auth.ClientConfig{
+			"methode": {
+				HMACKeys: map[string]string{"methode-1": "methode-secret"},
+				Grants:   []auth.Grant{{Lifecycles: []string{annotationLifecycle}, Methods: []string{"PUT"}}},
+			},
+		},
+		MaxSignedBodyBytes: int64(len(suite.body) - 1),
+	}
+	guard, err := config.Guard(true)
+	suite.Require().NoError(err)
+	handler := suite.newHTTPHandler()
+	handler.guard = guard
+
+	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
+	request.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	signature, err := auth.SignRequest(request, []byte("methode-secret"))
+	suite.Require().NoError(err)
+	request.Header.Set("Authorization", fmt.Sprintf("%s keyId=methode-1,signature=%s", auth.HMACScheme, base64.StdEncoding.EncodeToString(signature)))
+	rec := httptest.NewRecorder()
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.Equal(suite.T(), http.StatusRequestEntityTooLarge, rec.Code)
+	suite.annotationsService.AssertNotCalled(suite.T(), "Write", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// adminRoutes are the routes without a lifecycle that need a client granted the admin endpoints
+var adminRoutes = []struct{ method, url string }{
+	{"GET", "/__lifecycles"},
+	{"GET", "/__changes/stream"},
+	{"GET", "/__audit/" + knownUUID},
+	{"GET", "/__jobs"},
+	{"GET", "/__jobs/1"},
+	{"POST", "/__reload-config"},
+	{"GET", "/__maintenance"},
+	{"PUT", "/__maintenance"},
+	{"GET", "/__webhooks"},
+	{"GET", "/__webhooks/dead-letters"},
+}
+
+func (suite *HttpHandlerTestSuite) TestAuth_AdminEndpointsNeedCredentials() {
+	handler := router(suite.newGuardedHTTPHandler(), &suite.healthCheckHandler, suite.log)
+	for _, route := range adminRoutes {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, newRequest(route.method, route.url, "application/json", nil))
+		assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code, "Wrong response code to %s %s", route.method, route.url)
+		assert.Contains(suite.T(), rec.Header().Get("WWW-Authenticate"), auth.HMACScheme)
+	}
+}
+
+func (suite *HttpHandlerTestSuite) TestAuth_AdminEndpointsNeedTheAdminGrant() {
+	handler := router(suite.newGuardedHTTPHandler(), &suite.healthCheckHandler, suite.log)
+	for _, route := range adminRoutes {
+		request := newRequest(route.method, route.url, "application/json", nil)
+		request.Header.Set(auth.APIKeyHeader, "methode-key")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, request)
+		assert.Equal(suite.T(), http.StatusForbidden, rec.Code, "Wrong response code to %s %s", route.method, route.url)
+		assert.JSONEq(suite.T(), message("client methode may not use the admin endpoints"), rec.Body.String(), "Wrong body")
+	}
+}
+
+func (suite *HttpHandlerTestSuite) TestAuth_ClientsFollowUpTheirJobs() {
+	suite.annotationsService.On("Write", knownUUID, annotationLifecycle, platformVersion, suite.tid, suite.annotations).Return(nil)
+	suite.forwarder.On("SendMessage", suite.tid, "http://cmdb.ft.com/systems/methode-web-pub", platformVersion, knownUUID, suite.annotations).Return(nil)
+	handler := suite.newGuardedHTTPHandler()
+	handler.jobs = jobs.NewPool(1, 1, time.Hour)
+	r := router(handler, &suite.healthCheckHandler, suite.log)
+
+	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
+	request.Header.Add("X-Request-Id", suite.tid)
+	request.Header.Add("Prefer", "respond-async")
+	request.Header.Set(auth.APIKeyHeader, "methode-key")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, request)
+	suite.Require().Equal(http.StatusAccepted, rec.Code, "Wrong response code")
+	var job jobs.Job
+	suite.Require().NoError(json.Unmarshal(rec.Body.Bytes(), &job))
+	assert.Equal(suite.T(), "methode", job.Attributes["caller"])
+	assert.NoError(suite.T(), handler.jobs.Drain(context.Background()))
+
+	request = newRequest("GET", rec.Header().Get("Location"), "", nil)
+	request.Header.Set(auth.APIKeyHeader, "methode-key")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, request)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code, "The client should be able to follow up the job it submitted")
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, adminRequest("GET", "/__jobs/"+job.ID, nil))
+	assert.Equal(suite.T(), http.StatusOK, rec.Code, "Admins should be able to follow up any job")
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest("GET", "/__jobs/"+job.ID, "", nil))
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code, "Jobs should not be open to reads")
+
+	request = newRequest("GET", "/__jobs", "", nil)
+	request.Header.Set(auth.APIKeyHeader, "methode-key")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, request)
+	assert.Equal(suite.T(), http.StatusForbidden, rec.Code, "Listing the jobs should need the admin grant")
+}
+
+func (suite *HttpHandlerTestSuite) TestAuth_OpenReadsAndAdminEndpoints() {
+	suite.annotationsService.On("Count", annotationLifecycle, platformVersion).Return(10, nil)
+	handler := router(suite.newGuardedHTTPHandler(), &suite.healthCheckHandler, suite.log)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest("GET", fmt.Sprintf("/content/annotations/%s/__count", annotationLifecycle), "application/json", nil))
+	assert.True(suite.T(), http.StatusOK == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusOK))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest("GET", "/__context.jsonld", "application/json", nil))
+	assert.True(suite.T(), http.StatusOK == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusOK))
+
+	for _, url := range []string{"/__build-info", "/__ping", "/metrics"} {
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, newRequest("GET", url, "application/json", nil))
+		assert.NotEqual(suite.T(), http.StatusUnauthorized, rec.Code, "%s should not need credentials", url)
+	}
+}
+
+func (suite *HttpHandlerTestSuite) TestAuditRecords() {
+	dir, err := ioutil.TempDir("", "audit")
+	suite.Require().NoError(err)
+	defer os.RemoveAll(dir)
+	sink, err := audit.NewFileSink(filepath.Join(dir, "audit.jsonl"), 0, 0)
+	suite.Require().NoError(err)
+
+	suite.annotationsService.On("Read", knownUUID, suite.tid, annotationLifecycle, annotations.ReadFilter{}).Return(annotations.Annotations{}, false, nil).Once()
+	suite.annotationsService.On("Read", knownUUID, suite.tid, annotationLifecycle, annotations.ReadFilter{}).Return(suite.annotations, true, nil)
+	suite.annotationsService.On("Write", knownUUID, annotationLifecycle, platformVersion, suite.tid, suite.annotations).Return(nil)
+	suite.annotationsService.On("Delete", knownUUID, suite.tid, annotationLifecycle).Return(true, nil)
+	suite.forwarder.On("SendMessage", suite.tid, "http://cmdb.ft.com/systems/methode-web-pub", platformVersion, knownUUID, suite.annotations).Return(nil)
+	handler := suite.newGuardedHTTPHandler()
+	handler.audit = audit.New(sink, suite.log)
+	defer handler.audit.Close()
+	r := router(handler, &suite.healthCheckHandler, suite.log)
+
+	for _, method := range []string{"PUT", "DELETE"} {
+		request := newRequest(method, fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
+		request.Header.Add("X-Request-Id", suite.tid)
+		request.Header.Set(auth.APIKeyHeader, "methode-key")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, request)
+		assert.True(suite.T(), rec.Code < 300, "Wrong response code to %s, was %d", method, rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, adminRequest("GET", fmt.Sprintf("/__audit/%s?annotationLifecycle=%s", knownUUID, annotationLifecycle), nil))
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	var body struct {
+		Records []audit.Record `json:"records"`
+	}
+	suite.Require().NoError(json.NewDecoder(rec.Body).Decode(&body))
+	if assert.Len(suite.T(), body.Records, 2) {
+		written := body.Records[0]
+		assert.Equal(suite.T(), audit.Write, written.Action)
+		assert.Equal(suite.T(), audit.HTTP, written.Source)
+		assert.Equal(suite.T(), "methode", written.Caller, "The authenticated client should be recorded")
+		assert.Equal(suite.T(), "http://cmdb.ft.com/systems/methode-web-pub", written.OriginSystem)
+		assert.Equal(suite.T(), annotations.Annotations{}, written.Before)
+		assert.Equal(suite.T(), suite.annotations, written.After)
+
+		deleted := body.Records[1]
+		assert.Equal(suite.T(), audit.Delete, deleted.Action)
+		assert.Equal(suite.T(), suite.annotations, deleted.Before)
+		assert.Equal(suite.T(), annotations.Annotations{}, deleted.After)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, adminRequest("GET", fmt.Sprintf("/__audit/%s?from=%s", knownUUID, time.Now().Add(time.Hour).UTC().Format(time.RFC3339)), nil))
+	assert.JSONEq(suite.T(), `{"records": []}`, rec.Body.String(), "Records before from should be left out")
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, adminRequest("GET", fmt.Sprintf("/__audit/%s?to=yesterday", knownUUID), nil))
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+
+	handler.audit = nil
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, adminRequest("GET", fmt.Sprintf("/__audit/%s", knownUUID), nil))
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, rec.Code)
+}
+
+func (suite *HttpHandlerTestSuite) TestAuditRecords_NeedTheAdminGrant() {
+	handler := suite.newGuardedHTTPHandler()
+	handler.audit = audit.New(&recordingAuditSink{}, suite.log)
+	r := router(handler, &suite.healthCheckHandler, suite.log)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest("GET", fmt.Sprintf("/__audit/%s", knownUUID), "", nil))
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code, "Audit records should need credentials, even with open reads")
+
+	request := newRequest("GET", fmt.Sprintf("/__audit/%s", knownUUID), "", nil)
+	request.Header.Set(auth.APIKeyHeader, "methode-key")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, request)
+	assert.Equal(suite.T(), http.StatusForbidden, rec.Code, "Audit records should need the admin grant")
+}
+
+func (suite *HttpHandlerTestSuite) TestPutHandler_RateLimited() {
+	suite.annotationsService.On("Write", knownUUID, annotationLifecycle, platformVersion, suite.tid, suite.annotations).Return(nil)
+	suite.forwarder.On("SendMessage", suite.tid, "http://cmdb.ft.com/systems/methode-web-pub", platformVersion, knownUUID, suite.annotations).Return(nil)
+	handler := suite.newHTTPHandler()
+	handler.limits = limits.New(map[string]limits.Rate{annotationLifecycle: {PerSecond: 0.1, Burst: 1}}, 0, 0)
+	r := router(handler, &suite.healthCheckHandler, suite.log)
+
+	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
+	request.Header.Add("X-Request-Id", suite.tid)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusCreated == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusCreated))
+
+	request = newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
+	request.Header.Add("X-Request-Id", suite.tid)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusTooManyRequests == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusTooManyRequests))
+	assert.Equal(suite.T(), "10", rec.Header().Get("Retry-After"))
+	suite.annotationsService.AssertNumberOfCalls(suite.T(), "Write", 1)
+}
+
+func (suite *HttpHandlerTestSuite) TestPutHandler_BodyTooLarge() {
+	handler := suite.newHTTPHandler()
+	handler.limits = limits.New(nil, 0, int64(len(suite.body)-1))
+	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
+	rec := httptest.NewRecorder()
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusRequestEntityTooLarge == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusRequestEntityTooLarge))
+	suite.annotationsService.AssertNotCalled(suite.T(), "Write", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (suite *HttpHandlerTestSuite) TestPutHandler_TooManyAnnotations() {
+	handler := suite.newHTTPHandler()
+	handler.limits = limits.New(nil, len(suite.annotations)-1, 0)
+	request := newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body)
+	rec := httptest.NewRecorder()
+	router(handler, &suite.healthCheckHandler, suite.log).ServeHTTP(rec, request)
+	assert.True(suite.T(), http.StatusRequestEntityTooLarge == rec.Code, fmt.Sprintf("Wrong response code, was %d, should be %d", rec.Code, http.StatusRequestEntityTooLarge))
+	assert.JSONEq(suite.T(), message(fmt.Sprintf("%d annotations were sent, more than the maximum of %d", len(suite.annotations), len(suite.annotations)-1)), rec.Body.String(), "Wrong body")
+	suite.annotationsService.AssertNotCalled(suite.T(), "Write", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (suite *HttpHandlerTestSuite) TestMetrics() {
+	handler := suite.newHTTPHandler()
+	handler.limits = limits.New(nil, len(suite.annotations)-1, 0)
+	handler.metrics = stats.New()
+	r := router(handler, &suite.healthCheckHandler, suite.log)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest("PUT", fmt.Sprintf("/content/%s/annotations/%s", knownUUID, annotationLifecycle), "application/json", suite.body))
+	assert.Equal(suite.T(), http.StatusRequestEntityTooLarge, rec.Code)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest("GET", "/metrics", "", nil))
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.Contains(suite.T(), rec.Body.String(), `annotations_rw_validation_failures_total{reason="too_many_annotations"} 1`)
+
+	rec = httptest.NewRecorder()
+	router(suite.newHTTPHandler(), &suite.healthCheckHandler, suite.log).ServeHTTP(rec, newRequest("GET", "/metrics", "", nil))
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, rec.Code)
+}
+
 func newRequest(method, url, contentType string, body []byte) *http.Request {
 	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
 	if err != nil {
@@ -212,6 +1008,31 @@ func newRequest(method, url, contentType string, body []byte) *http.Request {
 	return req
 }
 
+// adminRequest returns a request carrying the API key of the client granted the admin endpoints by newGuardedHTTPHandler
+func adminRequest(method, url string, body []byte) *http.Request {
+	req := newRequest(method, url, "application/json", body)
+	req.Header.Set(auth.APIKeyHeader, "ops-key")
+	return req
+}
+
 func message(errMsg string) string {
 	return fmt.Sprintf("{\"message\": \"%s\"}\n", errMsg)
 }
+
+func TestPrefersAsync(t *testing.T) {
+	tests := []struct {
+		prefer []string
+		async  bool
+	}{
+		{nil, false},
+		{[]string{"respond-async"}, true},
+		{[]string{"return=minimal, Respond-Async; x=1"}, true},
+		{[]string{"return=minimal", "respond-async"}, true},
+		{[]string{"respond-asynchronously"}, false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("PUT", "/", nil)
+		r.Header["Prefer"] = test.prefer
+		assert.Equal(t, test.async, prefersAsync(r), "%v", test.prefer)
+	}
+}
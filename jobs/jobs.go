@@ -0,0 +1,230 @@
+// Package jobs runs work asynchronously on a bounded pool of workers and keeps track of its progress: the status of each
+// job, the timings and errors of its stages, and when it finished. Finished jobs are forgotten after a retention period.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Status of a job
+type Status string
+
+const (
+	Queued    Status = "queued"
+	Running   Status = "running"
+	Succeeded Status = "succeeded"
+	Failed    Status = "failed"
+)
+
+// ErrQueueFull is returned when a job is submitted while every worker is busy and the queue is full
+var ErrQueueFull = errors.New("job queue is full")
+
+// ErrClosed is returned when a job is submitted after the pool started draining
+var ErrClosed = errors.New("job pool is shutting down")
+
+// Stage is a step of a job, such as writing to Neo4j
+type Stage struct {
+	Name       string    `json:"name"`
+	Status     Status    `json:"status"`
+	Started    time.Time `json:"started"`
+	DurationMs int64     `json:"durationMs"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Job is a snapshot of the state of a submitted job
+type Job struct {
+	ID         string            `json:"id"`
+	Status     Status            `json:"status"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Created    time.Time         `json:"created"`
+	Started    *time.Time        `json:"started,omitempty"`
+	Finished   *time.Time        `json:"finished,omitempty"`
+	Stages     []Stage           `json:"stages"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// Run is handed to the work of a job to record its stages
+type Run struct {
+	pool *Pool
+	job  *Job
+}
+
+// Stage runs f as a stage of the job, recording its timing and error
+func (r *Run) Stage(name string, f func() error) error {
+	p := r.pool
+	p.mu.Lock()
+	r.job.Stages = append(r.job.Stages, Stage{Name: name, Status: Running, Started: p.now()})
+	idx := len(r.job.Stages) - 1
+	p.mu.Unlock()
+
+	err := f()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stage := &r.job.Stages[idx]
+	stage.DurationMs = p.now().Sub(stage.Started).Milliseconds()
+	stage.Status = Succeeded
+	if err != nil {
+		stage.Status = Failed
+		stage.Error = err.Error()
+	}
+	return err
+}
+
+type task struct {
+	job  *Job
+	work func(*Run) error
+}
+
+// Pool runs the submitted jobs on a fixed number of workers, queueing at most queueSize jobs waiting for one
+type Pool struct {
+	mu        sync.Mutex
+	jobs      map[string]*Job
+	queue     chan task
+	closed    bool
+	workers   sync.WaitGroup
+	retention time.Duration
+	now       func() time.Time
+}
+
+// NewPool starts the workers of a pool. Finished jobs are kept for the retention period.
+func NewPool(workers int, queueSize int, retention time.Duration) *Pool {
+	p := &Pool{
+		jobs:      map[string]*Job{},
+		queue:     make(chan task, queueSize),
+		retention: retention,
+		now:       time.Now,
+	}
+	for i := 0; i < workers; i++ {
+		p.workers.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+// Submit queues work as a new job, described by its attributes, and returns the job as queued
+func (p *Pool) Submit(attributes map[string]string, work func(*Run) error) (Job, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return Job{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return Job{}, ErrClosed
+	}
+	p.expire()
+
+	job := &Job{ID: id.String(), Status: Queued, Attributes: attributes, Created: p.now(), Stages: []Stage{}}
+	select {
+	case p.queue <- task{job: job, work: work}:
+	default:
+		return Job{}, ErrQueueFull
+	}
+	p.jobs[job.ID] = job
+	return job.snapshot(), nil
+}
+
+// Get returns the job with the id, unless it is unknown or expired
+func (p *Pool) Get(id string) (Job, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expire()
+
+	job, found := p.jobs[id]
+	if !found {
+		return Job{}, false
+	}
+	return job.snapshot(), true
+}
+
+// List returns the jobs that have not expired, most recent first
+func (p *Pool) List() []Job {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expire()
+
+	list := make([]Job, 0, len(p.jobs))
+	for _, job := range p.jobs {
+		list = append(list, job.snapshot())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Created.Equal(list[j].Created) {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].Created.After(list[j].Created)
+	})
+	return list
+}
+
+// Drain stops accepting jobs and waits until the queued and running ones are finished, or the context is done.
+// Draining a nil *Pool does nothing.
+func (p *Pool) Drain(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) work() {
+	defer p.workers.Done()
+	for t := range p.queue {
+		p.mu.Lock()
+		started := p.now()
+		t.job.Started = &started
+		t.job.Status = Running
+		p.mu.Unlock()
+
+		err := t.work(&Run{pool: p, job: t.job})
+
+		p.mu.Lock()
+		finished := p.now()
+		t.job.Finished = &finished
+		t.job.Status = Succeeded
+		if err != nil {
+			t.job.Status = Failed
+			t.job.Error = err.Error()
+		}
+		p.mu.Unlock()
+	}
+}
+
+// expire forgets the jobs finished more than the retention period ago. It must be called with the lock held.
+func (p *Pool) expire() {
+	cutoff := p.now().Add(-p.retention)
+	for id, job := range p.jobs {
+		if job.Finished != nil && job.Finished.Before(cutoff) {
+			delete(p.jobs, id)
+		}
+	}
+}
+
+func (j *Job) snapshot() Job {
+	s := *j
+	s.Stages = append([]Stage{}, j.Stages...)
+	return s
+}
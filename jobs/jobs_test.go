@@ -0,0 +1,111 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clock is a settable time source
+type clock struct {
+	sync.Mutex
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.t
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func waitFor(t *testing.T, p *Pool, id string, status Status) Job {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if job, _ := p.Get(id); job.Status == status {
+			return job
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("job %s did not become %s", id, status)
+	return Job{}
+}
+
+func TestJobStages(t *testing.T) {
+	c := &clock{t: time.Date(2020, 3, 4, 10, 0, 0, 0, time.UTC)}
+	p := NewPool(1, 1, time.Hour)
+	p.now = c.now
+
+	submitted, err := p.Submit(map[string]string{"uuid": "1234"}, func(r *Run) error {
+		r.Stage("neo4j", func() error { c.advance(40 * time.Millisecond); return nil })
+		return r.Stage("forward", func() error { c.advance(10 * time.Millisecond); return errors.New("kafka is down") })
+	})
+	require.NoError(t, err)
+	assert.Equal(t, Queued, submitted.Status)
+
+	job := waitFor(t, p, submitted.ID, Failed)
+	assert.Equal(t, "kafka is down", job.Error)
+	assert.Equal(t, map[string]string{"uuid": "1234"}, job.Attributes)
+	assert.Equal(t, []Stage{
+		{Name: "neo4j", Status: Succeeded, Started: c.t.Add(-50 * time.Millisecond), DurationMs: 40},
+		{Name: "forward", Status: Failed, Started: c.t.Add(-10 * time.Millisecond), DurationMs: 10, Error: "kafka is down"},
+	}, job.Stages)
+	assert.Equal(t, c.t, *job.Finished)
+}
+
+func TestQueueIsBounded(t *testing.T) {
+	p := NewPool(1, 1, time.Hour)
+	release := make(chan struct{})
+	block := func(r *Run) error { <-release; return nil }
+
+	running, err := p.Submit(nil, block)
+	require.NoError(t, err)
+	waitFor(t, p, running.ID, Running)
+
+	queued, err := p.Submit(nil, block)
+	require.NoError(t, err)
+	_, err = p.Submit(nil, block)
+	assert.Equal(t, ErrQueueFull, err)
+
+	close(release)
+	require.NoError(t, p.Drain(context.Background()))
+	job, _ := p.Get(queued.ID)
+	assert.Equal(t, Succeeded, job.Status, "Queued jobs should be run before the pool is drained")
+
+	_, err = p.Submit(nil, block)
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestJobsExpire(t *testing.T) {
+	c := &clock{t: time.Date(2020, 3, 4, 10, 0, 0, 0, time.UTC)}
+	p := NewPool(1, 2, time.Hour)
+	p.now = c.now
+
+	first, err := p.Submit(nil, func(r *Run) error { return nil })
+	require.NoError(t, err)
+	waitFor(t, p, first.ID, Succeeded)
+	c.advance(time.Minute)
+	second, err := p.Submit(nil, func(r *Run) error { return nil })
+	require.NoError(t, err)
+	waitFor(t, p, second.ID, Succeeded)
+
+	list := p.List()
+	require.Len(t, list, 2)
+	assert.Equal(t, second.ID, list[0].ID, "Jobs should be listed most recent first")
+
+	c.advance(time.Hour - time.Second)
+	_, found := p.Get(first.ID)
+	assert.False(t, found, "The first job should have expired")
+	_, found = p.Get(second.ID)
+	assert.True(t, found)
+}
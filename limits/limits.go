@@ -0,0 +1,199 @@
+// Package limits protects the service from clients that send too much: token-bucket rate limits for each lifecycle
+// or origin system, a maximum number of annotations per content and a maximum request body size.
+package limits
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Rate is a token bucket refilled at PerSecond tokens a second, holding at most Burst tokens
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// ParseRates parses rate limits given as <key>=<requests per second>[:<burst>], where the key is a lifecycle or an
+// origin system id. The burst defaults to the rate per second, rounded up.
+func ParseRates(specs []string) (map[string]Rate, error) {
+	rates := map[string]Rate{}
+	for _, spec := range specs {
+		idx := strings.LastIndex(spec, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("rate limit %q must be <lifecycle or origin>=<requests per second>[:<burst>]", spec)
+		}
+		key, value := spec[:idx], spec[idx+1:]
+
+		parts := strings.SplitN(value, ":", 2)
+		perSecond, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil || perSecond <= 0 {
+			return nil, fmt.Errorf("rate limit of %s must be a positive number of requests per second", key)
+		}
+		burst := int(math.Ceil(perSecond))
+		if len(parts) == 2 {
+			if burst, err = strconv.Atoi(parts[1]); err != nil || burst < 1 {
+				return nil, fmt.Errorf("burst of the rate limit of %s must be a positive integer", key)
+			}
+		}
+		if _, found := rates[key]; found {
+			return nil, fmt.Errorf("rate limit of %s is set twice", key)
+		}
+		rates[key] = Rate{PerSecond: perSecond, Burst: burst}
+	}
+	return rates, nil
+}
+
+// QuotaExceededError is returned when a request is larger than allowed
+type QuotaExceededError struct {
+	msg string
+}
+
+func (e QuotaExceededError) Error() string {
+	return e.msg
+}
+
+// Limits applies the rate limits and quotas. A nil *Limits applies none.
+type Limits struct {
+	buckets        map[string]*rate.Limiter
+	maxAnnotations int
+	maxBodyBytes   int64
+	now            func() time.Time
+}
+
+// New returns the Limits applying the rates, keyed by lifecycle or origin system id.
+// A maxAnnotations or maxBodyBytes of 0 means no limit.
+func New(rates map[string]Rate, maxAnnotations int, maxBodyBytes int64) *Limits {
+	buckets := map[string]*rate.Limiter{}
+	for key, r := range rates {
+		buckets[key] = rate.NewLimiter(rate.Limit(r.PerSecond), r.Burst)
+	}
+	return &Limits{buckets: buckets, maxAnnotations: maxAnnotations, maxBodyBytes: maxBodyBytes, now: time.Now}
+}
+
+// Allow takes a token from the bucket of every key that has one, such as the lifecycle and origin system of a request.
+// When any bucket is empty no token is taken, and the time until the request could be allowed is returned.
+func (l *Limits) Allow(keys ...string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	now := l.now()
+	var reservations []*rate.Reservation
+	var wait time.Duration
+	for _, key := range keys {
+		bucket, found := l.buckets[key]
+		if !found {
+			continue
+		}
+		r := bucket.ReserveN(now, 1)
+		reservations = append(reservations, r)
+		if delay := r.DelayFrom(now); delay > wait {
+			wait = delay
+		}
+	}
+
+	if wait == 0 {
+		return true, 0
+	}
+	for _, r := range reservations {
+		r.CancelAt(now)
+	}
+	return false, wait
+}
+
+// Wait blocks until weight tokens are available in the bucket of every key, or the context is done, in which case the
+// tokens reserved are given back.
+// It is used where requests cannot be refused, so they are slowed down instead.
+func (l *Limits) Wait(ctx context.Context, weight int, keys ...string) error {
+	if l == nil {
+		return nil
+	}
+
+	now := l.now()
+	var wait time.Duration
+	var reservations []*rate.Reservation
+	for _, key := range keys {
+		bucket, found := l.buckets[key]
+		if !found {
+			continue
+		}
+		n := weight
+		if n > bucket.Burst() {
+			n = bucket.Burst()
+		}
+		r := bucket.ReserveN(now, n)
+		reservations = append(reservations, r)
+		if delay := r.DelayFrom(now); delay > wait {
+			wait = delay
+		}
+	}
+	if wait == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// the tokens of a wait that ended early are given back as if they were never reserved, so they do not slow
+		// down what follows
+		for _, r := range reservations {
+			r.CancelAt(now)
+		}
+		return ctx.Err()
+	}
+}
+
+// Weight is the number of tokens taken for content that cannot be refused when it exceeds the quotas:
+// one for every maximum number of annotations, or maximum body size, that it holds.
+func (l *Limits) Weight(annotationCount int, bodyBytes int) int {
+	weight := 1
+	if l == nil {
+		return weight
+	}
+	if l.maxAnnotations > 0 {
+		if w := (annotationCount + l.maxAnnotations - 1) / l.maxAnnotations; w > weight {
+			weight = w
+		}
+	}
+	if l.maxBodyBytes > 0 {
+		if w := int((int64(bodyBytes) + l.maxBodyBytes - 1) / l.maxBodyBytes); w > weight {
+			weight = w
+		}
+	}
+	return weight
+}
+
+// CheckAnnotations returns a QuotaExceededError when there are more annotations than allowed for one piece of content
+func (l *Limits) CheckAnnotations(count int) error {
+	if l == nil || l.maxAnnotations == 0 || count <= l.maxAnnotations {
+		return nil
+	}
+	return QuotaExceededError{msg: fmt.Sprintf("%d annotations were sent, more than the maximum of %d", count, l.maxAnnotations)}
+}
+
+// ReadBody reads a request body, returning a QuotaExceededError as soon as it is larger than allowed
+func (l *Limits) ReadBody(body io.Reader) ([]byte, error) {
+	if l == nil || l.maxBodyBytes == 0 {
+		return ioutil.ReadAll(body)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(body, l.maxBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > l.maxBodyBytes {
+		return nil, QuotaExceededError{msg: fmt.Sprintf("request body is larger than the maximum of %d bytes", l.maxBodyBytes)}
+	}
+	return b, nil
+}
@@ -0,0 +1,119 @@
+package limits
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRates(t *testing.T) {
+	rates, err := ParseRates([]string{"annotations-v1=10", "http://cmdb.ft.com/systems/pac=0.5:3"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Rate{
+		"annotations-v1":                 {PerSecond: 10, Burst: 10},
+		"http://cmdb.ft.com/systems/pac": {PerSecond: 0.5, Burst: 3},
+	}, rates)
+
+	for _, spec := range []string{"annotations-v1", "=10", "annotations-v1=fast", "annotations-v1=-1", "annotations-v1=1:0"} {
+		_, err = ParseRates([]string{spec})
+		assert.Error(t, err, spec)
+	}
+	_, err = ParseRates([]string{"annotations-v1=1", "annotations-v1=2"})
+	assert.EqualError(t, err, "rate limit of annotations-v1 is set twice")
+}
+
+func TestAllow(t *testing.T) {
+	now := time.Date(2020, 3, 4, 10, 0, 0, 0, time.UTC)
+	l := New(map[string]Rate{"annotations-v1": {PerSecond: 1, Burst: 2}, "pac": {PerSecond: 0.5, Burst: 1}}, 0, 0)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		allowed, _ := l.Allow("annotations-v1", "methode")
+		assert.True(t, allowed, "The burst should be allowed")
+	}
+	allowed, retryAfter := l.Allow("annotations-v1", "methode")
+	assert.False(t, allowed)
+	assert.Equal(t, time.Second, retryAfter)
+
+	allowed, _ = l.Allow("annotations-pac", "pac")
+	assert.True(t, allowed)
+	allowed, retryAfter = l.Allow("annotations-pac", "pac")
+	assert.False(t, allowed)
+	assert.Equal(t, 2*time.Second, retryAfter)
+
+	now = now.Add(time.Second)
+	allowed, _ = l.Allow("annotations-v1")
+	assert.True(t, allowed, "A token should have been refilled")
+
+	allowed, _ = l.Allow("annotations-next-video")
+	assert.True(t, allowed, "Lifecycles without a rate limit should not be limited")
+}
+
+func TestAllowDoesNotTakeTokensWhenRefused(t *testing.T) {
+	now := time.Date(2020, 3, 4, 10, 0, 0, 0, time.UTC)
+	l := New(map[string]Rate{"annotations-v1": {PerSecond: 1, Burst: 1}, "methode": {PerSecond: 1, Burst: 1}}, 0, 0)
+	l.now = func() time.Time { return now }
+
+	allowed, _ := l.Allow("methode")
+	assert.True(t, allowed)
+	allowed, _ = l.Allow("annotations-v1", "methode")
+	assert.False(t, allowed)
+	allowed, _ = l.Allow("annotations-v1")
+	assert.True(t, allowed, "The lifecycle token should have been given back when the origin was refused")
+}
+
+func TestWait(t *testing.T) {
+	l := New(map[string]Rate{"annotations-v1": {PerSecond: 1000, Burst: 5}}, 0, 0)
+	assert.NoError(t, l.Wait(context.Background(), 100, "annotations-v1"), "Weights above the burst should be capped")
+
+	l = New(map[string]Rate{"annotations-v1": {PerSecond: 0.001, Burst: 1}}, 0, 0)
+	assert.NoError(t, l.Wait(context.Background(), 1, "annotations-v1"))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, l.Wait(ctx, 1, "annotations-v1"), "An empty bucket should block")
+}
+
+func TestWaitGivesBackTokensWhenCancelled(t *testing.T) {
+	l := New(map[string]Rate{"annotations-v1": {PerSecond: 0.001, Burst: 1}, "methode": {PerSecond: 0.001, Burst: 1}}, 0, 0)
+	allowed, _ := l.Allow("annotations-v1")
+	require.True(t, allowed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, l.Wait(ctx, 1, "annotations-v1", "methode"))
+	allowed, _ = l.Allow("methode")
+	assert.True(t, allowed, "The origin token should have been given back when the wait was cancelled")
+}
+
+func TestQuotas(t *testing.T) {
+	l := New(nil, 100, 10)
+
+	assert.NoError(t, l.CheckAnnotations(100))
+	assert.EqualError(t, l.CheckAnnotations(101), "101 annotations were sent, more than the maximum of 100")
+
+	body, err := l.ReadBody(bytes.NewBufferString("0123456789"))
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(body))
+	_, err = l.ReadBody(bytes.NewBufferString("0123456789a"))
+	assert.IsType(t, QuotaExceededError{}, err)
+
+	assert.Equal(t, 1, l.Weight(100, 10))
+	assert.Equal(t, 3, l.Weight(201, 10))
+	assert.Equal(t, 5, l.Weight(1, 41))
+}
+
+func TestNilLimits(t *testing.T) {
+	var l *Limits
+	allowed, _ := l.Allow("annotations-v1")
+	assert.True(t, allowed)
+	assert.NoError(t, l.Wait(context.Background(), 10, "annotations-v1"))
+	assert.NoError(t, l.CheckAnnotations(100000))
+	assert.Equal(t, 1, l.Weight(100000, 100000))
+	body, err := l.ReadBody(bytes.NewBufferString("{}"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", string(body))
+}
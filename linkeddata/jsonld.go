@@ -0,0 +1,138 @@
+// Package linkeddata renders annotations as linked data, using the FT ontology for predicates and concept types
+// and PROV-O for the provenance of each annotation.
+package linkeddata
+
+import (
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+)
+
+// JSONLDMediaType is the media type of JSON-LD documents
+const JSONLDMediaType = "application/ld+json"
+
+// Namespaces used by the linked data representations
+const (
+	rdfNS  = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xsdNS  = "http://www.w3.org/2001/XMLSchema#"
+	provNS = "http://www.w3.org/ns/prov#"
+	skosNS = "http://www.w3.org/2004/02/skos/core#"
+
+	relevanceScoreURI  = "http://www.ft.com/ontology/annotation/relevanceScore"
+	confidenceScoreURI = "http://www.ft.com/ontology/annotation/confidenceScore"
+	lifecycleURI       = "http://www.ft.com/ontology/annotation/lifecycle"
+
+	relevanceScoringSystem  = "http://api.ft.com/scoringsystem/FT-RELEVANCE-SYSTEM"
+	confidenceScoringSystem = "http://api.ft.com/scoringsystem/FT-CONFIDENCE-SYSTEM"
+)
+
+// ContentURI returns the URI identifying a piece of content
+func ContentURI(uuid string) string {
+	return "http://www.ft.com/thing/" + uuid
+}
+
+// Context returns the JSON-LD context of the documents built by JSONLD
+func Context() map[string]interface{} {
+	context := map[string]interface{}{
+		"rdf":  rdfNS,
+		"xsd":  xsdNS,
+		"prov": provNS,
+		"skos": skosNS,
+
+		"prefLabel": "skos:prefLabel",
+		"lifecycle": lifecycleURI,
+
+		"annotations": map[string]interface{}{"@reverse": "rdf:subject"},
+		"Annotation":  "rdf:Statement",
+		"predicate":   map[string]interface{}{"@id": "rdf:predicate", "@type": "@id"},
+		"object":      map[string]interface{}{"@id": "rdf:object", "@type": "@id"},
+
+		"wasAttributedTo": map[string]interface{}{"@id": "prov:wasAttributedTo", "@type": "@id"},
+		"generatedAtTime": map[string]interface{}{"@id": "prov:generatedAtTime", "@type": "xsd:dateTime"},
+		"wasDerivedFrom":  map[string]interface{}{"@id": "prov:wasDerivedFrom", "@type": "@id"},
+		"relevanceScore":  map[string]interface{}{"@id": relevanceScoreURI, "@type": "xsd:double"},
+		"confidenceScore": map[string]interface{}{"@id": confidenceScoreURI, "@type": "xsd:double"},
+	}
+	for _, name := range annotations.PredicateNames() {
+		uri, _ := annotations.PredicateURI(name)
+		context[name] = map[string]interface{}{"@id": uri, "@type": "@id"}
+	}
+	return context
+}
+
+// JSONLD returns the JSON-LD document describing the annotations of a piece of content.
+// The content is the subject of one property per predicate, pointing at the annotated concepts, and every annotation
+// is also described as a statement carrying its scores and PROV-O provenance.
+// The document refers to the context at contextURL, or embeds it when contextURL is empty.
+func JSONLD(contentUUID string, lifecycle string, anns annotations.Annotations, contextURL string) (map[string]interface{}, error) {
+	var context interface{} = Context()
+	if contextURL != "" {
+		context = contextURL
+	}
+
+	doc := map[string]interface{}{
+		"@context": context,
+		"@id":      ContentURI(contentUUID),
+	}
+
+	var statements []map[string]interface{}
+	for _, ann := range anns {
+		predicate, err := annotations.PredicateName(ann.Thing.Predicate)
+		if err != nil {
+			return nil, err
+		}
+		predicateURI, _ := annotations.PredicateURI(predicate)
+
+		concept := map[string]interface{}{"@id": ann.Thing.ID}
+		if len(ann.Thing.Types) > 0 {
+			concept["@type"] = ann.Thing.Types
+		}
+		if ann.Thing.PrefLabel != "" {
+			concept["prefLabel"] = ann.Thing.PrefLabel
+		}
+		objects, _ := doc[predicate].([]interface{})
+		doc[predicate] = append(objects, concept)
+
+		statement := map[string]interface{}{
+			"@type":     []string{"Annotation", "prov:Entity"},
+			"predicate": predicateURI,
+			"object":    ann.Thing.ID,
+			"lifecycle": lifecycle,
+		}
+		for key, value := range provenanceProperties(ann) {
+			statement[key] = value
+		}
+		statements = append(statements, statement)
+	}
+	if len(statements) > 0 {
+		doc["annotations"] = statements
+	}
+	return doc, nil
+}
+
+// provenanceProperties returns the JSON-LD properties describing the provenance of an annotation.
+// Only the first provenance is used, as only one is stored for each annotation.
+func provenanceProperties(ann annotations.Annotation) map[string]interface{} {
+	props := map[string]interface{}{}
+	if len(ann.Provenances) == 0 {
+		return props
+	}
+
+	prov := ann.Provenances[0]
+	if prov.AgentRole != "" {
+		props["wasAttributedTo"] = prov.AgentRole
+	}
+	if prov.AtTime != "" {
+		props["generatedAtTime"] = prov.AtTime
+	}
+	if prov.SourceConceptID != "" {
+		props["wasDerivedFrom"] = prov.SourceConceptID
+	}
+	for _, score := range prov.Scores {
+		switch score.ScoringSystem {
+		case relevanceScoringSystem:
+			props["relevanceScore"] = score.Value
+		case confidenceScoringSystem:
+			props["confidenceScore"] = score.Value
+		}
+	}
+	return props
+}
@@ -0,0 +1,122 @@
+package linkeddata
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const contentUUID = "3fa70485-3a57-3b9b-9449-774b001cd965"
+
+var readAnnotations = annotations.Annotations{
+	{
+		Thing: annotations.Thing{
+			ID:        "http://api.ft.com/things/2384fa7a-d514-3d6a-a0ea-3a711f66d0d8",
+			PrefLabel: "Apple",
+			Types:     []string{"http://www.ft.com/ontology/core/Thing", "http://www.ft.com/ontology/concept/Concept", "http://www.ft.com/ontology/organisation/Organisation"},
+			Predicate: "MENTIONS",
+		},
+		Provenances: []annotations.Provenance{{
+			Scores: []annotations.Score{
+				{ScoringSystem: relevanceScoringSystem, Value: 0.9},
+				{ScoringSystem: confidenceScoringSystem, Value: 0.8},
+			},
+			AgentRole:       "http://api.ft.com/things/0edd3c31-1fd0-4ef6-9230-8d545be3880a",
+			AtTime:          "2016-01-20T19:43:47.314Z",
+			SourceConceptID: "http://api.ft.com/things/ccaa202e-3d27-3b75-b2f2-261cf5038e1e",
+		}},
+	},
+	{
+		Thing: annotations.Thing{
+			ID:        "http://api.ft.com/things/5507ab98-b747-3ebc-b816-11603b9a4e56",
+			Predicate: "ABOUT",
+		},
+	},
+}
+
+func TestJSONLDWithEmbeddedContext(t *testing.T) {
+	doc, err := JSONLD(contentUUID, "annotations-v1", readAnnotations, "")
+	assert.NoError(t, err)
+
+	actual, err := json.Marshal(doc)
+	assert.NoError(t, err)
+	expected, err := json.Marshal(map[string]interface{}{
+		"@context": Context(),
+		"@id":      "http://www.ft.com/thing/3fa70485-3a57-3b9b-9449-774b001cd965",
+		"mentions": []interface{}{map[string]interface{}{
+			"@id":       "http://api.ft.com/things/2384fa7a-d514-3d6a-a0ea-3a711f66d0d8",
+			"@type":     []string{"http://www.ft.com/ontology/core/Thing", "http://www.ft.com/ontology/concept/Concept", "http://www.ft.com/ontology/organisation/Organisation"},
+			"prefLabel": "Apple",
+		}},
+		"about": []interface{}{map[string]interface{}{
+			"@id": "http://api.ft.com/things/5507ab98-b747-3ebc-b816-11603b9a4e56",
+		}},
+		"annotations": []interface{}{
+			map[string]interface{}{
+				"@type":           []string{"Annotation", "prov:Entity"},
+				"predicate":       "http://www.ft.com/ontology/annotation/mentions",
+				"object":          "http://api.ft.com/things/2384fa7a-d514-3d6a-a0ea-3a711f66d0d8",
+				"lifecycle":       "annotations-v1",
+				"wasAttributedTo": "http://api.ft.com/things/0edd3c31-1fd0-4ef6-9230-8d545be3880a",
+				"generatedAtTime": "2016-01-20T19:43:47.314Z",
+				"wasDerivedFrom":  "http://api.ft.com/things/ccaa202e-3d27-3b75-b2f2-261cf5038e1e",
+				"relevanceScore":  0.9,
+				"confidenceScore": 0.8,
+			},
+			map[string]interface{}{
+				"@type":     []string{"Annotation", "prov:Entity"},
+				"predicate": "http://www.ft.com/ontology/annotation/about",
+				"object":    "http://api.ft.com/things/5507ab98-b747-3ebc-b816-11603b9a4e56",
+				"lifecycle": "annotations-v1",
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(expected), string(actual))
+}
+
+func TestJSONLDLeavesOutScoresThatAreNotStored(t *testing.T) {
+	anns := annotations.Annotations{{
+		Thing: annotations.Thing{
+			ID:        "http://api.ft.com/things/2384fa7a-d514-3d6a-a0ea-3a711f66d0d8",
+			Predicate: "MENTIONS",
+		},
+		Provenances: []annotations.Provenance{{
+			SourceConceptID: "http://api.ft.com/things/ccaa202e-3d27-3b75-b2f2-261cf5038e1e",
+		}},
+	}}
+	doc, err := JSONLD(contentUUID, "annotations-v1", anns, "")
+	assert.NoError(t, err)
+
+	statements := doc["annotations"].([]map[string]interface{})
+	assert.Len(t, statements, 1)
+	assert.Equal(t, "http://api.ft.com/things/ccaa202e-3d27-3b75-b2f2-261cf5038e1e", statements[0]["wasDerivedFrom"])
+	assert.NotContains(t, statements[0], "relevanceScore")
+	assert.NotContains(t, statements[0], "confidenceScore")
+}
+
+func TestJSONLDWithContextURL(t *testing.T) {
+	doc, err := JSONLD(contentUUID, "annotations-v1", annotations.Annotations{}, "https://example.com/__context.jsonld")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"@context": "https://example.com/__context.jsonld",
+		"@id":      "http://www.ft.com/thing/3fa70485-3a57-3b9b-9449-774b001cd965",
+	}, doc)
+}
+
+func TestJSONLDFailsForUnknownPredicate(t *testing.T) {
+	_, err := JSONLD(contentUUID, "annotations-v1", annotations.Annotations{{Thing: annotations.Thing{Predicate: "LIKES"}}}, "")
+	assert.Equal(t, annotations.UnsupportedPredicateErr, err)
+}
+
+func TestContextDefinesEveryPredicate(t *testing.T) {
+	context := Context()
+	for _, name := range annotations.PredicateNames() {
+		uri, err := annotations.PredicateURI(name)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"@id": uri, "@type": "@id"}, context[name], name)
+	}
+}
@@ -0,0 +1,182 @@
+package linkeddata
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+)
+
+// Media types of the supported RDF serialisations
+const (
+	NTriplesMediaType = "application/n-triples"
+	TurtleMediaType   = "text/turtle"
+)
+
+// RDF formats, as named on the command line
+const (
+	NTriples = "ntriples"
+	Turtle   = "turtle"
+)
+
+// MediaTypeOf returns the media type of a named RDF format
+func MediaTypeOf(format string) (string, error) {
+	switch format {
+	case NTriples:
+		return NTriplesMediaType, nil
+	case Turtle:
+		return TurtleMediaType, nil
+	}
+	return "", fmt.Errorf("unsupported RDF format %q, must be %s or %s", format, NTriples, Turtle)
+}
+
+var turtlePrefixes = []struct {
+	prefix string
+	ns     string
+}{
+	{"rdf", rdfNS},
+	{"xsd", xsdNS},
+	{"prov", provNS},
+	{"skos", skosNS},
+	{"ftann", "http://www.ft.com/ontology/annotation/"},
+	{"ft", "http://www.ft.com/ontology/"},
+}
+
+// term is an RDF term already serialised in N-Triples syntax, or a Turtle prefixed name
+type term string
+
+// RDFWriter streams annotations as RDF triples. Every annotation gives a triple from the content to the concept
+// and a reified rdf:Statement carrying its lifecycle, scores and PROV-O provenance; the types and prefLabel
+// of the concepts are included as well.
+type RDFWriter struct {
+	w          *bufio.Writer
+	turtle     bool
+	blankNodes int
+	started    bool
+}
+
+// NewRDFWriter returns a writer of the given media type, either NTriplesMediaType or TurtleMediaType
+func NewRDFWriter(w io.Writer, mediaType string) (*RDFWriter, error) {
+	if mediaType != NTriplesMediaType && mediaType != TurtleMediaType {
+		return nil, fmt.Errorf("unsupported RDF media type %s", mediaType)
+	}
+	return &RDFWriter{w: bufio.NewWriter(w), turtle: mediaType == TurtleMediaType}, nil
+}
+
+// Write writes the triples describing the annotations of one piece of content
+func (rw *RDFWriter) Write(lifecycle string, content annotations.ContentAnnotations) error {
+	if !rw.started {
+		rw.started = true
+		if rw.turtle {
+			for _, p := range turtlePrefixes {
+				fmt.Fprintf(rw.w, "@prefix %s: <%s> .\n", p.prefix, p.ns)
+			}
+			rw.w.WriteString("\n")
+		}
+	}
+
+	subject := rw.iri(ContentURI(content.ContentUUID))
+	for _, ann := range content.Annotations {
+		predicateURI, err := annotations.PredicateURI(ann.Thing.Predicate)
+		if err != nil {
+			return err
+		}
+		predicate := rw.iri(predicateURI)
+		concept := rw.iri(ann.Thing.ID)
+
+		rw.triple(subject, predicate, concept)
+		for _, t := range ann.Thing.Types {
+			rw.triple(concept, rw.iri(rdfNS+"type"), rw.iri(t))
+		}
+		if ann.Thing.PrefLabel != "" {
+			rw.triple(concept, rw.iri(skosNS+"prefLabel"), literal(ann.Thing.PrefLabel, ""))
+		}
+
+		rw.blankNodes++
+		statement := term(fmt.Sprintf("_:a%d", rw.blankNodes))
+		rw.triple(statement, rw.iri(rdfNS+"type"), rw.iri(rdfNS+"Statement"))
+		rw.triple(statement, rw.iri(rdfNS+"type"), rw.iri(provNS+"Entity"))
+		rw.triple(statement, rw.iri(rdfNS+"subject"), subject)
+		rw.triple(statement, rw.iri(rdfNS+"predicate"), predicate)
+		rw.triple(statement, rw.iri(rdfNS+"object"), concept)
+		rw.triple(statement, rw.iri(lifecycleURI), literal(lifecycle, ""))
+
+		props := provenanceProperties(ann)
+		if agent, found := props["wasAttributedTo"]; found {
+			rw.triple(statement, rw.iri(provNS+"wasAttributedTo"), rw.iri(agent.(string)))
+		}
+		if atTime, found := props["generatedAtTime"]; found {
+			rw.triple(statement, rw.iri(provNS+"generatedAtTime"), literal(atTime.(string), rw.iri(xsdNS+"dateTime")))
+		}
+		if source, found := props["wasDerivedFrom"]; found {
+			rw.triple(statement, rw.iri(provNS+"wasDerivedFrom"), rw.iri(source.(string)))
+		}
+		if score, found := props["relevanceScore"]; found {
+			rw.triple(statement, rw.iri(relevanceScoreURI), literal(formatDouble(score.(float64)), rw.iri(xsdNS+"double")))
+		}
+		if score, found := props["confidenceScore"]; found {
+			rw.triple(statement, rw.iri(confidenceScoreURI), literal(formatDouble(score.(float64)), rw.iri(xsdNS+"double")))
+		}
+	}
+	return rw.w.Flush()
+}
+
+// Comment writes a comment line, e.g. to flag an export that stopped part way through
+func (rw *RDFWriter) Comment(text string) error {
+	fmt.Fprintf(rw.w, "# %s\n", strings.Replace(text, "\n", " ", -1))
+	return rw.w.Flush()
+}
+
+func (rw *RDFWriter) triple(s, p, o term) {
+	fmt.Fprintf(rw.w, "%s %s %s .\n", s, p, o)
+}
+
+// iri serialises an IRI, as a prefixed name in Turtle when the local name allows it
+func (rw *RDFWriter) iri(value string) term {
+	if rw.turtle {
+		for _, p := range turtlePrefixes {
+			if local := strings.TrimPrefix(value, p.ns); local != value && isPrefixedLocalName(local) {
+				return term(p.prefix + ":" + local)
+			}
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("<")
+	for _, r := range value {
+		if r <= 0x20 || strings.ContainsRune("<>\"{}|^`\\", r) {
+			fmt.Fprintf(&b, "\\u%04X", r)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	b.WriteString(">")
+	return term(b.String())
+}
+
+func isPrefixedLocalName(local string) bool {
+	if local == "" {
+		return false
+	}
+	for _, r := range local {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func literal(value string, datatype term) term {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`).Replace(value)
+	if datatype != "" {
+		return term(fmt.Sprintf(`"%s"^^%s`, escaped, datatype))
+	}
+	return term(fmt.Sprintf(`"%s"`, escaped))
+}
+
+func formatDouble(value float64) string {
+	return strconv.FormatFloat(value, 'E', -1, 64)
+}
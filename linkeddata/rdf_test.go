@@ -0,0 +1,84 @@
+package linkeddata
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRDFWriterNTriples(t *testing.T) {
+	var out bytes.Buffer
+	rw, err := NewRDFWriter(&out, NTriplesMediaType)
+	assert.NoError(t, err)
+
+	err = rw.Write("annotations-v1", annotations.ContentAnnotations{ContentUUID: contentUUID, Annotations: readAnnotations[:1]})
+	assert.NoError(t, err)
+
+	expected := `<http://www.ft.com/thing/3fa70485-3a57-3b9b-9449-774b001cd965> <http://www.ft.com/ontology/annotation/mentions> <http://api.ft.com/things/2384fa7a-d514-3d6a-a0ea-3a711f66d0d8> .
+<http://api.ft.com/things/2384fa7a-d514-3d6a-a0ea-3a711f66d0d8> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://www.ft.com/ontology/core/Thing> .
+<http://api.ft.com/things/2384fa7a-d514-3d6a-a0ea-3a711f66d0d8> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://www.ft.com/ontology/concept/Concept> .
+<http://api.ft.com/things/2384fa7a-d514-3d6a-a0ea-3a711f66d0d8> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://www.ft.com/ontology/organisation/Organisation> .
+<http://api.ft.com/things/2384fa7a-d514-3d6a-a0ea-3a711f66d0d8> <http://www.w3.org/2004/02/skos/core#prefLabel> "Apple" .
+_:a1 <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://www.w3.org/1999/02/22-rdf-syntax-ns#Statement> .
+_:a1 <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://www.w3.org/ns/prov#Entity> .
+_:a1 <http://www.w3.org/1999/02/22-rdf-syntax-ns#subject> <http://www.ft.com/thing/3fa70485-3a57-3b9b-9449-774b001cd965> .
+_:a1 <http://www.w3.org/1999/02/22-rdf-syntax-ns#predicate> <http://www.ft.com/ontology/annotation/mentions> .
+_:a1 <http://www.w3.org/1999/02/22-rdf-syntax-ns#object> <http://api.ft.com/things/2384fa7a-d514-3d6a-a0ea-3a711f66d0d8> .
+_:a1 <http://www.ft.com/ontology/annotation/lifecycle> "annotations-v1" .
+_:a1 <http://www.w3.org/ns/prov#wasAttributedTo> <http://api.ft.com/things/0edd3c31-1fd0-4ef6-9230-8d545be3880a> .
+_:a1 <http://www.w3.org/ns/prov#generatedAtTime> "2016-01-20T19:43:47.314Z"^^<http://www.w3.org/2001/XMLSchema#dateTime> .
+_:a1 <http://www.w3.org/ns/prov#wasDerivedFrom> <http://api.ft.com/things/ccaa202e-3d27-3b75-b2f2-261cf5038e1e> .
+_:a1 <http://www.ft.com/ontology/annotation/relevanceScore> "9E-01"^^<http://www.w3.org/2001/XMLSchema#double> .
+_:a1 <http://www.ft.com/ontology/annotation/confidenceScore> "8E-01"^^<http://www.w3.org/2001/XMLSchema#double> .
+`
+	assert.Equal(t, expected, out.String())
+}
+
+func TestRDFWriterTurtle(t *testing.T) {
+	var out bytes.Buffer
+	rw, err := NewRDFWriter(&out, TurtleMediaType)
+	assert.NoError(t, err)
+
+	assert.NoError(t, rw.Write("annotations-v1", annotations.ContentAnnotations{ContentUUID: contentUUID, Annotations: readAnnotations[1:]}))
+	assert.NoError(t, rw.Write("annotations-v1", annotations.ContentAnnotations{ContentUUID: contentUUID, Annotations: readAnnotations[1:]}))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, "@prefix rdf: <http://www.w3.org/1999/02/22-rdf-syntax-ns#> .", lines[0])
+	assert.Equal(t, 1, strings.Count(out.String(), "@prefix rdf:"), "Prefixes should only be written once")
+	assert.Contains(t, lines, "<http://www.ft.com/thing/3fa70485-3a57-3b9b-9449-774b001cd965> ftann:about <http://api.ft.com/things/5507ab98-b747-3ebc-b816-11603b9a4e56> .")
+	assert.Contains(t, lines, "_:a1 rdf:type rdf:Statement .")
+	assert.Contains(t, lines, "_:a2 rdf:type rdf:Statement .", "Blank nodes should be unique across the stream")
+}
+
+func TestRDFWriterLeavesOutScoresThatAreNotStored(t *testing.T) {
+	var out bytes.Buffer
+	rw, err := NewRDFWriter(&out, NTriplesMediaType)
+	assert.NoError(t, err)
+
+	ann := readAnnotations[0]
+	ann.Provenances = []annotations.Provenance{{AgentRole: "http://api.ft.com/things/0edd3c31-1fd0-4ef6-9230-8d545be3880a"}}
+	assert.NoError(t, rw.Write("annotations-v1", annotations.ContentAnnotations{ContentUUID: contentUUID, Annotations: annotations.Annotations{ann}}))
+
+	assert.Contains(t, out.String(), "_:a1 <http://www.w3.org/ns/prov#wasAttributedTo> <http://api.ft.com/things/0edd3c31-1fd0-4ef6-9230-8d545be3880a> .")
+	assert.NotContains(t, out.String(), "relevanceScore")
+	assert.NotContains(t, out.String(), "confidenceScore")
+}
+
+func TestRDFEscaping(t *testing.T) {
+	rw, _ := NewRDFWriter(&bytes.Buffer{}, NTriplesMediaType)
+	assert.Equal(t, term(`<http://example.com/a\u0020b\u003E>`), rw.iri("http://example.com/a b>"))
+	assert.Equal(t, term(`"say \"hi\"\n\\"`), literal("say \"hi\"\n\\", ""))
+}
+
+func TestMediaTypeOf(t *testing.T) {
+	mediaType, err := MediaTypeOf("turtle")
+	assert.NoError(t, err)
+	assert.Equal(t, TurtleMediaType, mediaType)
+
+	_, err = MediaTypeOf("rdfxml")
+	assert.Error(t, err)
+}
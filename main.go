@@ -1,17 +1,29 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/audit"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/auth"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/breaker"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/cache"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/canary"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/changes"
 	"github.com/Financial-Times/annotations-rw-neo4j/v4/forwarder"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/jobs"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/limits"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/linkeddata"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/stats"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/tracing"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/webhooks"
 
 	logger "github.com/Financial-Times/go-logger/v2"
 	"github.com/Financial-Times/http-handlers-go/v2/httphandlers"
@@ -57,6 +69,12 @@ func main() {
 		Desc:   "Json Config file - containing two config maps: one for originHeader to lifecycle, another for lifecycle to platformVersion mappings. ",
 		EnvVar: "LIFECYCLE_CONFIG_PATH",
 	})
+	configReloadInterval := app.Int(cli.IntOpt{
+		Name:   "configReloadInterval",
+		Value:  30,
+		Desc:   "Seconds between checks of the lifecycle configuration file for changes, which are then reloaded",
+		EnvVar: "CONFIG_RELOAD_INTERVAL",
+	})
 	zookeeperAddress := app.String(cli.StringOpt{
 		Name:   "zookeeperAddress",
 		Value:  "localhost:2181",
@@ -97,6 +115,200 @@ func main() {
 		Desc:   "Decides if annotations messages should be forwarded to a post publication queue",
 		EnvVar: "SHOULD_FORWARD_MESSAGES",
 	})
+	forwardHealthWindow := app.Int(cli.IntOpt{
+		Name:   "forwardHealthWindow",
+		Value:  100,
+		Desc:   "Number of the last messages forwarded the forwarding success ratio of __health is computed on, 0 to disable the check",
+		EnvVar: "FORWARD_HEALTH_WINDOW",
+	})
+	forwardHealthPeriod := app.Int(cli.IntOpt{
+		Name:   "forwardHealthPeriod",
+		Value:  300,
+		Desc:   "Seconds after which a message forwarded no longer counts towards the forwarding success ratio",
+		EnvVar: "FORWARD_HEALTH_PERIOD",
+	})
+	forwardHealthMinPercent := app.Int(cli.IntOpt{
+		Name:   "forwardHealthMinPercent",
+		Value:  90,
+		Desc:   "Percentage of the last messages forwarded that must have been sent for the forwarding to be healthy",
+		EnvVar: "FORWARD_HEALTH_MIN_PERCENT",
+	})
+	forwardingInGTG := app.Bool(cli.BoolOpt{
+		Name:   "forwardingInGtg",
+		Value:  false,
+		Desc:   "Fail __gtg when the producer cannot reach Kafka or the forwarding success ratio is too low, for the instance to leave the load balancer",
+		EnvVar: "FORWARDING_IN_GTG",
+	})
+	conceptIDSchemes := app.Strings(cli.StringsOpt{
+		Name:   "conceptIdSchemes",
+		Value:  []string{"ft-uri", "uuid", "urn"},
+		Desc:   "Concept identifier forms accepted on write, tried in order (ft-uri, uuid, urn, tme)",
+		EnvVar: "CONCEPT_ID_SCHEMES",
+	})
+	resolveEquivalentConcepts := app.Bool(cli.BoolOpt{
+		Name:   "resolveEquivalentConcepts",
+		Value:  false,
+		Desc:   "Write annotations against the canonical concept found by following EQUIVALENT_TO relationships, keeping the supplied concept as sourceConceptId",
+		EnvVar: "RESOLVE_EQUIVALENT_CONCEPTS",
+	})
+	apiSpecPath := app.String(cli.StringOpt{
+		Name:   "apiSpecPath",
+		Value:  "openapi.json",
+		Desc:   "OpenAPI document of the service, served at /__api and used to validate request bodies",
+		EnvVar: "API_SPEC_PATH",
+	})
+	jsonLDContextURL := app.String(cli.StringOpt{
+		Name:   "jsonLdContextUrl",
+		Desc:   "Public URL of the JSON-LD context served at /__context.jsonld, referenced by JSON-LD responses. The context is embedded in the responses when empty",
+		EnvVar: "JSON_LD_CONTEXT_URL",
+	})
+	authConfigPath := app.String(cli.StringOpt{
+		Name:   "authConfigPath",
+		Desc:   "Json Config file - containing the credentials of the API clients and the lifecycles and methods granted to each. Requests are not authenticated when empty",
+		EnvVar: "AUTH_CONFIG_PATH",
+	})
+	openReads := app.Bool(cli.BoolOpt{
+		Name:   "openReads",
+		Value:  true,
+		Desc:   "Allow GET requests without credentials when authentication is enabled",
+		EnvVar: "OPEN_READS",
+	})
+	rateLimits := app.Strings(cli.StringsOpt{
+		Name:   "rateLimits",
+		Value:  []string{},
+		Desc:   "Token-bucket rate limits of writes, as <lifecycle or origin system id>=<requests per second>[:<burst>]",
+		EnvVar: "RATE_LIMITS",
+	})
+	maxAnnotationsPerContent := app.Int(cli.IntOpt{
+		Name:   "maxAnnotationsPerContent",
+		Value:  0,
+		Desc:   "Maximum number of annotations written for one piece of content, 0 for no limit",
+		EnvVar: "MAX_ANNOTATIONS_PER_CONTENT",
+	})
+	maxRequestBodyBytes := app.Int(cli.IntOpt{
+		Name:   "maxRequestBodyBytes",
+		Value:  0,
+		Desc:   "Maximum size in bytes of the body of a write, 0 for no limit",
+		EnvVar: "MAX_REQUEST_BODY_BYTES",
+	})
+	readinessDrainDelay := app.Int(cli.IntOpt{
+		Name:   "readinessDrainDelay",
+		Value:  5,
+		Desc:   "Seconds between failing __gtg and closing the HTTP listener on shutdown, for load balancers to stop sending requests",
+		EnvVar: "READINESS_DRAIN_DELAY",
+	})
+	shutdownTimeout := app.Int(cli.IntOpt{
+		Name:   "shutdownTimeout",
+		Value:  25,
+		Desc:   "Seconds given to in-flight requests and messages to complete on shutdown",
+		EnvVar: "SHUTDOWN_TIMEOUT",
+	})
+	webhooksConfigPath := app.String(cli.StringOpt{
+		Name:   "webhooksConfigPath",
+		Desc:   "Json Config file - containing the webhook subscribers notified of annotation changes and how deliveries are retried. Webhooks are disabled when empty",
+		EnvVar: "WEBHOOKS_CONFIG_PATH",
+	})
+	changeStreamBufferSize := app.Int(cli.IntOpt{
+		Name:   "changeStreamBufferSize",
+		Value:  0,
+		Desc:   "Number of recent changes kept for clients of /__changes/stream to resume from, 0 to disable the change stream. The annotations are read before and after every write while it is enabled",
+		EnvVar: "CHANGE_STREAM_BUFFER_SIZE",
+	})
+	auditSink := app.String(cli.StringOpt{
+		Name:   "auditSink",
+		Value:  "",
+		Desc:   "Where the audit records of the writes and deletes are stored: file, neo4j or kafka. The audit log is disabled when empty. The annotations are read before every write while it is enabled",
+		EnvVar: "AUDIT_SINK",
+	})
+	auditFilePath := app.String(cli.StringOpt{
+		Name:   "auditFilePath",
+		Value:  "annotations-audit.jsonl",
+		Desc:   "File the audit records are appended to with the file audit sink",
+		EnvVar: "AUDIT_FILE_PATH",
+	})
+	auditFileMaxBytes := app.Int(cli.IntOpt{
+		Name:   "auditFileMaxBytes",
+		Value:  100 * 1024 * 1024,
+		Desc:   "Size in bytes at which the audit file is rotated, 0 to never rotate it",
+		EnvVar: "AUDIT_FILE_MAX_BYTES",
+	})
+	auditFileMaxFiles := app.Int(cli.IntOpt{
+		Name:   "auditFileMaxFiles",
+		Value:  10,
+		Desc:   "Number of rotated audit files kept, 0 to keep them all",
+		EnvVar: "AUDIT_FILE_MAX_FILES",
+	})
+	auditTopic := app.String(cli.StringOpt{
+		Name:   "auditTopic",
+		Value:  "AnnotationsAudit",
+		Desc:   "Kafka topic the audit records are sent to with the kafka audit sink",
+		EnvVar: "AUDIT_TOPIC",
+	})
+	tracingExporter := app.String(cli.StringOpt{
+		Name:   "tracingExporter",
+		Desc:   "Where the OpenTelemetry spans are exported: otlp, configured by the standard OTEL_EXPORTER_OTLP_* variables, or stdout for local runs. Tracing is disabled when empty",
+		EnvVar: "TRACING_EXPORTER",
+	})
+	asyncWorkers := app.Int(cli.IntOpt{
+		Name:   "asyncWorkers",
+		Value:  4,
+		Desc:   "Number of workers running the writes of PUT requests with a Prefer: respond-async header, 0 to always write synchronously",
+		EnvVar: "ASYNC_WORKERS",
+	})
+	asyncQueueSize := app.Int(cli.IntOpt{
+		Name:   "asyncQueueSize",
+		Value:  100,
+		Desc:   "Maximum number of asynchronous writes waiting for a worker",
+		EnvVar: "ASYNC_QUEUE_SIZE",
+	})
+	jobRetention := app.Int(cli.IntOpt{
+		Name:   "jobRetention",
+		Value:  3600,
+		Desc:   "Seconds for which the status of a finished asynchronous write is kept",
+		EnvVar: "JOB_RETENTION",
+	})
+	breakerFailures := app.Int(cli.IntOpt{
+		Name:   "breakerFailures",
+		Value:  5,
+		Desc:   "Number of consecutive failed calls to Neo4j opening the circuit breaker, which fails requests fast and pauses the consumer. The breaker is disabled when 0",
+		EnvVar: "BREAKER_FAILURES",
+	})
+	breakerOpenTimeout := app.Int(cli.IntOpt{
+		Name:   "breakerOpenTimeout",
+		Value:  30,
+		Desc:   "Seconds the circuit breaker stays open before letting a call probe Neo4j",
+		EnvVar: "BREAKER_OPEN_TIMEOUT",
+	})
+	canaryInterval := app.Int(cli.IntOpt{
+		Name:   "canaryInterval",
+		Value:  0,
+		Desc:   "Seconds between runs of the canary writing, reading back and deleting an annotation for a reserved piece of content, reported by __health. The canary is disabled when 0",
+		EnvVar: "CANARY_INTERVAL",
+	})
+	cacheSize := app.Int(cli.IntOpt{
+		Name:   "cacheSize",
+		Value:  0,
+		Desc:   "Number of annotation sets, one per piece of content and lifecycle, kept in the read cache. The cache is disabled when 0",
+		EnvVar: "CACHE_SIZE",
+	})
+	cacheTTL := app.Int(cli.IntOpt{
+		Name:   "cacheTTL",
+		Value:  30,
+		Desc:   "Seconds for which annotations are kept in the read cache, also returned as the max-age of Cache-Control",
+		EnvVar: "CACHE_TTL",
+	})
+	cacheInvalidationTopic := app.String(cli.StringOpt{
+		Name:   "cacheInvalidationTopic",
+		Value:  "",
+		Desc:   "Kafka topic the instances publish their cache invalidations to, for each to invalidate the annotations written by the others. Invalidations are local when empty",
+		EnvVar: "CACHE_INVALIDATION_TOPIC",
+	})
+	maintenance := app.Bool(cli.BoolOpt{
+		Name:   "maintenance",
+		Value:  false,
+		Desc:   "Start in maintenance mode, in which annotations can be read but not written or deleted, and consumption is paused. It can be toggled at runtime by PUT /__maintenance",
+		EnvVar: "MAINTENANCE",
+	})
 	appName := app.String(cli.StringOpt{
 		Name:   "appName",
 		Value:  "annotations-rw",
@@ -109,39 +321,165 @@ func main() {
 		log := logger.NewUPPLogger(*appName, *logLevel, logConf)
 		log.WithFields(map[string]interface{}{"port": *port, "neoURL": *neoURL}).Infof("Service %s has successfully started.", *appName)
 
-		annotationsService, err := setupAnnotationsService(*neoURL, *batchSize)
+		idSchemes, err := annotations.LookupIDSchemes(*conceptIDSchemes)
+		if err != nil {
+			log.WithError(err).Fatal("invalid concept identifier configuration")
+		}
+
+		shutdownTracing, err := tracing.Setup(context.Background(), *tracingExporter, *appName)
+		if err != nil {
+			log.WithError(err).Fatal("can't initialise tracing")
+		}
+
+		m := stats.New()
+		db, closeNeo, err := connectNeo(*neoURL, *batchSize)
+		if err != nil {
+			log.WithError(err).Fatal("can't connect to Neo4j")
+		}
+		db = tracing.Neo4j(m.Neo4j(db))
+		annotationsService, err := setupAnnotationsService(db, *resolveEquivalentConcepts, annotations.WithIDResolver(annotations.NewSchemeResolver(idSchemes...)))
 		if err != nil {
 			log.WithError(err).Fatal("can't initialise annotations service")
 		}
-		healtcheckHandler := healthCheckHandler{annotationsService: annotationsService}
-		originMap, lifecycleMap, messageType, err := readConfigMap(*config)
+		var roundTrip *canary.Canary
+		if *canaryInterval > 0 {
+			// the canary writes are left out of the metrics
+			roundTrip = canary.New(annotationsService)
+		}
+		var neoBreaker *breaker.Breaker
+		if *breakerFailures > 0 {
+			neoBreaker = breaker.New(*breakerFailures, time.Duration(*breakerOpenTimeout)*time.Second, m.BreakerState)
+		}
+		var readCache *cache.Cache
+		var cacheChannel *cache.KafkaChannel
+		if *cacheSize > 0 {
+			readCache = cache.New(*cacheSize, time.Duration(*cacheTTL)*time.Second, m.CacheLookup)
+			if *cacheInvalidationTopic != "" {
+				ch, setupErr := setupCacheChannel(*brokerAddress, *zookeeperAddress, *appName, *cacheInvalidationTopic, log)
+				if setupErr != nil {
+					log.WithError(setupErr).Fatal("can't initialise cache invalidation channel")
+				}
+				readCache.Broadcast(ch)
+				ch.Listen(readCache)
+				cacheChannel = ch
+			}
+		}
+		// calls rejected by the breaker, and reads served from the cache, are left out of the metrics
+		annotationsService = readCache.Service(neoBreaker.Service(m.Service(annotationsService)))
+		state := &shutdownState{}
+		maintenanceMode := newMaintenanceMode()
+		if *maintenance {
+			maintenanceMode.set(true, "started with --maintenance")
+			log.Warn("Starting in maintenance mode, writes and deletes are rejected and consumption is paused")
+		}
+		healtcheckHandler := healthCheckHandler{
+			annotationsService: annotationsService,
+			canary:             roundTrip,
+			canaryInterval:     time.Duration(*canaryInterval) * time.Second,
+			breaker:            neoBreaker,
+			maintenance:        maintenanceMode,
+			shutdown:           state,
+		}
+		lifecycleConf, err := readLifecycleConfig(*config)
 		if err != nil {
 			log.WithError(err).Fatal("can't read service configuration")
 		}
+		messageType := lifecycleConf.MessageType
+		liveConf := newLiveConfig(lifecycleConf)
+		reloader := newConfigReloader(*config, liveConf, log)
 
 		var f forwarder.QueueForwarder
+		var producer kafka.Producer
 		if *shouldForwardMessages {
 			p, setupErr := setupMessageProducer(*brokerAddress, *producerTopic)
 			if setupErr != nil {
 				log.WithError(setupErr).Fatal("can't initialise message producer")
 			}
+			producer = p
 
-			f = &forwarder.Forwarder{
+			var forwarded *forwarder.SuccessRatio
+			if *forwardHealthWindow > 0 {
+				forwarded = forwarder.NewSuccessRatio(*forwardHealthWindow, time.Duration(*forwardHealthPeriod)*time.Second)
+			}
+			f = forwarded.Forwarder(m.Forwarder(&forwarder.Forwarder{
 				Producer:    p,
 				MessageType: messageType,
+			}))
+
+			healtcheckHandler.producer = p
+			healtcheckHandler.forwarded = forwarded
+			healtcheckHandler.minForwardedRatio = float64(*forwardHealthMinPercent) / 100
+			healtcheckHandler.forwardingInGTG = *forwardingInGTG
+		}
+
+		spec, err := loadAPISpec(*apiSpecPath)
+		if err != nil {
+			log.WithError(err).Fatal("can't read API specification")
+		}
+
+		var guard *auth.Guard
+		if *authConfigPath != "" {
+			guard, err = auth.LoadGuard(*authConfigPath, *openReads)
+			if err != nil {
+				log.WithError(err).Fatal("can't read auth configuration")
+			}
+		}
+
+		rates, err := limits.ParseRates(*rateLimits)
+		if err != nil {
+			log.WithError(err).Fatal("invalid rate limits")
+		}
+		lim := limits.New(rates, *maxAnnotationsPerContent, int64(*maxRequestBodyBytes))
+
+		var notifier *webhooks.Notifier
+		if *webhooksConfigPath != "" {
+			notifier, err = webhooks.LoadNotifier(*webhooksConfigPath, log)
+			if err != nil {
+				log.WithError(err).Fatal("can't read webhooks configuration")
+			}
+		}
+
+		var stream *changes.Stream
+		if *changeStreamBufferSize > 0 {
+			stream = changes.NewStream(*changeStreamBufferSize, annotationsReader(annotationsService), log)
+		}
+
+		var auditLog *audit.Log
+		if *auditSink != "" {
+			sink, sinkErr := setupAuditSink(*auditSink, db, *auditFilePath, int64(*auditFileMaxBytes), *auditFileMaxFiles, *brokerAddress, *auditTopic)
+			if sinkErr != nil {
+				log.WithError(sinkErr).Fatal("can't initialise audit log")
 			}
+			auditLog = audit.New(sink, log)
+		}
+
+		var jobPool *jobs.Pool
+		if *asyncWorkers > 0 {
+			jobPool = jobs.NewPool(*asyncWorkers, *asyncQueueSize, time.Duration(*jobRetention)*time.Second)
 		}
 
 		hh := httpHandler{
 			annotationsService: annotationsService,
 			forwarder:          f,
-			originMap:          originMap,
-			lifecycleMap:       lifecycleMap,
+			config:             liveConf,
+			configReloader:     reloader,
 			messageType:        messageType,
 			log:                log,
+			apiSpec:            spec,
+			jsonLDContextURL:   *jsonLDContextURL,
+			guard:              guard,
+			limits:             lim,
+			consuming:          *shouldConsumeMessages,
+			jobs:               jobPool,
+			webhooks:           notifier,
+			changes:            stream,
+			audit:              auditLog,
+			metrics:            m,
+			cacheMaxAge:        readCache.TTL(),
+			maintenance:        maintenanceMode,
 		}
 
-		var qh queueHandler
+		qh := &queueHandler{}
 		if *shouldConsumeMessages {
 			var consumer kafka.Consumer
 			consumer, err = setupMessageConsumer(*zookeeperAddress, *consumerGroup, *consumerTopic)
@@ -150,35 +488,102 @@ func main() {
 			}
 			healtcheckHandler.consumer = consumer
 
-			qh = queueHandler{
+			qh = &queueHandler{
 				annotationsService: annotationsService,
 				consumer:           consumer,
 				forwarder:          f,
-				originMap:          originMap,
-				lifecycleMap:       lifecycleMap,
+				config:             liveConf,
 				messageType:        messageType,
 				log:                log,
+				limits:             lim,
+				webhooks:           notifier,
+				changes:            stream,
+				audit:              auditLog,
+				metrics:            m,
+				breaker:            neoBreaker,
+				maintenance:        maintenanceMode,
 			}
 
 			qh.Ingest()
 		}
 
-		http.Handle("/", router(&hh, &healtcheckHandler, log))
+		srv := &http.Server{
+			Addr:    fmt.Sprintf(":%d", *port),
+			Handler: router(&hh, &healtcheckHandler, log),
+		}
+		// change streams never go idle, so they are ended for the server to shut down
+		srv.RegisterOnShutdown(stream.Close)
 
 		go func() {
-			err = startServer(*port)
+			err = startServer(srv)
 			if err != nil {
 				log.WithError(err).Fatal("http server error occurred")
 			}
 		}()
 
+		stopWatching := make(chan struct{})
+		go reloader.Watch(time.Duration(*configReloadInterval)*time.Second, stopWatching)
+		if roundTrip != nil {
+			go roundTrip.Watch(time.Duration(*canaryInterval)*time.Second, stopWatching, maintenanceMode.enabled)
+		}
+
 		waitForSignal()
-		if *shouldConsumeMessages {
-			log.Infof("Shutting down Kafka consumer")
-			qh.consumer.Shutdown()
+		close(stopWatching)
+		sequence := shutdownSequence{
+			log:            log,
+			state:          state,
+			readinessDelay: time.Duration(*readinessDrainDelay) * time.Second,
+			timeout:        time.Duration(*shutdownTimeout) * time.Second,
+			server:         srv,
+			queue:          qh,
+			closeNeo:       closeNeo,
+			flushTraces:    shutdownTracing,
 		}
+		sequence.withComponents(jobPool, qh.consumer, notifier, producer, cacheChannel, auditLog).run()
 	}
 
+	app.Command("export-rdf", "Write every annotation in a lifecycle as RDF", func(cmd *cli.Cmd) {
+		lifecycle := cmd.String(cli.StringOpt{
+			Name: "lifecycle",
+			Desc: "Annotation lifecycle to export, e.g. annotations-v1",
+		})
+		format := cmd.String(cli.StringOpt{
+			Name:  "format",
+			Value: linkeddata.NTriples,
+			Desc:  "RDF format (ntriples, turtle)",
+		})
+		output := cmd.String(cli.StringOpt{
+			Name: "output",
+			Desc: "File to write the RDF to, instead of the standard output",
+		})
+
+		cmd.Action = func() {
+			logConf := logger.KeyNamesConfig{KeyTime: "@time"}
+			log := logger.NewUPPLogger(*appName, *logLevel, logConf)
+			log.SetOutput(os.Stderr)
+
+			err := exportRDF(*neoURL, *batchSize, *config, *lifecycle, *format, *output)
+			if err != nil {
+				log.WithError(err).Fatal("RDF export failed")
+			}
+		}
+	})
+
+	app.Command("validate-config", "Validate lifecycle configuration files", func(cmd *cli.Cmd) {
+		cmd.Spec = "[FILE...]"
+		files := cmd.StringsArg("FILE", nil, "Lifecycle configuration files, or Helm values files (.yaml) setting env.LIFECYCLE_CONFIG_PATH. Defaults to --lifecycleConfigPath")
+
+		cmd.Action = func() {
+			paths := *files
+			if len(paths) == 0 {
+				paths = []string{*config}
+			}
+			if !validateConfigFiles(os.Stdout, paths) {
+				cli.Exit(1)
+			}
+		}
+	})
+
 	err := app.Run(os.Args)
 	if err != nil {
 		fmt.Printf("app could not start: %s", err)
@@ -186,22 +591,83 @@ func main() {
 	}
 }
 
-func setupAnnotationsService(neoURL string, bathSize int) (annotations.Service, error) {
+// connectNeo connects to Neo4j, returning the connection along with a function closing it
+func connectNeo(neoURL string, batchSize int) (neoutils.NeoConnection, func(), error) {
 	conf := neoutils.DefaultConnectionConfig()
-	conf.BatchSize = bathSize
+	conf.BatchSize = batchSize
 	db, err := neoutils.Connect(neoURL, conf)
-
 	if err != nil {
-		return nil, fmt.Errorf("error connecting to Neo4j: %w", err)
+		return nil, nil, fmt.Errorf("error connecting to Neo4j: %w", err)
 	}
+	return db, conf.HTTPClient.CloseIdleConnections, nil
+}
 
-	annotationsService := annotations.NewCypherAnnotationsService(db)
-	err = annotationsService.Initialise()
+// setupAnnotationsService returns the initialised annotations service
+func setupAnnotationsService(db neoutils.NeoConnection, resolveEquivalentConcepts bool, opts ...annotations.ServiceOption) (annotations.Service, error) {
+	if resolveEquivalentConcepts {
+		opts = append(opts, annotations.WithEquivalenceResolver(annotations.NewNeoEquivalenceResolver(db)))
+	}
+
+	annotationsService := annotations.NewCypherAnnotationsService(db, opts...)
+	err := annotationsService.Initialise()
 	if err != nil {
 		return nil, fmt.Errorf("annotations service has not been initialised correctly: %w", err)
 	}
 
-	return annotations.NewCypherAnnotationsService(db), nil
+	return annotationsService, nil
+}
+
+// setupAuditSink returns the sink of the audit log of the given kind: file, neo4j or kafka
+func setupAuditSink(kind string, db neoutils.NeoConnection, filePath string, fileMaxBytes int64, fileMaxFiles int, brokerAddress string, topic string) (audit.Sink, error) {
+	switch kind {
+	case "file":
+		return audit.NewFileSink(filePath, fileMaxBytes, fileMaxFiles)
+	case "neo4j":
+		return audit.NewNeo4jSink(db)
+	case "kafka":
+		producer, err := setupMessageProducer(brokerAddress, topic)
+		if err != nil {
+			return nil, err
+		}
+		return audit.NewKafkaSink(producer), nil
+	default:
+		return nil, fmt.Errorf("unknown audit sink %q, it should be file, neo4j or kafka", kind)
+	}
+}
+
+func exportRDF(neoURL string, batchSize int, configPath string, lifecycle string, format string, output string) error {
+	_, lifecycleMap, _, err := readConfigMap(configPath)
+	if err != nil {
+		return err
+	}
+	if _, ok := lifecycleMap[lifecycle]; !ok {
+		return fmt.Errorf("annotation lifecycle %q is not configured", lifecycle)
+	}
+	mediaType, err := linkeddata.MediaTypeOf(format)
+	if err != nil {
+		return err
+	}
+
+	db, _, err := connectNeo(neoURL, batchSize)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if output != "" {
+		if out, err = os.Create(output); err != nil {
+			return fmt.Errorf("error creating output file: %w", err)
+		}
+		defer out.Close()
+	}
+
+	rw, err := linkeddata.NewRDFWriter(out, mediaType)
+	if err != nil {
+		return err
+	}
+	return annotations.NewCypherAnnotationsService(db).Export(lifecycle, func(content annotations.ContentAnnotations) error {
+		return rw.Write(lifecycle, content)
+	})
 }
 
 func setupMessageProducer(brokerAddress string, producerTopic string) (kafka.Producer, error) {
@@ -212,6 +678,25 @@ func setupMessageProducer(brokerAddress string, producerTopic string) (kafka.Pro
 	return producer, nil
 }
 
+// setupCacheChannel returns the cache invalidation channel of the instance, consuming the topic in a consumer group
+// named after the instance for it to receive every invalidation
+func setupCacheChannel(brokerAddress string, zookeeperAddress string, appName string, topic string, log *logger.UPPLogger) (*cache.KafkaChannel, error) {
+	instance, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("cannot identify the instance: %w", err)
+	}
+	producer, err := setupMessageProducer(brokerAddress, topic)
+	if err != nil {
+		return nil, err
+	}
+	consumer, err := setupMessageConsumer(zookeeperAddress, appName+"-cache-"+instance, topic)
+	if err != nil {
+		producer.Shutdown()
+		return nil, err
+	}
+	return cache.NewKafkaChannel(producer, consumer, instance, log), nil
+}
+
 func setupMessageConsumer(zookeeperAddress string, consumerGroup string, topic string) (kafka.Consumer, error) {
 	// discard the output of zookeeper library
 	noneLogger := logger.NewUPPInfoLogger("annotations-rw-neo4j-kafka-consumer")
@@ -234,56 +719,91 @@ func setupMessageConsumer(zookeeperAddress string, consumerGroup string, topic s
 }
 
 func readConfigMap(jsonPath string) (originMap map[string]string, lifecycleMap map[string]string, messageType string, err error) {
-
-	file, err := ioutil.ReadFile(jsonPath)
+	c, err := readLifecycleConfig(jsonPath)
 	if err != nil {
-		return nil, nil, "", fmt.Errorf("error reading configuration file: %w", err)
+		return nil, nil, "", err
+	}
+	return c.OriginMap, c.LifecycleMap, c.MessageType, nil
+}
+
+// annotationsReader reads the annotations of a piece of content for the change stream
+func annotationsReader(service annotations.Service) changes.Reader {
+	return func(uuid string, tid string, lifecycle string) (annotations.Annotations, error) {
+		thing, found, err := service.Read(uuid, tid, lifecycle, annotations.ReadFilter{})
+		if err != nil || !found {
+			return nil, err
+		}
+		return thing.(annotations.Annotations), nil
 	}
+}
 
-	type config struct {
-		OriginMap    map[string]string `json:"originMap"`
-		LifecycleMap map[string]string `json:"lifecycleMap"`
-		MessageType  string            `json:"messageType"`
+// readBefore reads the annotations a write or delete replaces, when the change stream or the audit log record them.
+// It returns nil when they are not recorded or cannot be read.
+func readBefore(service annotations.Service, recorded bool, log *logger.UPPLogger, uuid string, tid string, lifecycle string) annotations.Annotations {
+	if !recorded {
+		return nil
 	}
-	var c config
-	err = json.Unmarshal(file, &c)
+	anns, err := annotationsReader(service)(uuid, tid, lifecycle)
 	if err != nil {
-		return nil, nil, "", fmt.Errorf("error marshalling config file: %w", err)
+		log.WithTransactionID(tid).WithUUID(uuid).WithError(err).Warn("Failed to read the annotations before a change, the change stream will list them all as added and the audit log as unknown")
+		return nil
 	}
-
-	if c.MessageType == "" {
-		return nil, nil, "", fmt.Errorf("message type is not configured: %w", errors.New("empty message type"))
+	if anns == nil {
+		return annotations.Annotations{}
 	}
-
-	return c.OriginMap, c.LifecycleMap, c.MessageType, nil
+	return anns
 }
 
 func router(hh *httpHandler, hc *healthCheckHandler, log *logger.UPPLogger) http.Handler {
+	var monitoringRouter http.Handler = newServicesRouter(hh, hc)
+	monitoringRouter = httphandlers.TransactionAwareRequestLoggingHandler(log, monitoringRouter)
+	monitoringRouter = httphandlers.HTTPMetricsHandler(metrics.DefaultRegistry, monitoringRouter)
+
+	return monitoringRouter
+}
+
+func newServicesRouter(hh *httpHandler, hc *healthCheckHandler) *mux.Router {
 	servicesRouter := mux.NewRouter()
 	servicesRouter.Headers("Content-type: application/json")
+	servicesRouter.Use(tracing.Middleware)
+	if hh.guard != nil {
+		servicesRouter.Use(hh.authorise)
+	}
 
 	// Then API specific ones:
 	servicesRouter.HandleFunc("/content/{uuid}/annotations/{annotationLifecycle}", hh.GetAnnotations).Methods("GET")
 	servicesRouter.HandleFunc("/content/{uuid}/annotations/{annotationLifecycle}", hh.PutAnnotations).Methods("PUT")
 	servicesRouter.HandleFunc("/content/{uuid}/annotations/{annotationLifecycle}", hh.DeleteAnnotations).Methods("DELETE")
 	servicesRouter.HandleFunc("/content/annotations/{annotationLifecycle}/__count", hh.CountAnnotations).Methods("GET")
+	servicesRouter.HandleFunc("/content/annotations/{annotationLifecycle}/__export", hh.ExportAnnotations).Methods("GET")
+	servicesRouter.HandleFunc("/__context.jsonld", hh.GetJSONLDContext).Methods("GET")
+	servicesRouter.HandleFunc("/__lifecycles", hh.GetLifecycles).Methods("GET")
+	servicesRouter.HandleFunc("/__changes/stream", hh.StreamChanges).Methods("GET")
+	servicesRouter.HandleFunc("/__audit/{uuid}", hh.GetAuditRecords).Methods("GET")
+	servicesRouter.HandleFunc("/__jobs", hh.ListJobs).Methods("GET")
+	servicesRouter.HandleFunc("/__jobs/{id}", hh.GetJob).Methods("GET")
+	servicesRouter.HandleFunc("/__reload-config", hh.ReloadConfig).Methods("POST")
+	servicesRouter.HandleFunc("/__maintenance", hh.GetMaintenance).Methods("GET")
+	servicesRouter.HandleFunc("/__maintenance", hh.SetMaintenance).Methods("PUT")
+	servicesRouter.HandleFunc("/__webhooks", hh.GetWebhooks).Methods("GET")
+	servicesRouter.HandleFunc("/__webhooks/dead-letters", hh.GetWebhookDeadLetters).Methods("GET")
 
 	servicesRouter.HandleFunc("/__health", hc.Health()).Methods("GET")
+	servicesRouter.HandleFunc("/metrics", hh.GetMetrics).Methods("GET")
 	servicesRouter.HandleFunc("/__gtg", status.NewGoodToGoHandler(hc.GTG)).Methods("GET")
 	servicesRouter.HandleFunc(status.PingPath, status.PingHandler).Methods("GET")
 	servicesRouter.HandleFunc(status.PingPathDW, status.PingHandler).Methods("GET")
 	servicesRouter.HandleFunc(status.BuildInfoPath, status.BuildInfoHandler).Methods("GET")
 	servicesRouter.HandleFunc(status.BuildInfoPathDW, status.BuildInfoHandler).Methods("GET")
+	if hh.apiSpec != nil {
+		servicesRouter.Handle(apiPath, hh.apiSpec).Methods("GET")
+	}
 
-	var monitoringRouter http.Handler = servicesRouter
-	monitoringRouter = httphandlers.TransactionAwareRequestLoggingHandler(log, monitoringRouter)
-	monitoringRouter = httphandlers.HTTPMetricsHandler(metrics.DefaultRegistry, monitoringRouter)
-
-	return monitoringRouter
+	return servicesRouter
 }
 
-func startServer(port int) error {
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), nil); err != nil {
+func startServer(srv *http.Server) error {
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("unable to start server: %w", err)
 	}
 	return nil
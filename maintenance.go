@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maintenanceStatus is whether the service is in maintenance mode, since when and why
+type maintenanceStatus struct {
+	Enabled bool       `json:"enabled"`
+	Since   *time.Time `json:"since,omitempty"`
+	Reason  string     `json:"reason,omitempty"`
+}
+
+// maintenanceMode makes the service read-only while Neo4j is maintained or migrated: writes and deletes are rejected
+// and the consumer pauses, while reads keep being served. A nil *maintenanceMode is never enabled.
+type maintenanceMode struct {
+	mu     sync.Mutex
+	status maintenanceStatus
+	// off is closed when maintenance mode is disabled, releasing the writes waiting for it
+	off chan struct{}
+}
+
+func newMaintenanceMode() *maintenanceMode {
+	off := make(chan struct{})
+	close(off)
+	return &maintenanceMode{off: off}
+}
+
+// set enables or disables maintenance mode, returning whether it changed. The reason is kept while it is enabled.
+func (m *maintenanceMode) set(enabled bool, reason string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if enabled == m.status.Enabled {
+		if enabled {
+			m.status.Reason = reason
+		}
+		return false
+	}
+	if enabled {
+		now := time.Now().UTC()
+		m.status = maintenanceStatus{Enabled: true, Since: &now, Reason: reason}
+		m.off = make(chan struct{})
+	} else {
+		m.status = maintenanceStatus{}
+		close(m.off)
+	}
+	return true
+}
+
+func (m *maintenanceMode) get() maintenanceStatus {
+	if m == nil {
+		return maintenanceStatus{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+func (m *maintenanceMode) enabled() bool {
+	return m.get().Enabled
+}
+
+// wait blocks until maintenance mode is disabled or the context is done
+func (m *maintenanceMode) wait(ctx context.Context) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	off := m.off
+	m.mu.Unlock()
+	select {
+	case <-off:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/auth"
+
+	logger "github.com/Financial-Times/go-logger/v2"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	m := newMaintenanceMode()
+	assert.NoError(t, m.wait(context.Background()), "Waiting should not block while maintenance mode is disabled")
+
+	assert.True(t, m.set(true, "Neo4j migration"))
+	assert.False(t, m.set(true, "Neo4j upgrade"), "Enabling maintenance mode again should only change the reason")
+	status := m.get()
+	assert.True(t, status.Enabled)
+	assert.Equal(t, "Neo4j upgrade", status.Reason)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, m.wait(ctx), "Waiting should block while maintenance mode is enabled")
+
+	released := make(chan error)
+	go func() { released <- m.wait(context.Background()) }()
+	assert.True(t, m.set(false, ""))
+	assert.NoError(t, <-released, "Disabling maintenance mode should release the writes waiting")
+	assert.Equal(t, maintenanceStatus{}, m.get())
+
+	var disabled *maintenanceMode
+	assert.False(t, disabled.enabled())
+	assert.NoError(t, disabled.wait(context.Background()))
+}
+
+func TestMaintenanceEndpoint(t *testing.T) {
+	log := logger.NewUPPInfoLogger("annotations-rw")
+	hh := &httpHandler{log: log, maintenance: newMaintenanceMode()}
+	handler := router(hh, &healthCheckHandler{}, log)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/__maintenance", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"enabled": false}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/__maintenance", strings.NewReader(`{"enabled": true, "reason": "Neo4j migration"}`)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"reason":"Neo4j migration"`)
+	assert.True(t, hh.maintenance.enabled())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/__maintenance", strings.NewReader(`{"enabled": "yes"}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.True(t, hh.maintenance.enabled(), "An invalid request should not change maintenance mode")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/__maintenance", strings.NewReader(`{"enabled": false}`)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"enabled": false}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	router(&httpHandler{log: log}, &healthCheckHandler{}, log).ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/__maintenance", strings.NewReader(`{"enabled": true}`)))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestMaintenanceEndpointNeedsTheAdminGrant(t *testing.T) {
+	log := logger.NewUPPInfoLogger("annotations-rw")
+	guard, err := newTestGuard()
+	require.NoError(t, err)
+	hh := &httpHandler{log: log, maintenance: newMaintenanceMode(), guard: guard}
+	handler := router(hh, &healthCheckHandler{}, log)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/__maintenance", strings.NewReader(`{"enabled": true}`)))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "Toggling maintenance mode should need credentials")
+	assert.False(t, hh.maintenance.enabled())
+
+	request := httptest.NewRequest(http.MethodPut, "/__maintenance", strings.NewReader(`{"enabled": true}`))
+	request.Header.Set(auth.APIKeyHeader, "methode-key")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, request)
+	assert.Equal(t, http.StatusForbidden, rec.Code, "Toggling maintenance mode should need the admin grant")
+	assert.False(t, hh.maintenance.enabled())
+
+	request = httptest.NewRequest(http.MethodPut, "/__maintenance", strings.NewReader(`{"enabled": true}`))
+	request.Header.Set(auth.APIKeyHeader, "ops-key")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, request)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, hh.maintenance.enabled())
+}
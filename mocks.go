@@ -27,8 +27,8 @@ func (as *mockAnnotationsService) Write(contentUUID string, annotationLifecycle
 	args := as.Called(contentUUID, annotationLifecycle, platformVersion, tid, thing)
 	return args.Error(0)
 }
-func (as *mockAnnotationsService) Read(contentUUID string, tid string, annotationLifecycle string) (thing interface{}, found bool, err error) {
-	args := as.Called(contentUUID, tid, annotationLifecycle)
+func (as *mockAnnotationsService) Read(contentUUID string, tid string, annotationLifecycle string, filter annotations.ReadFilter) (thing interface{}, found bool, err error) {
+	args := as.Called(contentUUID, tid, annotationLifecycle, filter)
 	return args.Get(0), args.Bool(1), args.Error(2)
 }
 func (as *mockAnnotationsService) Delete(contentUUID string, tid string, annotationLifecycle string) (found bool, err error) {
@@ -39,6 +39,10 @@ func (as *mockAnnotationsService) Check() (err error) {
 	args := as.Called()
 	return args.Error(0)
 }
+func (as *mockAnnotationsService) CheckReadable() (err error) {
+	args := as.Called()
+	return args.Error(0)
+}
 func (as *mockAnnotationsService) DecodeJSON(decoder *json.Decoder) (thing interface{}, err error) {
 	args := as.Called(decoder)
 	return args.Get(0), args.Error(1)
@@ -47,6 +51,17 @@ func (as *mockAnnotationsService) Count(annotationLifecycle string, platformVers
 	args := as.Called(annotationLifecycle, platformVersion)
 	return args.Int(0), args.Error(1)
 }
+func (as *mockAnnotationsService) Export(annotationLifecycle string, handle func(annotations.ContentAnnotations) error) error {
+	args := as.Called(annotationLifecycle, handle)
+	if contents, ok := args.Get(0).([]annotations.ContentAnnotations); ok {
+		for _, content := range contents {
+			if err := handle(content); err != nil {
+				return err
+			}
+		}
+	}
+	return args.Error(1)
+}
 func (as *mockAnnotationsService) Initialise() error {
 	args := as.Called()
 	return args.Error(0)
@@ -67,3 +82,18 @@ func (mc mockConsumer) Shutdown() {
 func (mc mockConsumer) ConnectivityCheck() error {
 	return mc.err
 }
+
+type mockProducer struct {
+	err error
+}
+
+func (mp mockProducer) SendMessage(message kafka.FTMessage) error {
+	return mp.err
+}
+
+func (mp mockProducer) ConnectivityCheck() error {
+	return mp.err
+}
+
+func (mp mockProducer) Shutdown() {
+}
@@ -1,22 +1,39 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"sync"
+	"time"
 
 	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/audit"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/breaker"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/changes"
 	"github.com/Financial-Times/annotations-rw-neo4j/v4/forwarder"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/limits"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/stats"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/tracing"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/webhooks"
 
 	logger "github.com/Financial-Times/go-logger/v2"
 	"github.com/Financial-Times/kafka-client-go/kafka"
 	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
 
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Note: this will only work for annotation messages, and not for suggestion
 // because suggestions-rw-neo4j has in its config shouldConsumeMessages set to false
 // and therefore the code bellow is not executed
 
+// messageTimestampFormat is the format of the Message-Timestamp header
+const messageTimestampFormat = "2006-01-02T15:04:05.000Z0700"
+
+// errAbandoned is returned for a message that stopped waiting to be written because the service is shutting down
+var errAbandoned = errors.New("message abandoned as the service is shutting down")
+
 type queueMessage struct {
 	UUID        string
 	Annotations annotations.Annotations
@@ -26,59 +43,179 @@ type queueHandler struct {
 	annotationsService annotations.Service
 	consumer           kafka.Consumer
 	forwarder          forwarder.QueueForwarder
-	originMap          map[string]string
-	lifecycleMap       map[string]string
+	config             *liveConfig
 	messageType        string
 	log                *logger.UPPLogger
+	limits             *limits.Limits
+	webhooks           *webhooks.Notifier
+	changes            *changes.Stream
+	audit              *audit.Log
+	metrics            *stats.Metrics
+	breaker            *breaker.Breaker
+	maintenance        *maintenanceMode
+	inFlight           sync.WaitGroup
+	waits              context.Context
+	stopWaiting        context.CancelFunc
 }
 
 func (qh *queueHandler) Ingest() {
+	qh.waits, qh.stopWaiting = context.WithCancel(context.Background())
 	qh.consumer.StartListening(func(message kafka.FTMessage) error {
-		tid, found := message.Headers[transactionidutils.TransactionIDHeader]
-		if !found {
-			return errors.New("Missing transaction id from message")
+		qh.inFlight.Add(1)
+		outcome, err := qh.process(message)
+		if err == errAbandoned {
+			// the consumer commits every message the handler returns from, even when it fails, so the handler never
+			// returns and the message is redelivered once the service restarts
+			qh.inFlight.Done()
+			select {}
 		}
+		qh.metrics.Consumed(outcome)
+		qh.inFlight.Done()
+		return err
+	})
+}
 
-		originSystem, found := message.Headers["Origin-System-Id"]
-		if !found {
-			return errors.New("Missing Origini-System-Id header from message")
-		}
+// process writes and forwards the annotations of a message, returning the outcome of the message for the metrics.
+// The message is traced as part of the trace context in its headers.
+func (qh *queueHandler) process(message kafka.FTMessage) (outcome string, err error) {
+	if published, parseErr := time.Parse(messageTimestampFormat, message.Headers["Message-Timestamp"]); parseErr == nil {
+		qh.metrics.MessageAge(time.Since(published))
+	}
 
-		lifecycle, platformVersion, err := qh.getSourceFromHeader(originSystem)
-		if err != nil {
-			return err
-		}
+	ctx, span := tracing.Start(tracing.Extract(context.Background(), message.Headers), "queueHandler.process", trace.WithSpanKind(trace.SpanKindConsumer))
+	defer func() { tracing.End(span, err) }()
 
-		annMsg := new(queueMessage)
-		err = json.Unmarshal([]byte(message.Body), &annMsg)
-		if err != nil {
-			return errors.Errorf("Cannot process received message %s", tid)
-		}
+	tid, found := message.Headers[transactionidutils.TransactionIDHeader]
+	if !found {
+		return stats.Invalid, errors.New("Missing transaction id from message")
+	}
+	span.SetAttributes(tracing.TransactionID(tid))
 
-		err = qh.annotationsService.Write(annMsg.UUID, lifecycle, platformVersion, tid, annMsg.Annotations)
-		if err != nil {
-			qh.log.WithMonitoringEvent("SaveNeo4j", tid, qh.messageType).WithUUID(annMsg.UUID).WithError(err).Error("Cannot write to Neo4j")
-			return errors.Wrapf(err, "Failed to write message with tid=%s and uuid=%s", tid, annMsg.UUID)
-		}
+	originSystem, found := message.Headers["Origin-System-Id"]
+	if !found {
+		return stats.Invalid, errors.New("Missing Origini-System-Id header from message")
+	}
+
+	lifecycle, platformVersion, err := qh.getSourceFromHeader(originSystem)
+	if err != nil {
+		return stats.Invalid, err
+	}
 
-		qh.log.WithMonitoringEvent("SaveNeo4j", tid, qh.messageType).WithUUID(annMsg.UUID).Infof("%s successfully written in Neo4j", qh.messageType)
+	annMsg := new(queueMessage)
+	err = json.Unmarshal([]byte(message.Body), &annMsg)
+	if err != nil {
+		qh.metrics.ValidationFailed("invalid_json")
+		return stats.Invalid, errors.Errorf("Cannot process received message %s", tid)
+	}
+
+	// messages cannot be refused, so the rate limits and quotas slow down consumption instead
+	weight := qh.limits.Weight(len(annMsg.Annotations), len(message.Body))
+	if weight > 1 {
+		qh.log.WithTransactionID(tid).WithUUID(annMsg.UUID).Warnf("Message exceeds the annotation quotas, it counts as %d requests against the rate limits", weight)
+	}
+	if err = qh.limits.Wait(qh.waits, weight, lifecycle, originSystem); err != nil {
+		qh.log.WithTransactionID(tid).WithUUID(annMsg.UUID).Warn("Shutting down while the message waits on the rate limits, leaving it to be redelivered")
+		return "", errAbandoned
+	}
 
-		//forward message to the next queue
-		if qh.forwarder != nil {
-			qh.log.WithTransactionID(tid).WithUUID(annMsg.UUID).Debug("Forwarding message to the next queue")
-			return qh.forwarder.SendMessage(tid, originSystem, platformVersion, annMsg.UUID, annMsg.Annotations)
+	// messages should not fail while Neo4j is unavailable either, so consumption pauses while the circuit breaker is open
+	// or the service is in maintenance mode instead
+	service := annotations.WithContext(ctx, qh.annotationsService)
+	var before annotations.Annotations
+	for {
+		if qh.maintenance.enabled() {
+			qh.log.WithTransactionID(tid).WithUUID(annMsg.UUID).Warn("Service is in maintenance mode, pausing consumption until it is disabled")
 		}
-		return nil
+		if err = qh.maintenance.wait(qh.waits); err != nil {
+			qh.log.WithTransactionID(tid).WithUUID(annMsg.UUID).Warn("Shutting down while the message is paused in maintenance mode, leaving it to be redelivered")
+			return "", errAbandoned
+		}
+		if state, _ := qh.breaker.State(); state != breaker.Closed {
+			qh.log.WithTransactionID(tid).WithUUID(annMsg.UUID).Warnf("Neo4j circuit breaker is %s, pausing consumption until it lets writes through", state)
+		}
+		if err = qh.breaker.Wait(qh.waits); err != nil {
+			qh.log.WithTransactionID(tid).WithUUID(annMsg.UUID).Warn("Shutting down while the message waits on the circuit breaker, leaving it to be redelivered")
+			return "", errAbandoned
+		}
+		before = readBefore(service, qh.changes != nil || qh.audit != nil, qh.log, annMsg.UUID, tid, lifecycle)
+		err = service.Write(annMsg.UUID, lifecycle, platformVersion, tid, annMsg.Annotations)
+		if _, open := breaker.RetryAfter(err); !open {
+			break
+		}
+	}
+	if err != nil {
+		qh.log.WithMonitoringEvent("SaveNeo4j", tid, qh.messageType).WithUUID(annMsg.UUID).WithError(err).Error("Cannot write to Neo4j")
+		outcome = stats.Failed
+		if _, ok := err.(annotations.ValidationError); ok || err == annotations.UnsupportedPredicateErr {
+			outcome = stats.Invalid
+		}
+		return outcome, errors.Wrapf(err, "Failed to write message with tid=%s and uuid=%s", tid, annMsg.UUID)
+	}
+
+	qh.log.WithMonitoringEvent("SaveNeo4j", tid, qh.messageType).WithUUID(annMsg.UUID).Infof("%s successfully written in Neo4j", qh.messageType)
+	qh.audit.Record(audit.Record{
+		Action:        audit.Write,
+		Source:        audit.Kafka,
+		TransactionID: tid,
+		OriginSystem:  originSystem,
+		Lifecycle:     lifecycle,
+		UUID:          annMsg.UUID,
+		Before:        before,
+		After:         annMsg.Annotations,
+	})
+	qh.webhooks.Notify(webhooks.Event{
+		Type:            webhooks.Written,
+		UUID:            annMsg.UUID,
+		Lifecycle:       lifecycle,
+		PlatformVersion: platformVersion,
+		OriginSystem:    originSystem,
+		TransactionID:   tid,
+		Annotations:     annMsg.Annotations,
 	})
+	qh.changes.Written(annMsg.UUID, tid, lifecycle, before)
+
+	//forward message to the next queue
+	if qh.forwarder != nil {
+		qh.log.WithTransactionID(tid).WithUUID(annMsg.UUID).Debug("Forwarding message to the next queue")
+		return stats.Written, forwarder.WithContext(ctx, qh.forwarder).SendMessage(tid, originSystem, platformVersion, annMsg.UUID, annMsg.Annotations)
+	}
+	return stats.Written, nil
+}
+
+// StopWaiting ends the waits of the messages being processed, so they do not hold up the shutdown of the consumer.
+// Messages waiting on the rate limits, the circuit breaker or maintenance mode are abandoned: they are not committed, so
+// they are redelivered once the service restarts.
+func (qh *queueHandler) StopWaiting() {
+	if qh.stopWaiting != nil {
+		qh.stopWaiting()
+	}
+}
+
+// Drain waits for the messages being processed to be written and forwarded, or for the context to be done.
+// The consumer should be shut down first, so no new message starts processing.
+func (qh *queueHandler) Drain(ctx context.Context) error {
+	drained := make(chan struct{})
+	go func() {
+		qh.inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (qh *queueHandler) getSourceFromHeader(originSystem string) (string, string, error) {
-	annotationLifecycle, found := qh.originMap[originSystem]
+	config := qh.config.get()
+	annotationLifecycle, found := config.OriginMap[originSystem]
 	if !found {
 		return "", "", errors.Errorf("Annotation Lifecycle not found for origin system id: %s", originSystem)
 	}
 
-	platformVersion, found := qh.lifecycleMap[annotationLifecycle]
+	platformVersion, found := config.LifecycleMap[annotationLifecycle]
 	if !found {
 		return "", "", errors.Errorf("Platform version not found for origin system id: %s and annotation lifecycle: %s", originSystem, annotationLifecycle)
 	}
@@ -2,18 +2,31 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/audit"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/breaker"
 	"github.com/Financial-Times/annotations-rw-neo4j/v4/forwarder"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/limits"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/stats"
 
 	logger "github.com/Financial-Times/go-logger/v2"
 	"github.com/Financial-Times/kafka-client-go/kafka"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 )
 
+// errNeo4jTimeout is the error of a write that timed out reaching Neo4j, counted as a failure by the circuit breaker
+var errNeo4jTimeout = &url.Error{Op: "Post", URL: "http://localhost:7474/db/data/batch", Err: errors.New("i/o timeout")}
+
 type QueueHandlerTestSuite struct {
 	suite.Suite
 	headers            map[string]string
@@ -59,9 +72,26 @@ func (suite *QueueHandlerTestSuite) TestQueueHandler_Ingest() {
 		annotationsService: suite.annotationsService,
 		consumer:           mockConsumer{message: suite.message},
 		forwarder:          suite.forwarder,
-		originMap:          suite.originMap,
-		lifecycleMap:       suite.lifecycleMap,
+		config:             newLiveConfig(lifecycleConfig{OriginMap: suite.originMap, LifecycleMap: suite.lifecycleMap, MessageType: "Annotations"}),
+		log:                suite.log,
+	}
+	qh.Ingest()
+
+	suite.annotationsService.AssertCalled(suite.T(), "Write", suite.queueMessage.UUID, annotationLifecycle, platformVersion, suite.tid, suite.queueMessage.Annotations)
+	suite.forwarder.AssertCalled(suite.T(), "SendMessage", suite.tid, suite.originSystem, platformVersion, suite.queueMessage.UUID, suite.queueMessage.Annotations)
+}
+
+func (suite *QueueHandlerTestSuite) TestQueueHandler_Ingest_OverQuotaIsThrottledNotDropped() {
+	suite.annotationsService.On("Write", suite.queueMessage.UUID, annotationLifecycle, platformVersion, suite.tid, suite.queueMessage.Annotations).Return(nil)
+	suite.forwarder.On("SendMessage", suite.tid, suite.originSystem, platformVersion, suite.queueMessage.UUID, suite.queueMessage.Annotations).Return(nil)
+
+	qh := &queueHandler{
+		annotationsService: suite.annotationsService,
+		consumer:           mockConsumer{message: suite.message},
+		forwarder:          suite.forwarder,
+		config:             newLiveConfig(lifecycleConfig{OriginMap: suite.originMap, LifecycleMap: suite.lifecycleMap, MessageType: "Annotations"}),
 		log:                suite.log,
+		limits:             limits.New(map[string]limits.Rate{annotationLifecycle: {PerSecond: 1000, Burst: 10}}, 1, 10),
 	}
 	qh.Ingest()
 
@@ -69,6 +99,99 @@ func (suite *QueueHandlerTestSuite) TestQueueHandler_Ingest() {
 	suite.forwarder.AssertCalled(suite.T(), "SendMessage", suite.tid, suite.originSystem, platformVersion, suite.queueMessage.UUID, suite.queueMessage.Annotations)
 }
 
+func (suite *QueueHandlerTestSuite) TestQueueHandler_Ingest_PausedWhileBreakerOpen() {
+	suite.annotationsService.On("Write", suite.queueMessage.UUID, annotationLifecycle, platformVersion, suite.tid, suite.queueMessage.Annotations).Return(errNeo4jTimeout).Once()
+	suite.annotationsService.On("Write", suite.queueMessage.UUID, annotationLifecycle, platformVersion, suite.tid, suite.queueMessage.Annotations).Return(nil)
+	suite.forwarder.On("SendMessage", suite.tid, suite.originSystem, platformVersion, suite.queueMessage.UUID, suite.queueMessage.Annotations).Return(nil)
+
+	neoBreaker := breaker.New(1, 30*time.Millisecond, nil)
+	qh := &queueHandler{
+		annotationsService: neoBreaker.Service(suite.annotationsService),
+		consumer:           mockConsumer{message: suite.message},
+		forwarder:          suite.forwarder,
+		config:             newLiveConfig(lifecycleConfig{OriginMap: suite.originMap, LifecycleMap: suite.lifecycleMap, MessageType: "Annotations"}),
+		log:                suite.log,
+		breaker:            neoBreaker,
+	}
+	qh.Ingest()
+	state, _ := neoBreaker.State()
+	assert.Equal(suite.T(), breaker.Open, state)
+	suite.forwarder.AssertNotCalled(suite.T(), "SendMessage", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	start := time.Now()
+	qh.Ingest()
+	assert.True(suite.T(), time.Since(start) >= 25*time.Millisecond, "Consumption should pause until the breaker lets a write through")
+	suite.annotationsService.AssertNumberOfCalls(suite.T(), "Write", 2)
+	suite.forwarder.AssertNumberOfCalls(suite.T(), "SendMessage", 1)
+	state, _ = neoBreaker.State()
+	assert.Equal(suite.T(), breaker.Closed, state)
+}
+
+func (suite *QueueHandlerTestSuite) TestQueueHandler_Ingest_PausedInMaintenance() {
+	suite.annotationsService.On("Write", suite.queueMessage.UUID, annotationLifecycle, platformVersion, suite.tid, suite.queueMessage.Annotations).Return(nil)
+	suite.forwarder.On("SendMessage", suite.tid, suite.originSystem, platformVersion, suite.queueMessage.UUID, suite.queueMessage.Annotations).Return(nil)
+
+	maintenance := newMaintenanceMode()
+	maintenance.set(true, "Neo4j migration")
+	qh := &queueHandler{
+		annotationsService: suite.annotationsService,
+		consumer:           mockConsumer{message: suite.message},
+		forwarder:          suite.forwarder,
+		config:             newLiveConfig(lifecycleConfig{OriginMap: suite.originMap, LifecycleMap: suite.lifecycleMap, MessageType: "Annotations"}),
+		log:                suite.log,
+		maintenance:        maintenance,
+	}
+	done := make(chan struct{})
+	go func() {
+		qh.Ingest()
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	suite.annotationsService.AssertNotCalled(suite.T(), "Write", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	maintenance.set(false, "")
+	<-done
+	suite.annotationsService.AssertNumberOfCalls(suite.T(), "Write", 1)
+	suite.forwarder.AssertNumberOfCalls(suite.T(), "SendMessage", 1)
+}
+
+// recordingAuditSink keeps the audit records appended
+type recordingAuditSink struct {
+	audit.Sink
+	records []audit.Record
+}
+
+func (s *recordingAuditSink) Append(r audit.Record) error {
+	s.records = append(s.records, r)
+	return nil
+}
+
+func (suite *QueueHandlerTestSuite) TestQueueHandler_Ingest_Audited() {
+	before := annotations.Annotations{{Thing: annotations.Thing{ID: "http://api.ft.com/things/1"}}}
+	suite.annotationsService.On("Read", suite.queueMessage.UUID, suite.tid, annotationLifecycle, annotations.ReadFilter{}).Return(before, true, nil)
+	suite.annotationsService.On("Write", suite.queueMessage.UUID, annotationLifecycle, platformVersion, suite.tid, suite.queueMessage.Annotations).Return(nil)
+
+	sink := &recordingAuditSink{}
+	qh := queueHandler{
+		annotationsService: suite.annotationsService,
+		consumer:           mockConsumer{message: suite.message},
+		config:             newLiveConfig(lifecycleConfig{OriginMap: suite.originMap, LifecycleMap: suite.lifecycleMap, MessageType: "Annotations"}),
+		log:                suite.log,
+		audit:              audit.New(sink, suite.log),
+	}
+	qh.Ingest()
+
+	if assert.Len(suite.T(), sink.records, 1) {
+		r := sink.records[0]
+		assert.Equal(suite.T(), audit.Write, r.Action)
+		assert.Equal(suite.T(), audit.Kafka, r.Source)
+		assert.Equal(suite.T(), suite.originSystem, r.OriginSystem)
+		assert.Empty(suite.T(), r.Caller)
+		assert.Equal(suite.T(), before, r.Before)
+		assert.Equal(suite.T(), suite.queueMessage.Annotations, r.After)
+	}
+}
+
 func (suite *QueueHandlerTestSuite) TestQueueHandler_Ingest_ProducerNil() {
 	suite.annotationsService.On("Write", suite.queueMessage.UUID, annotationLifecycle, platformVersion, suite.tid, suite.queueMessage.Annotations).Return(nil)
 
@@ -76,8 +199,7 @@ func (suite *QueueHandlerTestSuite) TestQueueHandler_Ingest_ProducerNil() {
 		annotationsService: suite.annotationsService,
 		consumer:           mockConsumer{message: suite.message},
 		forwarder:          nil,
-		originMap:          suite.originMap,
-		lifecycleMap:       suite.lifecycleMap,
+		config:             newLiveConfig(lifecycleConfig{OriginMap: suite.originMap, LifecycleMap: suite.lifecycleMap, MessageType: "Annotations"}),
 		log:                suite.log,
 	}
 	qh.Ingest()
@@ -94,8 +216,7 @@ func (suite *QueueHandlerTestSuite) TestQueueHandler_Ingest_JsonError() {
 		annotationsService: suite.annotationsService,
 		consumer:           mockConsumer{message: message},
 		forwarder:          suite.forwarder,
-		originMap:          suite.originMap,
-		lifecycleMap:       suite.lifecycleMap,
+		config:             newLiveConfig(lifecycleConfig{OriginMap: suite.originMap, LifecycleMap: suite.lifecycleMap, MessageType: "Annotations"}),
 		log:                suite.log,
 	}
 	qh.Ingest()
@@ -103,3 +224,28 @@ func (suite *QueueHandlerTestSuite) TestQueueHandler_Ingest_JsonError() {
 	suite.forwarder.AssertNumberOfCalls(suite.T(), "SendMessage", 0)
 	suite.annotationsService.AssertNumberOfCalls(suite.T(), "Write", 0)
 }
+
+func (suite *QueueHandlerTestSuite) TestQueueHandler_Ingest_Metrics() {
+	suite.annotationsService.On("Write", suite.queueMessage.UUID, annotationLifecycle, platformVersion, suite.tid, suite.queueMessage.Annotations).Return(nil)
+	suite.headers["Message-Timestamp"] = time.Now().Add(-time.Minute).Format(messageTimestampFormat)
+
+	m := stats.New()
+	for _, body := range []string{string(suite.body), "invalid json"} {
+		qh := &queueHandler{
+			annotationsService: suite.annotationsService,
+			consumer:           mockConsumer{message: kafka.NewFTMessage(suite.headers, body)},
+			config:             newLiveConfig(lifecycleConfig{OriginMap: suite.originMap, LifecycleMap: suite.lifecycleMap, MessageType: "Annotations"}),
+			log:                suite.log,
+			metrics:            m,
+		}
+		qh.Ingest()
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(suite.T(), rec.Body.String(), `annotations_rw_kafka_messages_consumed_total{outcome="written"} 1`)
+	assert.Contains(suite.T(), rec.Body.String(), `annotations_rw_kafka_messages_consumed_total{outcome="invalid"} 1`)
+	assert.Contains(suite.T(), rec.Body.String(), `annotations_rw_validation_failures_total{reason="invalid_json"} 1`)
+	assert.Contains(suite.T(), rec.Body.String(), `annotations_rw_kafka_message_age_seconds_bucket{le="30"} 0`)
+	assert.Contains(suite.T(), rec.Body.String(), `annotations_rw_kafka_message_age_seconds_bucket{le="300"} 2`)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/audit"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/cache"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/jobs"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/webhooks"
+
+	logger "github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/kafka"
+)
+
+// shutdownState records that the service has started shutting down, so __gtg can fail before anything stops
+type shutdownState struct {
+	started int32
+}
+
+func (s *shutdownState) begin() {
+	atomic.StoreInt32(&s.started, 1)
+}
+
+func (s *shutdownState) inProgress() bool {
+	return s != nil && atomic.LoadInt32(&s.started) == 1
+}
+
+type httpServer interface {
+	Shutdown(ctx context.Context) error
+}
+
+type drainer interface {
+	Drain(ctx context.Context) error
+}
+
+type stopper interface {
+	Shutdown()
+}
+
+type messageQueue interface {
+	drainer
+	StopWaiting()
+}
+
+type closer interface {
+	Close() error
+}
+
+// shutdownSequence stops the service in order: __gtg fails first and, after readinessDelay to let load balancers
+// notice, the server stops accepting requests and the consumer stops consuming, abandoning the messages waiting on the
+// rate limits, the circuit breaker or maintenance mode, uncommitted. In-flight requests, asynchronous writes and
+// messages are drained until timeout, then the webhook events left are delivered while the producer is flushed, the
+// cache invalidations left are published, the audit log and the Neo4j connection are closed, and the spans left are
+// exported.
+type shutdownSequence struct {
+	log            *logger.UPPLogger
+	state          *shutdownState
+	readinessDelay time.Duration
+	timeout        time.Duration
+	server         httpServer
+	jobs           drainer
+	consumer       stopper
+	queue          messageQueue
+	webhooks       drainer
+	producer       stopper
+	cacheChannel   stopper
+	audit          closer
+	closeNeo       func()
+	flushTraces    func(ctx context.Context) error
+}
+
+// withComponents returns a copy of the sequence stopping the optional components that are enabled. Disabled components
+// are nil pointers, left out so that the sequence does not see them as typed nils that are set.
+func (s shutdownSequence) withComponents(jobPool *jobs.Pool, consumer kafka.Consumer, notifier *webhooks.Notifier, producer kafka.Producer, cacheChannel *cache.KafkaChannel, auditLog *audit.Log) shutdownSequence {
+	if jobPool != nil {
+		s.jobs = jobPool
+	}
+	if consumer != nil {
+		s.consumer = consumer
+	}
+	if notifier != nil {
+		s.webhooks = notifier
+	}
+	if producer != nil {
+		s.producer = producer
+	}
+	if cacheChannel != nil {
+		s.cacheChannel = cacheChannel
+	}
+	if auditLog != nil {
+		s.audit = auditLog
+	}
+	return s
+}
+
+func (s shutdownSequence) run() {
+	s.log.Info("Shutting down, failing __gtg")
+	s.state.begin()
+	time.Sleep(s.readinessDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.log.Info("Draining in-flight HTTP requests")
+		if err := s.server.Shutdown(ctx); err != nil {
+			s.log.WithError(err).Warn("HTTP requests were still in flight at the end of the shutdown timeout")
+		}
+		if s.jobs != nil {
+			s.log.Info("Draining asynchronous writes")
+			if err := s.jobs.Drain(ctx); err != nil {
+				s.log.WithError(err).Warn("Asynchronous writes were still queued or running at the end of the shutdown timeout")
+			}
+		}
+	}()
+	if s.consumer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.log.Info("Shutting down Kafka consumer")
+			s.queue.StopWaiting()
+			s.consumer.Shutdown()
+			if err := s.queue.Drain(ctx); err != nil {
+				s.log.WithError(err).Warn("Messages were still in flight at the end of the shutdown timeout")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if s.webhooks != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.log.Info("Delivering webhook events")
+			if err := s.webhooks.Drain(ctx); err != nil {
+				s.log.WithError(err).Warn("Webhook events left at the end of the shutdown timeout were dead-lettered")
+			}
+		}()
+	}
+	if s.producer != nil {
+		s.log.Info("Flushing Kafka producer")
+		s.producer.Shutdown()
+	}
+	wg.Wait()
+	if s.cacheChannel != nil {
+		s.log.Info("Shutting down cache invalidation channel")
+		s.cacheChannel.Shutdown()
+	}
+	if s.audit != nil {
+		s.log.Info("Closing audit log")
+		if err := s.audit.Close(); err != nil {
+			s.log.WithError(err).Warn("Failed to close the audit log")
+		}
+	}
+	if s.closeNeo != nil {
+		s.log.Info("Closing Neo4j connection")
+		s.closeNeo()
+	}
+	if s.flushTraces != nil {
+		s.log.Info("Exporting traces")
+		if err := s.flushTraces(ctx); err != nil {
+			s.log.WithError(err).Warn("Failed to export the traces left")
+		}
+	}
+	s.log.Info("Shutdown complete")
+}
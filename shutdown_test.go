@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/breaker"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/limits"
+
+	logger "github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/kafka"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// shutdownRecorder records the steps of a shutdown sequence
+type shutdownRecorder struct {
+	sync.Mutex
+	steps []string
+}
+
+func (r *shutdownRecorder) record(step string) {
+	r.Lock()
+	defer r.Unlock()
+	r.steps = append(r.steps, step)
+}
+
+type recordedServer struct{ r *shutdownRecorder }
+
+func (s recordedServer) Shutdown(ctx context.Context) error {
+	s.r.record("server")
+	return nil
+}
+
+type recordedStopper struct {
+	r    *shutdownRecorder
+	name string
+}
+
+func (s recordedStopper) Shutdown() {
+	s.r.record(s.name)
+}
+
+func TestShutdownSequenceOrder(t *testing.T) {
+	state := &shutdownState{}
+	recorder := &shutdownRecorder{}
+	gtgFailedFirst := false
+
+	shutdownSequence{
+		log:          logger.NewUPPInfoLogger("annotations-rw"),
+		state:        state,
+		timeout:      time.Second,
+		server:       serverFunc(func() { gtgFailedFirst = state.inProgress(); recorder.record("server") }),
+		jobs:         recordedDrainer{recorder, "jobs"},
+		consumer:     recordedStopper{recorder, "consumer"},
+		queue:        &queueHandler{},
+		webhooks:     recordedDrainer{recorder, "webhooks"},
+		producer:     recordedStopper{recorder, "producer"},
+		cacheChannel: recordedStopper{recorder, "cache"},
+		audit:        recordedCloser{recorder, "audit"},
+		closeNeo:     func() { recorder.record("neo4j") },
+		flushTraces:  func(context.Context) error { recorder.record("traces"); return nil },
+	}.run()
+
+	assert.True(t, gtgFailedFirst, "__gtg should fail before the server stops")
+	assert.ElementsMatch(t, []string{"server", "jobs", "consumer"}, recorder.steps[:3], "The server and consumer should stop first")
+	assert.NotEqual(t, "jobs", recorder.steps[0], "Asynchronous writes should be drained once the server stops accepting them")
+	assert.ElementsMatch(t, []string{"webhooks", "producer"}, recorder.steps[3:5], "Webhook events should be delivered while the producer is flushed")
+	assert.Equal(t, []string{"cache", "audit", "neo4j", "traces"}, recorder.steps[5:], "The audit log should be closed once the last changes are recorded, and the traces exported last")
+}
+
+type recordedDrainer struct {
+	r    *shutdownRecorder
+	name string
+}
+
+func (d recordedDrainer) Drain(ctx context.Context) error {
+	d.r.record(d.name)
+	return nil
+}
+
+type recordedCloser struct {
+	r    *shutdownRecorder
+	name string
+}
+
+func (c recordedCloser) Close() error {
+	c.r.record(c.name)
+	return nil
+}
+
+type serverFunc func()
+
+func (f serverFunc) Shutdown(ctx context.Context) error {
+	f()
+	return nil
+}
+
+func TestShutdownSequenceWithoutKafka(t *testing.T) {
+	recorder := &shutdownRecorder{}
+	shutdownSequence{
+		log:      logger.NewUPPInfoLogger("annotations-rw"),
+		state:    &shutdownState{},
+		timeout:  time.Second,
+		server:   recordedServer{recorder},
+		queue:    &queueHandler{},
+		closeNeo: func() { recorder.record("neo4j") },
+	}.run()
+
+	assert.Equal(t, []string{"server", "neo4j"}, recorder.steps)
+}
+
+func TestShutdownSequenceWithOptionalComponentsDisabled(t *testing.T) {
+	recorder := &shutdownRecorder{}
+	sequence := shutdownSequence{
+		log:      logger.NewUPPInfoLogger("annotations-rw"),
+		state:    &shutdownState{},
+		timeout:  time.Second,
+		server:   recordedServer{recorder},
+		queue:    &queueHandler{},
+		closeNeo: func() { recorder.record("neo4j") },
+	}.withComponents(nil, nil, nil, nil, nil, nil)
+
+	assert.True(t, sequence.jobs == nil, "Disabled asynchronous writes should not be drained")
+	assert.True(t, sequence.consumer == nil, "A disabled consumer should not be shut down")
+	assert.True(t, sequence.webhooks == nil, "Disabled webhooks should not be delivered")
+	assert.True(t, sequence.producer == nil, "A disabled producer should not be flushed")
+	assert.True(t, sequence.cacheChannel == nil, "A disabled cache invalidation channel should not be shut down")
+	assert.True(t, sequence.audit == nil, "A disabled audit log should not be closed")
+
+	sequence.run()
+	assert.Equal(t, []string{"server", "neo4j"}, recorder.steps)
+}
+
+// backgroundConsumer hands its message to the handler in the background and, like the Kafka consumer, commits it once
+// the handler returns, whatever its error
+type backgroundConsumer struct {
+	mockConsumer
+	committed chan struct{}
+}
+
+func (c backgroundConsumer) StartListening(messageHandler func(message kafka.FTMessage) error) {
+	go func() {
+		messageHandler(c.message)
+		if c.committed != nil {
+			close(c.committed)
+		}
+	}()
+}
+
+func TestQueueHandlerDrainWaitsForInFlightMessages(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	service := new(mockAnnotationsService)
+	service.On("Write", "uuid", annotationLifecycle, platformVersion, "tid", mock.Anything).Run(func(args mock.Arguments) {
+		close(started)
+		<-release
+	}).Return(nil)
+
+	qh := &queueHandler{
+		annotationsService: service,
+		consumer: backgroundConsumer{mockConsumer: mockConsumer{message: kafka.NewFTMessage(
+			map[string]string{"X-Request-Id": "tid", "Origin-System-Id": "http://cmdb.ft.com/systems/methode-web-pub"},
+			`{"uuid":"uuid"}`)}},
+		config: newLiveConfig(lifecycleConfig{
+			OriginMap:    map[string]string{"http://cmdb.ft.com/systems/methode-web-pub": annotationLifecycle},
+			LifecycleMap: map[string]string{annotationLifecycle: platformVersion},
+		}),
+		log: logger.NewUPPInfoLogger("annotations-rw"),
+	}
+	qh.Ingest()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, qh.Drain(ctx), "Drain should time out while a message is in flight")
+
+	close(release)
+	assert.NoError(t, qh.Drain(context.Background()))
+}
+
+// newBackgroundQueueHandler returns a queue handler that processes a message in the background once it ingests
+func newBackgroundQueueHandler(service annotations.Service) *queueHandler {
+	return &queueHandler{
+		annotationsService: service,
+		consumer: backgroundConsumer{mockConsumer: mockConsumer{message: kafka.NewFTMessage(
+			map[string]string{"X-Request-Id": "tid", "Origin-System-Id": "http://cmdb.ft.com/systems/methode-web-pub"},
+			`{"uuid":"uuid"}`)}, committed: make(chan struct{})},
+		config: newLiveConfig(lifecycleConfig{
+			OriginMap:    map[string]string{"http://cmdb.ft.com/systems/methode-web-pub": annotationLifecycle},
+			LifecycleMap: map[string]string{annotationLifecycle: platformVersion},
+		}),
+		log: logger.NewUPPInfoLogger("annotations-rw"),
+	}
+}
+
+// assertAbandonedAtShutdown checks that the message the queue handler is waiting with does not hold up the shutdown,
+// and is left uncommitted so that it is redelivered once the service restarts
+func assertAbandonedAtShutdown(t *testing.T, qh *queueHandler, waitingOn string) {
+	time.Sleep(10 * time.Millisecond)
+	qh.StopWaiting()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, qh.Drain(ctx), "The message waiting on %s should not hold up the shutdown", waitingOn)
+
+	select {
+	case <-qh.consumer.(backgroundConsumer).committed:
+		assert.Fail(t, "The message waiting on "+waitingOn+" should not be committed")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestQueueHandlerStopWaitingAbandonsRateLimitedMessages(t *testing.T) {
+	service := new(mockAnnotationsService)
+	lim := limits.New(map[string]limits.Rate{annotationLifecycle: {PerSecond: 0.001, Burst: 1}}, 0, 0)
+	allowed, _ := lim.Allow(annotationLifecycle)
+	assert.True(t, allowed)
+
+	qh := newBackgroundQueueHandler(service)
+	qh.limits = lim
+	qh.Ingest()
+
+	assertAbandonedAtShutdown(t, qh, "the rate limits")
+	service.AssertNotCalled(t, "Write", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestQueueHandlerStopWaitingAbandonsMessagesPausedInMaintenance(t *testing.T) {
+	service := new(mockAnnotationsService)
+	maintenance := newMaintenanceMode()
+	maintenance.set(true, "Neo4j migration")
+
+	qh := newBackgroundQueueHandler(service)
+	qh.maintenance = maintenance
+	qh.Ingest()
+
+	assertAbandonedAtShutdown(t, qh, "maintenance mode")
+	service.AssertNotCalled(t, "Write", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestQueueHandlerStopWaitingAbandonsMessagesPausedByTheBreaker(t *testing.T) {
+	service := new(mockAnnotationsService)
+	service.On("Write", "uuid", annotationLifecycle, platformVersion, "tid", mock.Anything).Return(errNeo4jTimeout).Once()
+	neoBreaker := breaker.New(1, time.Hour, nil)
+	assert.Error(t, neoBreaker.Service(service).Write("uuid", annotationLifecycle, platformVersion, "tid", nil))
+	state, _ := neoBreaker.State()
+	assert.Equal(t, breaker.Open, state)
+
+	qh := newBackgroundQueueHandler(neoBreaker.Service(service))
+	qh.breaker = neoBreaker
+	qh.Ingest()
+
+	assertAbandonedAtShutdown(t, qh, "the circuit breaker")
+	service.AssertNumberOfCalls(t, "Write", 1)
+}
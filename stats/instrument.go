@@ -0,0 +1,114 @@
+package stats
+
+import (
+	"context"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/forwarder"
+
+	"github.com/Financial-Times/neo-utils-go/neoutils"
+	"github.com/jmcvetta/neoism"
+)
+
+// Service wraps the annotations service to measure its writes, reads and deletes. Annotations the service rejects
+// count as validation failures, with the reason unsupported_predicate or invalid_annotation.
+func (m *Metrics) Service(s annotations.Service) annotations.Service {
+	if m == nil {
+		return s
+	}
+	return instrumentedService{Service: s, m: m}
+}
+
+type instrumentedService struct {
+	annotations.Service
+	m *Metrics
+}
+
+func (s instrumentedService) WithContext(ctx context.Context) annotations.Service {
+	return instrumentedService{Service: annotations.WithContext(ctx, s.Service), m: s.m}
+}
+
+func (s instrumentedService) Write(contentUUID string, annotationLifecycle string, platformVersion string, tid string, thing interface{}) error {
+	start := time.Now()
+	err := s.Service.Write(contentUUID, annotationLifecycle, platformVersion, tid, thing)
+	s.m.operationDuration.WithLabelValues("write", annotationLifecycle).Observe(time.Since(start).Seconds())
+
+	if err == annotations.UnsupportedPredicateErr {
+		s.m.ValidationFailed("unsupported_predicate")
+	} else if _, ok := err.(annotations.ValidationError); ok {
+		s.m.ValidationFailed("invalid_annotation")
+	} else if err == nil {
+		anns, _ := thing.(annotations.Annotations)
+		for _, a := range anns {
+			predicate, _ := annotations.PredicateName(a.Thing.Predicate)
+			s.m.annotationsWritten.WithLabelValues(annotationLifecycle, predicate).Inc()
+		}
+	}
+	return err
+}
+
+func (s instrumentedService) Read(contentUUID string, tid string, annotationLifecycle string, filter annotations.ReadFilter) (interface{}, bool, error) {
+	start := time.Now()
+	defer func() {
+		s.m.operationDuration.WithLabelValues("read", annotationLifecycle).Observe(time.Since(start).Seconds())
+	}()
+	return s.Service.Read(contentUUID, tid, annotationLifecycle, filter)
+}
+
+func (s instrumentedService) Delete(contentUUID string, tid string, annotationLifecycle string) (bool, error) {
+	start := time.Now()
+	defer func() {
+		s.m.operationDuration.WithLabelValues("delete", annotationLifecycle).Observe(time.Since(start).Seconds())
+	}()
+	return s.Service.Delete(contentUUID, tid, annotationLifecycle)
+}
+
+// Neo4j wraps a Neo4j connection to measure the size of its batches and count those that fail
+func (m *Metrics) Neo4j(conn neoutils.NeoConnection) neoutils.NeoConnection {
+	if m == nil {
+		return conn
+	}
+	return instrumentedConnection{NeoConnection: conn, m: m}
+}
+
+type instrumentedConnection struct {
+	neoutils.NeoConnection
+	m *Metrics
+}
+
+func (c instrumentedConnection) CypherBatch(queries []*neoism.CypherQuery) error {
+	c.m.neoBatchSize.Observe(float64(len(queries)))
+	err := c.NeoConnection.CypherBatch(queries)
+	if err != nil {
+		c.m.neoBatchErrors.Inc()
+	}
+	return err
+}
+
+// Forwarder wraps a forwarder to count the messages forwarded and those that failed
+func (m *Metrics) Forwarder(f forwarder.QueueForwarder) forwarder.QueueForwarder {
+	if m == nil {
+		return f
+	}
+	return instrumentedForwarder{QueueForwarder: f, m: m}
+}
+
+type instrumentedForwarder struct {
+	forwarder.QueueForwarder
+	m *Metrics
+}
+
+func (f instrumentedForwarder) WithContext(ctx context.Context) forwarder.QueueForwarder {
+	return instrumentedForwarder{QueueForwarder: forwarder.WithContext(ctx, f.QueueForwarder), m: f.m}
+}
+
+func (f instrumentedForwarder) SendMessage(transactionID string, originSystem string, platformVersion string, uuid string, anns annotations.Annotations) error {
+	err := f.QueueForwarder.SendMessage(transactionID, originSystem, platformVersion, uuid, anns)
+	outcome := Forwarded
+	if err != nil {
+		outcome = Failed
+	}
+	f.m.messagesForwarded.WithLabelValues(outcome).Inc()
+	return err
+}
@@ -0,0 +1,170 @@
+// Package stats measures what the service does with annotations, for Prometheus to scrape: the latency of writes, reads
+// and deletes per lifecycle, the annotations written per predicate, validation failures, Neo4j batches and circuit breaker,
+// and the messages consumed from and forwarded to Kafka.
+package stats
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/breaker"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "annotations_rw"
+
+// Outcomes of the messages consumed and forwarded
+const (
+	Written   = "written"
+	Invalid   = "invalid"
+	Failed    = "failed"
+	Forwarded = "forwarded"
+)
+
+// Metrics holds the metrics of the service in its own registry. A nil *Metrics measures nothing.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	operationDuration  *prometheus.HistogramVec
+	annotationsWritten *prometheus.CounterVec
+	validationFailures *prometheus.CounterVec
+	neoBatchSize       prometheus.Histogram
+	neoBatchErrors     prometheus.Counter
+	breakerState       *prometheus.GaugeVec
+	cacheLookups       *prometheus.CounterVec
+	messagesConsumed   *prometheus.CounterVec
+	messagesForwarded  *prometheus.CounterVec
+	messageAge         prometheus.Histogram
+}
+
+// New returns the metrics of the service, along with the Go runtime and process metrics
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Time taken to write, read or delete the annotations of a piece of content in Neo4j.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation", "lifecycle"}),
+		annotationsWritten: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "annotations_written_total",
+			Help:      "Annotations written to Neo4j.",
+		}, []string{"lifecycle", "predicate"}),
+		validationFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "validation_failures_total",
+			Help:      "Writes rejected as invalid, by reason.",
+		}, []string{"reason"}),
+		neoBatchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "neo4j_batch_size",
+			Help:      "Number of Cypher queries sent to Neo4j in a batch.",
+			Buckets:   []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000},
+		}),
+		neoBatchErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "neo4j_batch_errors_total",
+			Help:      "Batches of Cypher queries that failed.",
+		}),
+		breakerState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "neo4j_circuit_breaker_state",
+			Help:      "State of the Neo4j circuit breaker: 1 for the current state, closed, half-open or open, and 0 for the others.",
+		}, []string{"state"}),
+		cacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_lookups_total",
+			Help:      "Lookups of annotations in the read cache, by outcome: hit or miss.",
+		}, []string{"outcome"}),
+		messagesConsumed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "kafka_messages_consumed_total",
+			Help:      "Messages consumed from Kafka, by outcome: written, invalid or failed.",
+		}, []string{"outcome"}),
+		messagesForwarded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "kafka_messages_forwarded_total",
+			Help:      "Messages forwarded to Kafka, by outcome: forwarded or failed.",
+		}, []string{"outcome"}),
+		messageAge: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "kafka_message_age_seconds",
+			Help:      "Time between the Message-Timestamp of a message consumed from Kafka and the start of its processing.",
+			Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600},
+		}),
+	}
+	m.registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		m.operationDuration,
+		m.annotationsWritten,
+		m.validationFailures,
+		m.neoBatchSize,
+		m.neoBatchErrors,
+		m.breakerState,
+		m.cacheLookups,
+		m.messagesConsumed,
+		m.messagesForwarded,
+		m.messageAge,
+	)
+	return m
+}
+
+// ValidationFailed counts a write rejected for the reason
+func (m *Metrics) ValidationFailed(reason string) {
+	if m == nil {
+		return
+	}
+	m.validationFailures.WithLabelValues(reason).Inc()
+}
+
+// Consumed counts a message consumed from Kafka with the outcome: Written, Invalid or Failed
+func (m *Metrics) Consumed(outcome string) {
+	if m == nil {
+		return
+	}
+	m.messagesConsumed.WithLabelValues(outcome).Inc()
+}
+
+// MessageAge records the age of a message consumed from Kafka when its processing starts
+func (m *Metrics) MessageAge(age time.Duration) {
+	if m == nil {
+		return
+	}
+	m.messageAge.Observe(age.Seconds())
+}
+
+// BreakerState records the current state of the Neo4j circuit breaker
+func (m *Metrics) BreakerState(state breaker.State) {
+	if m == nil {
+		return
+	}
+	for _, s := range breaker.States {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		m.breakerState.WithLabelValues(string(s)).Set(value)
+	}
+}
+
+// CacheLookup counts a lookup in the read cache, hit or missed
+func (m *Metrics) CacheLookup(hit bool) {
+	if m == nil {
+		return
+	}
+	outcome := "miss"
+	if hit {
+		outcome = "hit"
+	}
+	m.cacheLookups.WithLabelValues(outcome).Inc()
+}
+
+// ServeHTTP exposes the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
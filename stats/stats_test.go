@@ -0,0 +1,164 @@
+package stats
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/breaker"
+
+	"github.com/Financial-Times/neo-utils-go/neoutils"
+	"github.com/jmcvetta/neoism"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sampleCount returns the number of observations of the histogram with the name and label values
+func sampleCount(t *testing.T, m *Metrics, name string, labels map[string]string) uint64 {
+	families, err := m.registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != namespace+"_"+name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if labels[label.GetName()] != label.GetValue() {
+					continue metrics
+				}
+			}
+			return metric.GetHistogram().GetSampleCount()
+		}
+	}
+	return 0
+}
+
+type service struct {
+	annotations.Service
+	err error
+}
+
+func (s service) Write(contentUUID string, annotationLifecycle string, platformVersion string, tid string, thing interface{}) error {
+	return s.err
+}
+
+func (s service) Read(contentUUID string, tid string, annotationLifecycle string, filter annotations.ReadFilter) (interface{}, bool, error) {
+	return nil, false, s.err
+}
+
+func (s service) Delete(contentUUID string, tid string, annotationLifecycle string) (bool, error) {
+	return false, s.err
+}
+
+func TestService(t *testing.T) {
+	m := New()
+	anns := annotations.Annotations{
+		{Thing: annotations.Thing{ID: "http://api.ft.com/things/1"}},
+		{Thing: annotations.Thing{ID: "http://api.ft.com/things/2", Predicate: "about"}},
+		{Thing: annotations.Thing{ID: "http://api.ft.com/things/3", Predicate: "about"}},
+	}
+
+	require.NoError(t, m.Service(service{}).Write("1234", "annotations-v1", "v1", "tid_test", anns))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.annotationsWritten.WithLabelValues("annotations-v1", "mentions")), "The default predicate should count as mentions")
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.annotationsWritten.WithLabelValues("annotations-v1", "about")))
+
+	m.Service(service{err: annotations.UnsupportedPredicateErr}).Write("1234", "annotations-v1", "v1", "tid_test", anns)
+	m.Service(service{err: annotations.ValidationError{Msg: "invalid"}}).Write("1234", "annotations-v1", "v1", "tid_test", anns)
+	m.Service(service{err: errors.New("unavailable")}).Write("1234", "annotations-v1", "v1", "tid_test", anns)
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.validationFailures.WithLabelValues("unsupported_predicate")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.validationFailures.WithLabelValues("invalid_annotation")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.annotationsWritten.WithLabelValues("annotations-v1", "about")), "Failed writes should not count annotations")
+	assert.Equal(t, uint64(4), sampleCount(t, m, "operation_duration_seconds", map[string]string{"operation": "write", "lifecycle": "annotations-v1"}))
+
+	m.Service(service{}).Read("1234", "tid_test", "annotations-pac", annotations.ReadFilter{})
+	m.Service(service{}).Delete("1234", "tid_test", "annotations-pac")
+	assert.Equal(t, uint64(1), sampleCount(t, m, "operation_duration_seconds", map[string]string{"operation": "read", "lifecycle": "annotations-pac"}))
+	assert.Equal(t, uint64(1), sampleCount(t, m, "operation_duration_seconds", map[string]string{"operation": "delete", "lifecycle": "annotations-pac"}))
+}
+
+type neoConn struct {
+	neoutils.NeoConnection
+	err error
+}
+
+func (c neoConn) CypherBatch(queries []*neoism.CypherQuery) error {
+	return c.err
+}
+
+func TestNeo4j(t *testing.T) {
+	m := New()
+	queries := []*neoism.CypherQuery{{}, {}, {}}
+	require.NoError(t, m.Neo4j(neoConn{}).CypherBatch(queries))
+	assert.Error(t, m.Neo4j(neoConn{err: errors.New("unavailable")}).CypherBatch(queries))
+
+	assert.Equal(t, uint64(2), sampleCount(t, m, "neo4j_batch_size", nil))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.neoBatchErrors))
+}
+
+type queueForwarder struct {
+	err error
+}
+
+func (f queueForwarder) SendMessage(transactionID string, originSystem string, platformVersion string, uuid string, anns annotations.Annotations) error {
+	return f.err
+}
+
+func TestForwarder(t *testing.T) {
+	m := New()
+	require.NoError(t, m.Forwarder(queueForwarder{}).SendMessage("tid_test", "origin", "v1", "1234", nil))
+	assert.Error(t, m.Forwarder(queueForwarder{err: errors.New("unavailable")}).SendMessage("tid_test", "origin", "v1", "1234", nil))
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.messagesForwarded.WithLabelValues(Forwarded)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.messagesForwarded.WithLabelValues(Failed)))
+}
+
+func TestDisabled(t *testing.T) {
+	var m *Metrics
+	s := service{}
+	assert.Equal(t, s, m.Service(s))
+	conn := neoConn{}
+	assert.Equal(t, conn, m.Neo4j(conn))
+	f := queueForwarder{}
+	assert.Equal(t, f, m.Forwarder(f))
+	m.ValidationFailed("schema")
+	m.Consumed(Written)
+	m.MessageAge(time.Second)
+	m.BreakerState(breaker.Open)
+	m.CacheLookup(true)
+}
+
+func TestBreakerState(t *testing.T) {
+	m := New()
+	m.BreakerState(breaker.Open)
+	m.BreakerState(breaker.HalfOpen)
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.breakerState.WithLabelValues("half-open")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(m.breakerState.WithLabelValues("open")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(m.breakerState.WithLabelValues("closed")))
+}
+
+func TestCacheLookup(t *testing.T) {
+	m := New()
+	m.CacheLookup(true)
+	m.CacheLookup(true)
+	m.CacheLookup(false)
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.cacheLookups.WithLabelValues("hit")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.cacheLookups.WithLabelValues("miss")))
+}
+
+func TestServeHTTP(t *testing.T) {
+	m := New()
+	m.Consumed(Invalid)
+	m.MessageAge(90 * time.Second)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `annotations_rw_kafka_messages_consumed_total{outcome="invalid"} 1`)
+	assert.Contains(t, w.Body.String(), `annotations_rw_kafka_message_age_seconds_bucket{le="300"} 1`)
+	assert.Contains(t, w.Body.String(), "go_goroutines")
+}
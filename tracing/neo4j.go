@@ -0,0 +1,41 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/Financial-Times/neo-utils-go/neoutils"
+
+	"github.com/jmcvetta/neoism"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// BatchSizeKey is the attribute of the number of Cypher queries in a batch
+const BatchSizeKey = attribute.Key("neo4j.batch_size")
+
+// Connection traces each CypherBatch of a Neo4j connection as a child of the span of its context
+type Connection struct {
+	neoutils.NeoConnection
+	ctx context.Context
+}
+
+// Neo4j returns the connection tracing its batches
+func Neo4j(conn neoutils.NeoConnection) *Connection {
+	return &Connection{NeoConnection: conn, ctx: context.Background()}
+}
+
+// WithContext returns the connection tracing its batches as part of the span of ctx
+func (c *Connection) WithContext(ctx context.Context) neoutils.NeoConnection {
+	return &Connection{NeoConnection: c.NeoConnection, ctx: ctx}
+}
+
+// CypherBatch runs the queries in a span of their own
+func (c *Connection) CypherBatch(queries []*neoism.CypherQuery) (err error) {
+	_, span := Start(c.ctx, "neo4j.CypherBatch", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemNeo4j,
+		BatchSizeKey.Int(len(queries)),
+	))
+	defer func() { End(span, err) }()
+	return c.NeoConnection.CypherBatch(queries)
+}
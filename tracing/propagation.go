@@ -0,0 +1,43 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware traces the requests to a mux router, taking the trace context from the traceparent header. Spans are
+// named after the method and route of the request, such as PUT /content/{uuid}/annotations/{annotationLifecycle}.
+func Middleware(next http.Handler) http.Handler {
+	traced := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace.SpanFromContext(r.Context()).SetAttributes(TransactionID(transactionidutils.GetTransactionIDFromRequest(r)))
+		next.ServeHTTP(w, r)
+	})
+	return otelhttp.NewMiddleware("annotations-rw", otelhttp.WithSpanNameFormatter(routeName))(traced)
+}
+
+func routeName(operation string, r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return r.Method + " " + template
+		}
+	}
+	return r.Method + " " + operation
+}
+
+// Extract returns a context carrying the trace context of the headers of a Kafka message
+func Extract(ctx context.Context, headers map[string]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(headers))
+}
+
+// Inject adds the trace context of ctx to the headers of a Kafka message
+func Inject(ctx context.Context, headers map[string]string) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(headers))
+}
@@ -0,0 +1,88 @@
+// Package tracing traces requests and messages with OpenTelemetry, from the HTTP handlers and the Kafka consumer down
+// to the Neo4j batches and the messages forwarded. Spans carry the transaction ID of the request or message they are
+// part of, and are exported with OTLP, or to stdout for local runs.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/Financial-Times/annotations-rw-neo4j/v4"
+
+// Exporters the spans can be sent to
+const (
+	OTLP   = "otlp"
+	Stdout = "stdout"
+)
+
+// TransactionIDKey is the attribute linking a span to the transaction ID of its request or message
+const TransactionIDKey = attribute.Key("transaction_id")
+
+// TransactionID returns the transaction ID attribute of a span
+func TransactionID(tid string) attribute.KeyValue {
+	return TransactionIDKey.String(tid)
+}
+
+// Setup registers the W3C trace context propagator, so traces carry on through the service, and the tracer provider
+// exporting spans of the service to the exporter: otlp, configured by the standard OTEL_EXPORTER_OTLP_* variables, or
+// stdout. No spans are recorded when the exporter is empty. The function returned flushes the spans left.
+func Setup(ctx context.Context, exporter string, serviceName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "":
+		return nil, nil
+	case OTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	case Stdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q, it should be otlp or stdout", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s tracing exporter: %w", exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(spanExporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span of the service, as a child of the span in the context if any
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// End records the error, if any, on the span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Detach returns a context carrying the span of ctx but not its cancellation, for work that outlives a request to be
+// traced as part of it
+func Detach(ctx context.Context) context.Context {
+	return trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+}
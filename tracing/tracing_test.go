@@ -0,0 +1,134 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Financial-Times/neo-utils-go/neoutils"
+
+	"github.com/gorilla/mux"
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// record records the spans ended until the test is cleaned up
+func record(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+	return recorder
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[string]string {
+	values := map[string]string{}
+	for _, kv := range span.Attributes() {
+		values[string(kv.Key)] = kv.Value.Emit()
+	}
+	return values
+}
+
+func TestSetup(t *testing.T) {
+	flush, err := Setup(context.Background(), "", "annotations-rw")
+	require.NoError(t, err)
+	assert.Nil(t, flush, "Nothing should be exported when tracing is disabled")
+
+	_, err = Setup(context.Background(), "zipkin", "annotations-rw")
+	assert.Error(t, err)
+
+	flush, err = Setup(context.Background(), Stdout, "annotations-rw")
+	require.NoError(t, err)
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+	assert.NoError(t, flush(context.Background()))
+}
+
+func TestMiddleware(t *testing.T) {
+	recorder := record(t)
+	r := mux.NewRouter()
+	r.Use(Middleware)
+	r.HandleFunc("/content/{uuid}/annotations/{annotationLifecycle}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "validate")
+		span.End()
+		w.WriteHeader(http.StatusCreated)
+	}).Methods("PUT")
+
+	req := httptest.NewRequest("PUT", "/content/1234/annotations/annotations-v1", nil)
+	req.Header.Set("traceparent", traceparent)
+	req.Header.Set("X-Request-Id", "tid_test")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	validate, request := spans[0], spans[1]
+	assert.Equal(t, "PUT /content/{uuid}/annotations/{annotationLifecycle}", request.Name())
+	assert.Equal(t, trace.SpanKindServer, request.SpanKind())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", request.SpanContext().TraceID().String(), "The trace should carry on from the traceparent header")
+	assert.Equal(t, "00f067aa0ba902b7", request.Parent().SpanID().String())
+	assert.Equal(t, "tid_test", attributes(request)[string(TransactionIDKey)])
+	assert.Equal(t, request.SpanContext().SpanID(), validate.Parent().SpanID())
+}
+
+func TestKafkaHeaders(t *testing.T) {
+	record(t)
+	ctx := Extract(context.Background(), map[string]string{"X-Request-Id": "tid_test", "traceparent": traceparent})
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", trace.SpanContextFromContext(ctx).TraceID().String())
+
+	ctx, span := Start(ctx, "process")
+	defer span.End()
+	headers := map[string]string{}
+	Inject(ctx, headers)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.SpanContext().SpanID().String()+"-01", headers["traceparent"])
+}
+
+type neoConn struct {
+	neoutils.NeoConnection
+	err error
+}
+
+func (c neoConn) CypherBatch(queries []*neoism.CypherQuery) error {
+	return c.err
+}
+
+func TestNeo4j(t *testing.T) {
+	recorder := record(t)
+	ctx, parent := Start(context.Background(), "request")
+	conn := Neo4j(neoConn{err: errors.New("unavailable")}).WithContext(ctx)
+	assert.Error(t, conn.CypherBatch([]*neoism.CypherQuery{{}, {}}))
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	batch := spans[0]
+	assert.Equal(t, "neo4j.CypherBatch", batch.Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), batch.Parent().SpanID())
+	assert.Equal(t, "2", attributes(batch)[string(BatchSizeKey)])
+	assert.Equal(t, codes.Error, batch.Status().Code)
+
+	assert.NoError(t, Neo4j(neoConn{}).CypherBatch(nil), "Batches without a context should be traced on their own")
+	assert.False(t, recorder.Ended()[2].Parent().IsValid())
+}
+
+func TestDetach(t *testing.T) {
+	record(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx, span := Start(ctx, "request")
+	span.End()
+	cancel()
+
+	detached := Detach(ctx)
+	assert.NoError(t, detached.Err())
+	assert.Equal(t, span.SpanContext(), trace.SpanContextFromContext(detached))
+}
@@ -0,0 +1,108 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+
+	logger "github.com/Financial-Times/go-logger/v2"
+)
+
+// Config is the content of the webhooks configuration file: the subscribers and how deliveries are retried.
+// Durations are in seconds; the zero values get the defaults.
+type Config struct {
+	Subscribers           []Subscriber `json:"subscribers"`
+	MaxAttempts           int          `json:"maxAttempts"`
+	InitialBackoffSeconds float64      `json:"initialBackoffSeconds"`
+	MaxBackoffSeconds     float64      `json:"maxBackoffSeconds"`
+	TimeoutSeconds        float64      `json:"timeoutSeconds"`
+	QueueSize             int          `json:"queueSize"`
+}
+
+// Defaults of the delivery options
+const (
+	DefaultMaxAttempts    = 8
+	DefaultInitialBackoff = time.Second
+	DefaultMaxBackoff     = 5 * time.Minute
+	DefaultTimeout        = 10 * time.Second
+	DefaultQueueSize      = 1000
+)
+
+// LoadNotifier reads the webhooks configuration file and starts the Notifier it describes
+func LoadNotifier(path string, log *logger.UPPLogger) (*Notifier, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading webhooks configuration file: %w", err)
+	}
+	var c Config
+	if err = json.Unmarshal(file, &c); err != nil {
+		return nil, fmt.Errorf("error parsing webhooks configuration file: %w", err)
+	}
+	if err = c.Validate(); err != nil {
+		return nil, err
+	}
+	return New(c.Subscribers, c.Options(), log), nil
+}
+
+// Validate checks that every subscriber has a unique id, an absolute http(s) URL, a secret and supported predicates
+func (c Config) Validate() error {
+	ids := map[string]bool{}
+	for i, s := range c.Subscribers {
+		if s.ID == "" {
+			return fmt.Errorf("webhook subscriber %d has no id", i)
+		}
+		if ids[s.ID] {
+			return fmt.Errorf("webhook subscriber %s is configured twice", s.ID)
+		}
+		ids[s.ID] = true
+
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook subscriber %s must have an absolute http or https URL", s.ID)
+		}
+		if s.Secret == "" {
+			return fmt.Errorf("webhook subscriber %s has no secret", s.ID)
+		}
+		for _, predicate := range s.Predicates {
+			if name, err := annotations.PredicateName(predicate); err != nil || name != predicate {
+				return fmt.Errorf("webhook subscriber %s filters on unsupported predicate %s", s.ID, predicate)
+			}
+		}
+	}
+	return nil
+}
+
+// Options returns the delivery options, with the defaults for the ones not configured
+func (c Config) Options() Options {
+	o := Options{
+		MaxAttempts:    c.MaxAttempts,
+		InitialBackoff: seconds(c.InitialBackoffSeconds),
+		MaxBackoff:     seconds(c.MaxBackoffSeconds),
+		Timeout:        seconds(c.TimeoutSeconds),
+		QueueSize:      c.QueueSize,
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = DefaultMaxAttempts
+	}
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = DefaultInitialBackoff
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = DefaultMaxBackoff
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTimeout
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = DefaultQueueSize
+	}
+	return o
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,363 @@
+// Package webhooks notifies HTTP subscribers of annotation changes, for consumers that cannot read the Kafka topic the
+// annotations are forwarded to. Every event is POSTed as JSON to the subscribers of its lifecycle, signed with the
+// secret of the subscriber, retried with exponential backoff and dead-lettered after too many failed attempts.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+
+	logger "github.com/Financial-Times/go-logger/v2"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Event types
+const (
+	Written = "annotations.written"
+	Deleted = "annotations.deleted"
+)
+
+// Headers of the deliveries
+const (
+	IDHeader        = "X-Webhook-Id"
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// maxDeadLetters is the number of most recent dead letters kept
+const maxDeadLetters = 100
+
+// Event is a change of the annotations of a piece of content in a lifecycle, as POSTed to subscribers.
+// The annotations are those written, limited to the predicates the subscriber filters on.
+type Event struct {
+	ID              string                  `json:"id"`
+	Type            string                  `json:"type"`
+	UUID            string                  `json:"uuid"`
+	Lifecycle       string                  `json:"annotationLifecycle"`
+	PlatformVersion string                  `json:"platformVersion"`
+	OriginSystem    string                  `json:"originSystem,omitempty"`
+	TransactionID   string                  `json:"transactionId"`
+	Time            time.Time               `json:"time"`
+	Annotations     annotations.Annotations `json:"annotations,omitempty"`
+}
+
+// Subscriber receives the events of the lifecycles it filters on, or of every lifecycle when it has none.
+// Written events only carry the annotations with the predicates it filters on, or all of them when it has none, and are
+// not delivered when none of their annotations have those predicates.
+type Subscriber struct {
+	ID         string   `json:"id"`
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret"`
+	Lifecycles []string `json:"lifecycles"`
+	Predicates []string `json:"predicates"`
+}
+
+// SubscriberStatus describes a subscriber and its deliveries, without its secret
+type SubscriberStatus struct {
+	ID           string   `json:"id"`
+	URL          string   `json:"url"`
+	Lifecycles   []string `json:"lifecycles"`
+	Predicates   []string `json:"predicates"`
+	Queued       int      `json:"queued"`
+	Delivered    int64    `json:"delivered"`
+	DeadLettered int64    `json:"deadLettered"`
+}
+
+// DeadLetter is an event that could not be delivered to a subscriber
+type DeadLetter struct {
+	Subscriber string    `json:"subscriber"`
+	Event      Event     `json:"event"`
+	Attempts   int       `json:"attempts"`
+	Error      string    `json:"error"`
+	Time       time.Time `json:"time"`
+}
+
+// Options tune the deliveries
+type Options struct {
+	// MaxAttempts is the number of deliveries of an event tried before it is dead-lettered
+	MaxAttempts int
+	// InitialBackoff is the wait after the first failed attempt, doubled after each following one up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout of each attempt
+	Timeout time.Duration
+	// QueueSize is the number of events waiting to be delivered to a subscriber, beyond which they are dead-lettered
+	QueueSize int
+}
+
+type subscription struct {
+	Subscriber
+	lifecycles   map[string]bool
+	predicates   map[string]bool
+	queue        chan Event
+	delivered    int64
+	deadLettered int64
+}
+
+// Notifier delivers events to the subscribers, each from its own queue so a failing subscriber does not hold up the
+// others. A nil *Notifier delivers nothing.
+type Notifier struct {
+	subscriptions []*subscription
+	options       Options
+	client        *http.Client
+	log           *logger.UPPLogger
+	now           func() time.Time
+
+	mu          sync.Mutex
+	deadLetters []DeadLetter
+	closed      bool
+	abort       chan struct{}
+	abortOnce   sync.Once
+	workers     sync.WaitGroup
+}
+
+// New starts delivering events to the subscribers
+func New(subscribers []Subscriber, options Options, log *logger.UPPLogger) *Notifier {
+	n := &Notifier{
+		options: options,
+		client:  &http.Client{Timeout: options.Timeout},
+		log:     log,
+		now:     time.Now,
+		abort:   make(chan struct{}),
+	}
+	for _, s := range subscribers {
+		sub := &subscription{
+			Subscriber: s,
+			lifecycles: toSet(s.Lifecycles),
+			predicates: toSet(s.Predicates),
+			queue:      make(chan Event, options.QueueSize),
+		}
+		n.subscriptions = append(n.subscriptions, sub)
+		n.workers.Add(1)
+		go n.deliverAll(sub)
+	}
+	return n
+}
+
+// Notify queues the event for the subscribers of its lifecycle. It never blocks: when the queue of a subscriber is
+// full, the event is dead-lettered for that subscriber.
+func (n *Notifier) Notify(e Event) {
+	if n == nil {
+		return
+	}
+	if e.ID == "" {
+		id, _ := uuid.NewV4()
+		e.ID = id.String()
+	}
+	if e.Time.IsZero() {
+		e.Time = n.now().UTC()
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		n.log.WithTransactionID(e.TransactionID).WithUUID(e.UUID).Warn("Webhook event dropped, the service is shutting down")
+		return
+	}
+	for _, sub := range n.subscriptions {
+		if len(sub.lifecycles) > 0 && !sub.lifecycles[e.Lifecycle] {
+			continue
+		}
+		filtered, relevant := sub.filter(e)
+		if !relevant {
+			continue
+		}
+		select {
+		case sub.queue <- filtered:
+		default:
+			n.deadLetterLocked(sub, e, 0, "queue of the subscriber is full")
+		}
+	}
+}
+
+// filter limits the annotations of the event to the predicates of the subscriber. It tells the event is not relevant
+// to the subscriber when none of the annotations written are left.
+func (s *subscription) filter(e Event) (Event, bool) {
+	if len(s.predicates) == 0 || e.Type != Written {
+		return e, true
+	}
+	filtered := annotations.Annotations{}
+	for _, a := range e.Annotations {
+		name, err := annotations.PredicateName(a.Thing.Predicate)
+		if err == nil && s.predicates[name] {
+			filtered = append(filtered, a)
+		}
+	}
+	e.Annotations = filtered
+	return e, len(filtered) > 0
+}
+
+func (n *Notifier) deliverAll(sub *subscription) {
+	defer n.workers.Done()
+	for e := range sub.queue {
+		attempts, err := n.deliverWithRetries(sub, e)
+		if err != nil {
+			n.mu.Lock()
+			n.deadLetterLocked(sub, e, attempts, err.Error())
+			n.mu.Unlock()
+			continue
+		}
+		atomic.AddInt64(&sub.delivered, 1)
+	}
+}
+
+func (n *Notifier) deliverWithRetries(sub *subscription, e Event) (int, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+
+	backoff := n.options.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-n.abort:
+			return attempt - 1, errors.New("not delivered as the service is shutting down")
+		default:
+		}
+		if err = n.deliver(sub, e, body); err == nil {
+			return attempt, nil
+		}
+		n.log.WithTransactionID(e.TransactionID).WithUUID(e.UUID).WithField("subscriber", sub.ID).WithField("attempt", attempt).WithError(err).Warn("Webhook delivery failed")
+		if attempt >= n.options.MaxAttempts {
+			return attempt, err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-n.abort:
+			timer.Stop()
+			return attempt, fmt.Errorf("%v, not retried as the service is shutting down", err)
+		}
+		if backoff *= 2; backoff > n.options.MaxBackoff {
+			backoff = n.options.MaxBackoff
+		}
+	}
+}
+
+func (n *Notifier) deliver(sub *subscription, e Event, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(n.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IDHeader, e.ID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign([]byte(sub.Secret), timestamp, body))
+	req.Header.Set("X-Request-Id", e.TransactionID)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("subscriber responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// deadLetterLocked records an event that could not be delivered. It must be called with the lock held.
+func (n *Notifier) deadLetterLocked(sub *subscription, e Event, attempts int, reason string) {
+	atomic.AddInt64(&sub.deadLettered, 1)
+	n.log.WithMonitoringEvent("WebhookDeadLetter", e.TransactionID, e.Type).WithUUID(e.UUID).WithField("subscriber", sub.ID).WithField("attempts", attempts).Error("Webhook event dead-lettered: " + reason)
+
+	n.deadLetters = append(n.deadLetters, DeadLetter{Subscriber: sub.ID, Event: e, Attempts: attempts, Error: reason, Time: n.now().UTC()})
+	if len(n.deadLetters) > maxDeadLetters {
+		n.deadLetters = n.deadLetters[len(n.deadLetters)-maxDeadLetters:]
+	}
+}
+
+// Subscribers describes the subscribers and their deliveries
+func (n *Notifier) Subscribers() []SubscriberStatus {
+	statuses := []SubscriberStatus{}
+	if n == nil {
+		return statuses
+	}
+	for _, sub := range n.subscriptions {
+		statuses = append(statuses, SubscriberStatus{
+			ID:           sub.ID,
+			URL:          sub.URL,
+			Lifecycles:   sub.Lifecycles,
+			Predicates:   sub.Predicates,
+			Queued:       len(sub.queue),
+			Delivered:    atomic.LoadInt64(&sub.delivered),
+			DeadLettered: atomic.LoadInt64(&sub.deadLettered),
+		})
+	}
+	return statuses
+}
+
+// DeadLetters returns the most recent events that could not be delivered, oldest first
+func (n *Notifier) DeadLetters() []DeadLetter {
+	if n == nil {
+		return []DeadLetter{}
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]DeadLetter{}, n.deadLetters...)
+}
+
+// Drain stops accepting events and waits for the queued ones to be delivered until the context is done, when the
+// retries stop and the events left are dead-lettered. Draining a nil *Notifier does nothing.
+func (n *Notifier) Drain(ctx context.Context) error {
+	if n == nil {
+		return nil
+	}
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		for _, sub := range n.subscriptions {
+			close(sub.queue)
+		}
+	}
+	n.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		n.abortOnce.Do(func() { close(n.abort) })
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Sign returns the signature of a delivery: sha256= followed by the hex encoded HMAC-SHA256, with the secret of the
+// subscriber, of the timestamp header and the body joined by a dot
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func toSet(values []string) map[string]bool {
+	set := map[string]bool{}
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
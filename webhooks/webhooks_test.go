@@ -0,0 +1,175 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/annotations-rw-neo4j/v4/annotations"
+
+	logger "github.com/Financial-Times/go-logger/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// subscriberServer records the deliveries it receives, failing the first failures ones
+type subscriberServer struct {
+	*httptest.Server
+	sync.Mutex
+	failures   int
+	requests   int
+	deliveries []*http.Request
+	bodies     [][]byte
+}
+
+func newSubscriberServer(failures int) *subscriberServer {
+	s := &subscriberServer{failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Lock()
+		defer s.Unlock()
+		s.requests++
+		if s.requests <= s.failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		s.deliveries = append(s.deliveries, r)
+		s.bodies = append(s.bodies, body)
+	}))
+	return s
+}
+
+var testOptions = Options{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond, Timeout: time.Second, QueueSize: 10}
+
+func writtenEvent(lifecycle string) Event {
+	return Event{
+		Type:            Written,
+		UUID:            "3a636e78-5a47-11e7-9bc8-8055f264aa8b",
+		Lifecycle:       lifecycle,
+		PlatformVersion: "v1",
+		TransactionID:   "tid_test",
+		Annotations: annotations.Annotations{
+			{Thing: annotations.Thing{ID: "http://api.ft.com/things/1", Predicate: "about"}},
+			{Thing: annotations.Thing{ID: "http://api.ft.com/things/2"}},
+		},
+	}
+}
+
+func TestNotifyDeliversSignedFilteredEvents(t *testing.T) {
+	server := newSubscriberServer(0)
+	defer server.Close()
+
+	n := New([]Subscriber{{ID: "search", URL: server.URL, Secret: "s3cret", Lifecycles: []string{"annotations-v1"}, Predicates: []string{"mentions"}}}, testOptions, logger.NewUPPInfoLogger("test"))
+	n.Notify(writtenEvent("annotations-pac"))
+	n.Notify(writtenEvent("annotations-v1"))
+	n.Notify(Event{Type: Deleted, UUID: "3a636e78-5a47-11e7-9bc8-8055f264aa8b", Lifecycle: "annotations-v1", TransactionID: "tid_delete"})
+	require.NoError(t, n.Drain(context.Background()))
+
+	require.Len(t, server.deliveries, 2, "Only the events of the subscribed lifecycle should be delivered")
+	r := server.deliveries[0]
+	assert.Equal(t, Sign([]byte("s3cret"), r.Header.Get(TimestampHeader), server.bodies[0]), r.Header.Get(SignatureHeader))
+	assert.Equal(t, "tid_test", r.Header.Get("X-Request-Id"))
+
+	var e Event
+	require.NoError(t, json.Unmarshal(server.bodies[0], &e))
+	assert.Equal(t, r.Header.Get(IDHeader), e.ID)
+	assert.Equal(t, Written, e.Type)
+	assert.Equal(t, annotations.Annotations{{Thing: annotations.Thing{ID: "http://api.ft.com/things/2"}}}, e.Annotations, "Only mentions should be delivered")
+
+	require.NoError(t, json.Unmarshal(server.bodies[1], &e))
+	assert.Equal(t, Deleted, e.Type)
+
+	assert.Equal(t, []SubscriberStatus{{ID: "search", URL: server.URL, Lifecycles: []string{"annotations-v1"}, Predicates: []string{"mentions"}, Delivered: 2}}, n.Subscribers())
+}
+
+func TestNotifySkipsEventsWithoutMatchingAnnotations(t *testing.T) {
+	server := newSubscriberServer(0)
+	defer server.Close()
+
+	n := New([]Subscriber{{ID: "search", URL: server.URL, Secret: "s3cret", Predicates: []string{"hasAuthor"}}}, testOptions, logger.NewUPPInfoLogger("test"))
+	n.Notify(writtenEvent("annotations-v1"))
+	n.Notify(Event{Type: Deleted, UUID: "3a636e78-5a47-11e7-9bc8-8055f264aa8b", Lifecycle: "annotations-v1", TransactionID: "tid_delete"})
+	require.NoError(t, n.Drain(context.Background()))
+
+	require.Len(t, server.deliveries, 1, "Written events without annotations with the predicates should not be delivered")
+	var e Event
+	require.NoError(t, json.Unmarshal(server.bodies[0], &e))
+	assert.Equal(t, Deleted, e.Type)
+	assert.Empty(t, n.DeadLetters())
+}
+
+func TestNotifyRetriesThenDeadLetters(t *testing.T) {
+	flaky := newSubscriberServer(2)
+	defer flaky.Close()
+	down := newSubscriberServer(10)
+	defer down.Close()
+
+	n := New([]Subscriber{{ID: "flaky", URL: flaky.URL, Secret: "a"}, {ID: "down", URL: down.URL, Secret: "b"}}, testOptions, logger.NewUPPInfoLogger("test"))
+	n.Notify(writtenEvent("annotations-v1"))
+	require.NoError(t, n.Drain(context.Background()))
+
+	assert.Len(t, flaky.deliveries, 1, "The event should be delivered on the third attempt")
+	assert.Equal(t, 3, down.requests)
+
+	deadLetters := n.DeadLetters()
+	require.Len(t, deadLetters, 1)
+	assert.Equal(t, "down", deadLetters[0].Subscriber)
+	assert.Equal(t, 3, deadLetters[0].Attempts)
+	assert.Equal(t, "subscriber responded with status 503", deadLetters[0].Error)
+	assert.Equal(t, int64(1), n.Subscribers()[1].DeadLettered)
+}
+
+func TestDrainStopsRetries(t *testing.T) {
+	down := newSubscriberServer(10)
+	defer down.Close()
+
+	options := testOptions
+	options.InitialBackoff = time.Hour
+	n := New([]Subscriber{{ID: "down", URL: down.URL, Secret: "a"}}, options, logger.NewUPPInfoLogger("test"))
+	n.Notify(writtenEvent("annotations-v1"))
+	n.Notify(writtenEvent("annotations-v1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, n.Drain(ctx))
+
+	deadLetters := n.DeadLetters()
+	require.Len(t, deadLetters, 2)
+	assert.Equal(t, "subscriber responded with status 503, not retried as the service is shutting down", deadLetters[0].Error)
+	assert.Equal(t, "not delivered as the service is shutting down", deadLetters[1].Error)
+	assert.Equal(t, 1, down.requests)
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := Subscriber{ID: "search", URL: "https://search.example.com/hook", Secret: "s3cret", Predicates: []string{"about"}}
+	assert.NoError(t, Config{Subscribers: []Subscriber{valid}}.Validate())
+
+	tests := []struct {
+		name   string
+		change func(s *Subscriber)
+		err    string
+	}{
+		{"no id", func(s *Subscriber) { s.ID = "" }, "webhook subscriber 0 has no id"},
+		{"relative URL", func(s *Subscriber) { s.URL = "/hook" }, "webhook subscriber search must have an absolute http or https URL"},
+		{"no secret", func(s *Subscriber) { s.Secret = "" }, "webhook subscriber search has no secret"},
+		{"unsupported predicate", func(s *Subscriber) { s.Predicates = []string{"ABOUT"} }, "webhook subscriber search filters on unsupported predicate ABOUT"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := valid
+			test.change(&s)
+			assert.EqualError(t, Config{Subscribers: []Subscriber{s}}.Validate(), test.err)
+		})
+	}
+	assert.EqualError(t, Config{Subscribers: []Subscriber{valid, valid}}.Validate(), "webhook subscriber search is configured twice")
+}
+
+func TestConfigOptionsDefaults(t *testing.T) {
+	assert.Equal(t, Options{MaxAttempts: DefaultMaxAttempts, InitialBackoff: DefaultInitialBackoff, MaxBackoff: DefaultMaxBackoff, Timeout: DefaultTimeout, QueueSize: DefaultQueueSize}, Config{}.Options())
+	assert.Equal(t, 1500*time.Millisecond, Config{InitialBackoffSeconds: 1.5}.Options().InitialBackoff)
+}